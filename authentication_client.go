@@ -64,16 +64,11 @@ func (client *AuthenticationClient) SetRegion(region string) {
 // If the authentication is successful, the AuthenticateClientResult member of the response will contain the Principal of the authenticated entity.
 func (client AuthenticationClient) AuthenticateClient(ctx context.Context, request AuthenticateClientRequest) (response AuthenticateClientResponse, err error) {
 	var ociResponse common.OCIResponse
-	policy := common.NoRetryPolicy()
-	if request.RetryPolicy() != nil {
-		policy = *request.RetryPolicy()
-	}
-
 	if !(request.OpcRetryToken != nil && *request.OpcRetryToken != "") {
 		request.OpcRetryToken = common.String(common.RetryToken())
 	}
 
-	ociResponse, err = common.Retry(ctx, request, client.authenticateClient, policy)
+	ociResponse, err = retry(ctx, request, client.authenticateClient)
 	if err != nil {
 		if ociResponse != nil {
 			response = AuthenticateClientResponse{RawResponse: ociResponse.HTTPResponse()}
@@ -112,16 +107,11 @@ func (client AuthenticationClient) authenticateClient(ctx context.Context, reque
 // If the request is successful, it returns the subset of the request groups that the entity corresponding to the Principal is a part of.
 func (client AuthenticationClient) FilterGroupMembership(ctx context.Context, request FilterGroupMembershipRequest) (response FilterGroupMembershipResponse, err error) {
 	var ociResponse common.OCIResponse
-	policy := common.NoRetryPolicy()
-	if request.RetryPolicy() != nil {
-		policy = *request.RetryPolicy()
-	}
-
 	if !(request.OpcRetryToken != nil && *request.OpcRetryToken != "") {
 		request.OpcRetryToken = common.String(common.RetryToken())
 	}
 
-	ociResponse, err = common.Retry(ctx, request, client.filterGroupMembership, policy)
+	ociResponse, err = retry(ctx, request, client.filterGroupMembership)
 	if err != nil {
 		if ociResponse != nil {
 			response = FilterGroupMembershipResponse{RawResponse: ociResponse.HTTPResponse()}
@@ -155,3 +145,13 @@ func (client AuthenticationClient) filterGroupMembership(ctx context.Context, re
 
 	return response, err
 }
+
+// retry makes the operation with the retry policy of the request. A request without a retry policy is made once,
+// directly: once its context is done, the retry goroutine of the SDK keeps writing the response that it returns,
+// which races with the caller.
+func retry(ctx context.Context, request common.OCIRetryableRequest, operation common.OCIOperation) (common.OCIResponse, error) {
+	if request.RetryPolicy() == nil {
+		return operation(ctx, request, nil, nil)
+	}
+	return common.Retry(ctx, request, operation, *request.RetryPolicy())
+}
@@ -5,10 +5,16 @@ import (
 	"context"
 	"fmt"
 	"sync"
+	"time"
 
+	lru "github.com/hashicorp/golang-lru"
 	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/helper/locksutil"
 	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/oracle/oci-go-sdk/v65/common"
 	"github.com/oracle/oci-go-sdk/v65/common/auth"
+	"github.com/oracle/oci-go-sdk/v65/core"
+	"github.com/oracle/oci-go-sdk/v65/identity"
 )
 
 // operationPrefixOCI is used as a prefix for OpenAPI operation id's.
@@ -32,27 +38,118 @@ type backend struct {
 	authClientMutex sync.RWMutex
 
 	// The client used to authenticate with OCI Identity
-	authenticationClient *AuthenticationClient
+	authenticationClient IdentityAuthenticator
+
+	// Lock to make changes to identityClient
+	identityClientMutex sync.Mutex
+
+	// The client used to resolve group names with OCI Identity
+	identityClient *identity.IdentityClient
+
+	// The cache of group names resolved with OCI Identity
+	groupNameCache *lru.Cache
+
+	// Lock to make changes to computeClients
+	computeClientMutex sync.Mutex
+
+	// The clients used to look up instances with the Compute API, by endpoint or region
+	computeClients map[string]*core.ComputeClient
+
+	// The configuration provider of the Compute clients. The instance principal of Vault is used if nil.
+	computeConfigurationProvider common.ConfigurationProvider
+
+	// The cache of instances looked up with the Compute API
+	instanceCache *lru.Cache
+
+	// The cache of users looked up with OCI Identity
+	userCache *lru.Cache
+
+	// The cache of compartments looked up with OCI Identity
+	compartmentCache *lru.Cache
+
+	// The cache of group membership decisions
+	membershipCache *membershipCache
+
+	// Lock to make changes to the entryCache
+	entryCacheMutex sync.RWMutex
+
+	// The cache of decoded config and role entries
+	entryCache entryCache
+
+	// The token buckets of the login rate limits
+	loginLimiters map[string]*loginLimiters
+
+	// The failed logins of keyIds and subjects, for the lockout policy
+	loginLockouts *loginLockouts
+
+	// Locks for the read-modify-write of the login ledger entries
+	principalLocks []*locksutil.LockEntry
+
+	// The logins of the principals that were not written to the ledger yet
+	principalLogins *principalLogins
+
+	// Lock held while the periodic function tidies the login ledger
+	tidyMutex sync.Mutex
+
+	// The time of the last tidy of the login ledger by the periodic function
+	lastTidy time.Time
+
+	// Lock held while the group membership of the principals is rechecked
+	recheckMutex sync.Mutex
+
+	// The time of the last complete recheck of the group membership of the principals
+	lastRecheck time.Time
 }
 
-func Backend() (*backend, error) {
-	b := &backend{}
+func Backend(opts ...BackendOption) (*backend, error) {
+	b := &backend{
+		membershipCache:  newMembershipCache(defaultMembershipCacheSize),
+		groupNameCache:   newGroupNameCache(),
+		compartmentCache: newCompartmentCache(),
+		instanceCache:    newInstanceCache(),
+		userCache:        newUserCache(),
+		principalLocks:   locksutil.CreateLocks(),
+		principalLogins:  newPrincipalLogins(pendingLoginsCacheSize),
+		loginLimiters:    newLoginLimitersByKind(loginLimiterCacheSize),
+		loginLockouts:    newLoginLockouts(lockoutCacheSize),
+		entryCache: entryCache{
+			roles: make(map[string]*OCIRoleEntry),
+		},
+	}
+
+	for _, opt := range opts {
+		opt(b)
+	}
 
 	b.Backend = &framework.Backend{
 		Help: backendHelp,
 		PathsSpecial: &logical.Paths{
 			Unauthenticated: []string{
-				"login/*",
+				"login",
+				"login/+",
+			},
+			LocalStorage: []string{
+				principalStoragePrefix,
 			},
 		},
 		Paths: []*framework.Path{
 			pathLogin(b),
 			pathLoginRole(b),
+			pathLoginVerify(b),
 			pathRole(b),
+			pathRoleRefresh(b),
 			pathListRoles(b),
 			pathConfig(b),
+			pathCachePurge(b),
+			pathListPrincipals(b),
+			pathPrincipalsTidy(b),
+			pathPrincipalsUnlock(b),
+			pathPrincipal(b),
 		},
-		BackendType: logical.TypeCredential,
+		AuthRenew:    b.pathLoginRenew,
+		Invalidate:   b.invalidate,
+		PeriodicFunc: b.periodicFunc,
+		BackendType:  logical.TypeCredential,
 	}
 
 	return b, nil
@@ -87,6 +184,24 @@ func (b *backend) createAuthClient() error {
 	return nil
 }
 
+// getAuthClient returns the authentication client, creating it if needed
+func (b *backend) getAuthClient() (IdentityAuthenticator, error) {
+	b.authClientMutex.RLock()
+	authenticationClient := b.authenticationClient
+	b.authClientMutex.RUnlock()
+	if authenticationClient != nil {
+		return authenticationClient, nil
+	}
+
+	if err := b.createAuthClient(); err != nil {
+		return nil, errAuthClientUnavailable
+	}
+
+	b.authClientMutex.RLock()
+	defer b.authClientMutex.RUnlock()
+	return b.authenticationClient, nil
+}
+
 const backendHelp = `
 The OCI Auth plugin enables authentication and authorization using OCI Identity credentials. 
 
@@ -4,6 +4,7 @@ package ociauth
 import (
 	"context"
 	"fmt"
+	"net/http"
 	"os"
 	"reflect"
 	"testing"
@@ -77,6 +78,12 @@ func initTest(t *testing.T) (backend logical.Backend, config *logical.BackendCon
 	if roleOCIDList == "" {
 		return nil, nil, fmt.Errorf("%s is not set", envVarRoleOCIDList)
 	}
+	err = createTestRoles(backend, config, roleOCIDList)
+	return
+}
+
+// createTestRoles creates the roles of the login tests, bound to the given OCIDs
+func createTestRoles(backend logical.Backend, config *logical.BackendConfig, roleOCIDList string) error {
 	// Create the devRole
 	devRoleData := map[string]interface{}{
 		"description":    DevRole + " description",
@@ -85,9 +92,8 @@ func initTest(t *testing.T) (backend logical.Backend, config *logical.BackendCon
 		"token_ttl":      1500,
 	}
 
-	err = createRole(devRoleData, DevRole, backend, config)
-	if err != nil {
-		return
+	if err := createRole(devRoleData, DevRole, backend, config); err != nil {
+		return err
 	}
 
 	// Create the opsRole
@@ -96,11 +102,11 @@ func initTest(t *testing.T) (backend logical.Backend, config *logical.BackendCon
 		"ocid_list":      "",
 		"token_policies": "policy3",
 		"token_ttl":      1000,
+		"force":          true,
 	}
 
-	err = createRole(opsRoleData, OpsRole, backend, config)
-	if err != nil {
-		return
+	if err := createRole(opsRoleData, OpsRole, backend, config); err != nil {
+		return err
 	}
 
 	// Create the knowledgeWorkerRole
@@ -111,12 +117,7 @@ func initTest(t *testing.T) (backend logical.Backend, config *logical.BackendCon
 		"token_ttl":      1000,
 	}
 
-	err = createRole(knowledgeWorkerRole, KnowledgeWorkerRole, backend, config)
-	if err != nil {
-		return
-	}
-
-	return
+	return createRole(knowledgeWorkerRole, KnowledgeWorkerRole, backend, config)
 }
 
 func createRole(roleData map[string]interface{}, roleName string, backend logical.Backend, config *logical.BackendConfig) error {
@@ -146,13 +147,13 @@ func TestBackEnd_ValidateUserApiKeyLogin(t *testing.T) {
 		"auth_type": "apikey",
 		"role":      DevRole,
 	}
-	makeRequestAndValidateResponse(t, cmdMap, false, 1500*time.Second, []string{"policy1", "policy2"})
+	makeRequestAndValidateResponse(t, cmdMap, "", 1500*time.Second, []string{"policy1", "policy2"})
 
 	cmdMap = map[string]string{
 		"auth_type": "apikey",
 		"role":      KnowledgeWorkerRole,
 	}
-	makeRequestAndValidateResponse(t, cmdMap, false, 1000*time.Second, []string{"policy1", "policy5"})
+	makeRequestAndValidateResponse(t, cmdMap, "", 1000*time.Second, []string{"policy1", "policy5"})
 }
 
 func TestBackEnd_ValidateUserApiKeyLoginNotInRole(t *testing.T) {
@@ -166,7 +167,7 @@ func TestBackEnd_ValidateUserApiKeyLoginNotInRole(t *testing.T) {
 		"auth_type": "apikey",
 		"role":      OpsRole,
 	}
-	makeRequestAndValidateResponse(t, cmdMap, true, 1500*time.Second, []string{})
+	makeRequestAndValidateResponse(t, cmdMap, LoginErrorNotInGroup, 1500*time.Second, []string{})
 }
 
 func TestBackEnd_ValidateUserApiKeyLoginNonExistentRole(t *testing.T) {
@@ -180,7 +181,7 @@ func TestBackEnd_ValidateUserApiKeyLoginNonExistentRole(t *testing.T) {
 		"auth_type": "apikey",
 		"role":      NonExistentRole,
 	}
-	makeRequestAndValidateResponse(t, cmdMap, true, 1500*time.Second, []string{})
+	makeRequestAndValidateResponse(t, cmdMap, LoginErrorInvalidRole, 1500*time.Second, []string{})
 }
 
 func TestBackEnd_ValidateInstancePrincipalLogin(t *testing.T) {
@@ -194,13 +195,13 @@ func TestBackEnd_ValidateInstancePrincipalLogin(t *testing.T) {
 		"auth_type": "ip",
 		"role":      DevRole,
 	}
-	makeRequestAndValidateResponse(t, cmdMap, false, 1500*time.Second, []string{"policy1", "policy2"})
+	makeRequestAndValidateResponse(t, cmdMap, "", 1500*time.Second, []string{"policy1", "policy2"})
 
 	cmdMap = map[string]string{
 		"auth_type": "ip",
 		"role":      KnowledgeWorkerRole,
 	}
-	makeRequestAndValidateResponse(t, cmdMap, false, 1000*time.Second, []string{"policy1", "policy5"})
+	makeRequestAndValidateResponse(t, cmdMap, "", 1000*time.Second, []string{"policy1", "policy5"})
 }
 
 func TestBackEnd_ValidateInstancePrincipalLoginNotInRole(t *testing.T) {
@@ -214,7 +215,7 @@ func TestBackEnd_ValidateInstancePrincipalLoginNotInRole(t *testing.T) {
 		"auth_type": "ip",
 		"role":      OpsRole,
 	}
-	makeRequestAndValidateResponse(t, cmdMap, true, 1500*time.Second, []string{})
+	makeRequestAndValidateResponse(t, cmdMap, LoginErrorNotInGroup, 1500*time.Second, []string{})
 }
 
 func TestBackEnd_ValidateInstancePrincipalLoginNonExistentRole(t *testing.T) {
@@ -228,10 +229,12 @@ func TestBackEnd_ValidateInstancePrincipalLoginNonExistentRole(t *testing.T) {
 		"auth_type": "ip",
 		"role":      NonExistentRole,
 	}
-	makeRequestAndValidateResponse(t, cmdMap, true, 1500*time.Second, []string{})
+	makeRequestAndValidateResponse(t, cmdMap, LoginErrorInvalidRole, 1500*time.Second, []string{})
 }
 
-func makeRequestAndValidateResponse(t *testing.T, cmdMap map[string]string, expectFailure bool, expectedTTL time.Duration, expectedPolicies []string) {
+// makeRequestAndValidateResponse logs in with cmdMap. An empty expectedErrorCode expects the login to succeed with
+// expectedTTL and expectedPolicies, otherwise the login is expected to fail with that code and its status code.
+func makeRequestAndValidateResponse(t *testing.T, cmdMap map[string]string, expectedErrorCode string, expectedTTL time.Duration, expectedPolicies []string) {
 	t.Helper()
 
 	role := cmdMap["role"]
@@ -261,19 +264,16 @@ func makeRequestAndValidateResponse(t *testing.T, cmdMap map[string]string, expe
 		t.Fatalf("Test failed, got error: resp:%#v\n err:%v", response, err)
 	}
 
-	if response == nil || (response != nil && response.IsError()) {
-		if expectFailure {
-			return
-		} else {
-			t.Fatalf("Test failure: unexpected response: %#v\n", response)
-		}
-	} else {
-		if expectFailure {
-			if response.Data["http_status_code"] != 401 {
-				t.Fatalf("Expected failure, but the request succeeded. Test Failed. Response: %#v\n", response)
-			}
-			return
+	if expectedErrorCode != "" {
+		statusCode, code := loginErrorCode(t, response)
+		if code != expectedErrorCode || statusCode != loginErrorStatusCodes[expectedErrorCode] {
+			t.Fatalf("Expected error %q with status %d, got %q with status %d: %#v\n", expectedErrorCode, loginErrorStatusCodes[expectedErrorCode], code, statusCode, response)
 		}
+		return
+	}
+
+	if response == nil || response.IsError() || response.Data[logical.HTTPStatusCode] != nil {
+		t.Fatalf("Test failure: unexpected response: %#v\n", response)
 	}
 
 	if response == nil || response.Auth == nil {
@@ -295,3 +295,156 @@ func makeRequestAndValidateResponse(t *testing.T, cmdMap map[string]string, expe
 		t.Fatalf("Failed Policy Comparison! Expected Policies: %#v Got Policies: %#v resp: %#v\n", expectedPolicies, response.Auth.Policies, response)
 	}
 }
+
+// initOfflineTest creates a backend authenticating with the fake Identity server, with the roles of the login tests
+func initOfflineTest(t *testing.T, fake *fakeIdentity, roleOCIDList string) (*backend, *logical.BackendConfig) {
+	t.Helper()
+
+	config := &logical.BackendConfig{
+		System: &logical.StaticSystemView{
+			DefaultLeaseTTLVal: time.Hour * 24,
+			MaxLeaseTTLVal:     time.Hour * 24 * 2,
+		},
+		StorageView: &logical.InmemStorage{},
+	}
+
+	b, err := Backend(WithIdentityAuthenticator(fake.client(t)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Setup(context.Background(), config); err != nil {
+		t.Fatal(err)
+	}
+
+	resp, err := b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.CreateOperation,
+		Path:      "config",
+		Storage:   config.StorageView,
+		Data: map[string]interface{}{
+			HomeTenancyIdConfigName: testTenancyId,
+		},
+	})
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("Config creation failed. resp:%#v\n err:%v", resp, err)
+	}
+
+	if err := createTestRoles(b, config, roleOCIDList); err != nil {
+		t.Fatal(err)
+	}
+	return b, config
+}
+
+func TestBackEnd_OfflineLogin(t *testing.T) {
+	roleGroup := "ocid1.group.oc1..rolegroup"
+
+	for _, principalType := range []string{PrincipalTypeUser, PrincipalTypeInstance} {
+		t.Run(principalType, func(t *testing.T) {
+			fake := newFakeIdentity(t)
+			b, config := initOfflineTest(t, fake, roleGroup+",ocid1.group.oc1..othergroup")
+
+			login := func(role string) *logical.Response {
+				headers, keyId := signedAPIKeyHeaders(t, PathVersionBase+fmt.Sprintf(PathBaseFormat, "oci", role))
+				subjectId := "ocid1." + principalType + ".oc1..testsubject"
+				fake.addPrincipal(keyId, newFakePrincipal(subjectId, principalType, nil), roleGroup)
+
+				resp, err := b.HandleRequest(context.Background(), &logical.Request{
+					Operation: logical.UpdateOperation,
+					Path:      "login/" + role,
+					Storage:   config.StorageView,
+					Data: map[string]interface{}{
+						"request_headers": headers,
+					},
+				})
+				if err != nil {
+					t.Fatalf("login failed: %v", err)
+				}
+				return resp
+			}
+
+			for role, expected := range map[string]struct {
+				ttl      time.Duration
+				policies []string
+			}{
+				DevRole:             {1500 * time.Second, []string{"policy1", "policy2"}},
+				KnowledgeWorkerRole: {1000 * time.Second, []string{"policy1", "policy5"}},
+			} {
+				resp := login(role)
+				if resp == nil || resp.Auth == nil {
+					t.Fatalf("expected login to %s to succeed: %#v", role, resp)
+				}
+				if resp.Auth.TTL != expected.ttl || !reflect.DeepEqual(sliceToMap(resp.Auth.Policies), sliceToMap(expected.policies)) {
+					t.Fatalf("unexpected auth of %s: %#v", role, resp.Auth)
+				}
+			}
+
+			if statusCode, code := loginErrorCode(t, login(OpsRole)); statusCode != http.StatusForbidden || code != LoginErrorNotInGroup {
+				t.Fatalf("expected login to a role without OCIDs to fail: %d %s", statusCode, code)
+			}
+			if statusCode, code := loginErrorCode(t, login(NonExistentRole)); statusCode != http.StatusBadRequest || code != LoginErrorInvalidRole {
+				t.Fatalf("expected login to a nonexistent role to fail: %d %s", statusCode, code)
+			}
+		})
+	}
+}
+
+func TestBackEnd_OfflineLoginErrors(t *testing.T) {
+	roleGroup := "ocid1.group.oc1..rolegroup"
+	fake := newFakeIdentity(t)
+	b, config := initOfflineTest(t, fake, roleGroup)
+
+	headers, keyId := signedAPIKeyHeaders(t, PathVersionBase+fmt.Sprintf(PathBaseFormat, "oci", DevRole))
+	login := func() *logical.Response {
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      "login/" + DevRole,
+			Storage:   config.StorageView,
+			Data: map[string]interface{}{
+				"request_headers": headers,
+			},
+		})
+		if err != nil {
+			t.Fatalf("login failed: %v", err)
+		}
+		return resp
+	}
+
+	// The keyId is not known to Identity
+	if statusCode, code := loginErrorCode(t, login()); statusCode != http.StatusUnauthorized || code != LoginErrorSignatureRejected {
+		t.Fatalf("expected an unknown key to be rejected: %d %s", statusCode, code)
+	}
+
+	subjectId := "ocid1.user.oc1..testsubject"
+	fake.addPrincipal(keyId, newFakePrincipal(subjectId, PrincipalTypeUser, nil))
+	if statusCode, code := loginErrorCode(t, login()); statusCode != http.StatusForbidden || code != LoginErrorNotInGroup {
+		t.Fatalf("expected a principal outside of the group to be rejected: %d %s", statusCode, code)
+	}
+
+	fake.setMembership(subjectId, roleGroup)
+	if resp := login(); resp == nil || resp.Auth == nil {
+		t.Fatalf("expected login to succeed: %#v", resp)
+	}
+
+	testCases := []struct {
+		path       string
+		statusCode int
+		expected   int
+		code       string
+	}{
+		{fakeIdentityAuthenticateClient, http.StatusServiceUnavailable, http.StatusServiceUnavailable, LoginErrorIdentityUnavailable},
+		{fakeIdentityAuthenticateClient, http.StatusTooManyRequests, http.StatusTooManyRequests, LoginErrorThrottled},
+		{fakeIdentityAuthenticateClient, http.StatusUnauthorized, http.StatusUnauthorized, LoginErrorSignatureRejected},
+		{fakeIdentityFilterGroupMembership, http.StatusInternalServerError, http.StatusServiceUnavailable, LoginErrorIdentityUnavailable},
+	}
+	for _, tc := range testCases {
+		b.membershipCache.purge()
+		fake.setError(tc.path, tc.statusCode)
+		if statusCode, code := loginErrorCode(t, login()); statusCode != tc.expected || code != tc.code {
+			t.Fatalf("unexpected error for %d from %s: %d %s", tc.statusCode, tc.path, statusCode, code)
+		}
+		fake.setError(tc.path, 0)
+	}
+
+	if fake.requestCount(fakeIdentityAuthenticateClient) != 7 {
+		t.Fatalf("unexpected requests: %d", fake.requestCount(fakeIdentityAuthenticateClient))
+	}
+}
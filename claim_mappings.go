@@ -0,0 +1,155 @@
+// Copyright © 2019, Oracle and/or its affiliates.
+package ociauth
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/hashicorp/go-secure-stdlib/strutil"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+// templateVariableCompartmentName is the policy template variable of the name of the compartment of the principal,
+// which is looked up with Identity
+const templateVariableCompartmentName = "compartment_name"
+
+var (
+	// policyTemplateVariablePattern matches the variables of policy templates, such as {{compartment_name}}
+	policyTemplateVariablePattern = regexp.MustCompile(`\{\{\s*([A-Za-z0-9_.-]+)\s*\}\}`)
+
+	// metadataNamePattern restricts the metadata names of claim_mappings
+	metadataNamePattern = regexp.MustCompile(`^[A-Za-z0-9_.-]+$`)
+)
+
+// reservedMetadataNames are the token metadata set by logins, which claim_mappings can not overwrite
+var reservedMetadataNames = []string{"role_name", "role_names", "matched_group_ids",
+	"user_name", "user_email", "user_identity_provider_id", "user_external_identifier"}
+
+// isInstanceTagName returns true if the metadata name is one of the tags of an instance, which are set by logins
+func isInstanceTagName(name string) bool {
+	return strings.HasPrefix(name, definedTagClaimPrefix) || strings.HasPrefix(name, freeformTagClaimPrefix)
+}
+
+// validateClaimMappings validates the claim_mappings field, which maps claim keys to token metadata names
+func validateClaimMappings(claimMappings map[string]string) error {
+	metadataNames := make(map[string]string, len(claimMappings))
+	for claim, name := range claimMappings {
+		if claim == "" {
+			return fmt.Errorf("claim_mappings contains an empty claim")
+		}
+		if !metadataNamePattern.MatchString(name) {
+			return fmt.Errorf("invalid metadata name %q for the claim %q in claim_mappings", name, claim)
+		}
+		if strutil.StrListContains(reservedMetadataNames, name) || name == templateVariableCompartmentName || isInstanceTagName(name) {
+			return fmt.Errorf("the metadata name %q of the claim %q in claim_mappings is reserved", name, claim)
+		}
+		if other, ok := metadataNames[name]; ok {
+			return fmt.Errorf("the claims %q and %q are mapped to the same metadata name %q", other, claim, name)
+		}
+		metadataNames[name] = claim
+	}
+	return nil
+}
+
+// parsePolicyTemplates validates the token_policy_templates field. The variables of the templates must be metadata
+// names of claim_mappings, or compartment_name.
+func parsePolicyTemplates(templates []string, claimMappings map[string]string) ([]string, error) {
+	variables := map[string]bool{templateVariableCompartmentName: true}
+	for _, name := range claimMappings {
+		variables[name] = true
+	}
+
+	templates = strutil.RemoveDuplicatesStable(templates, true)
+	for _, template := range templates {
+		withoutVariables := policyTemplateVariablePattern.ReplaceAllString(template, "")
+		if strings.Contains(withoutVariables, "{{") || strings.Contains(withoutVariables, "}}") {
+			return nil, fmt.Errorf("invalid template %q in token_policy_templates", template)
+		}
+		for _, match := range policyTemplateVariablePattern.FindAllStringSubmatch(template, -1) {
+			if !variables[match[1]] {
+				return nil, fmt.Errorf("unknown variable %q in the template %q of token_policy_templates, expected %s or a metadata name of claim_mappings", match[1], template, templateVariableCompartmentName)
+			}
+		}
+	}
+	return templates, nil
+}
+
+// claimMetadata returns the token metadata that claim_mappings maps from the claims of the principal.
+// Claims that the principal does not have are left out.
+func (roleEntry *OCIRoleEntry) claimMetadata(claims InternalClaims) map[string]string {
+	metadata := make(map[string]string, len(roleEntry.ClaimMappings))
+	for claim, name := range roleEntry.ClaimMappings {
+		if value := claims.GetString(claim); value != "" {
+			metadata[name] = value
+		}
+	}
+	return metadata
+}
+
+// renderPolicyTemplates returns the policies of the token_policy_templates of the role for the principal.
+// A template with a variable that has no value for the principal, such as a claim the principal does not have,
+// grants no policy, so that a principal never gets a policy meant for another.
+func (b *backend) renderPolicyTemplates(ctx context.Context, req *logical.Request, roleEntry *OCIRoleEntry, claims InternalClaims) []string {
+	if len(roleEntry.TokenPolicyTemplates) == 0 {
+		return nil
+	}
+
+	values := roleEntry.claimMetadata(claims)
+	var policies []string
+	for _, template := range roleEntry.TokenPolicyTemplates {
+		complete := true
+		policy := policyTemplateVariablePattern.ReplaceAllStringFunc(template, func(variable string) string {
+			name := policyTemplateVariablePattern.FindStringSubmatch(variable)[1]
+			if _, ok := values[name]; !ok && name == templateVariableCompartmentName {
+				values[name] = b.compartmentName(ctx, req, claims)
+			}
+			if values[name] == "" {
+				complete = false
+			}
+			return values[name]
+		})
+		if !complete {
+			b.Logger().Debug("No value for a variable of a policy template", "id", req.ID, "template", template)
+			continue
+		}
+		policies = append(policies, strings.ToLower(policy))
+	}
+
+	sort.Strings(policies)
+	return strutil.RemoveDuplicates(policies, false)
+}
+
+// compartmentName returns the name of the compartment of the principal, or an empty string if the principal has no
+// compartment or its name can not be looked up
+func (b *backend) compartmentName(ctx context.Context, req *logical.Request, claims InternalClaims) string {
+	compartmentId := claims.GetString(ClaimCompartment)
+	if compartmentId == "" {
+		return ""
+	}
+	compartment, err := b.getCompartment(ctx, req.ID, compartmentId)
+	if err != nil {
+		b.Logger().Warn("Unable to look up the compartment name for the policy templates", "id", req.ID, "compartment", compartmentId, "err", err)
+		return ""
+	}
+	return compartment.name
+}
+
+// applyClaimMappings adds the tags of the instance, the attributes of the user, the metadata of claim_mappings and
+// the policies of token_policy_templates to the auth
+func (b *backend) applyClaimMappings(ctx context.Context, req *logical.Request, roleEntry *OCIRoleEntry, claims InternalClaims, auth *logical.Auth) {
+	applyUserAttributes(claims, auth)
+	for name, value := range instanceTagMetadata(claims) {
+		auth.Metadata[name] = value
+	}
+	for name, value := range roleEntry.claimMetadata(claims) {
+		auth.Metadata[name] = value
+	}
+
+	// The policies of auth are shared with the role entry, so they are copied before being extended
+	if policies := b.renderPolicyTemplates(ctx, req, roleEntry, claims); len(policies) > 0 {
+		auth.Policies = strutil.RemoveDuplicates(append(append([]string{}, auth.Policies...), policies...), false)
+	}
+}
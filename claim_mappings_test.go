@@ -0,0 +1,123 @@
+// Copyright © 2019, Oracle and/or its affiliates.
+package ociauth
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/oracle/oci-go-sdk/v65/common"
+	"github.com/oracle/oci-go-sdk/v65/identity"
+)
+
+func TestParsePolicyTemplates(t *testing.T) {
+	claimMappings := map[string]string{ClaimInstance: "instance_id"}
+
+	templates, err := parsePolicyTemplates([]string{"app-{{compartment_name}}", "host-{{ instance_id }}", "APP-{{compartment_name}}"}, claimMappings)
+	if err != nil || !reflect.DeepEqual(templates, []string{"app-{{compartment_name}}", "host-{{ instance_id }}"}) {
+		t.Fatalf("unexpected templates: %#v %v", templates, err)
+	}
+
+	invalidTemplates := []string{"app-{{tenancy_name}}", "app-{{compartment_name}", "app-}}", "app-{{}}"}
+	for _, template := range invalidTemplates {
+		if _, err := parsePolicyTemplates([]string{template}, claimMappings); err == nil {
+			t.Fatalf("expected %q to be rejected", template)
+		}
+	}
+
+	invalidMappings := []map[string]string{
+		{ClaimInstance: "role_name"},
+		{ClaimInstance: "compartment_name"},
+		{ClaimInstance: "with space"},
+		{ClaimInstance: "id", ClaimCompartment: "id"},
+		{"": "id"},
+	}
+	for _, claimMappings := range invalidMappings {
+		if err := validateClaimMappings(claimMappings); err == nil {
+			t.Fatalf("expected %v to be rejected", claimMappings)
+		}
+	}
+}
+
+func TestBackend_ClaimMappings(t *testing.T) {
+	var compartmentRequests int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&compartmentRequests, 1)
+		w.Header().Set("Content-Type", "application/json")
+		segments := strings.Split(r.URL.Path, "/")
+		if ocid := segments[len(segments)-1]; ocid != testCompartmentId {
+			t.Errorf("unexpected compartment: %s", ocid)
+		}
+		json.NewEncoder(w).Encode(identity.Compartment{Id: common.String(testCompartmentId), Name: common.String("Payments")})
+	}))
+	defer server.Close()
+
+	b, config, login := newTestLocalLoginBackend(t, nil)
+	b.identityClient = newTestIdentityClient(t, server.URL)
+
+	writeRole := func(data map[string]interface{}) *logical.Response {
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.CreateOperation,
+			Path:      "role/approle",
+			Storage:   config.StorageView,
+			Data:      data,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp
+	}
+
+	resp := writeRole(map[string]interface{}{
+		"bound_compartment_ids":  testCompartmentId,
+		"token_policies":         "default",
+		"claim_mappings":         map[string]interface{}{ClaimCompartment: "compartment_id", ClaimInstance: "instance_id"},
+		"token_policy_templates": "app-{{compartment_name}},host-{{instance_id}}",
+	})
+	if resp != nil {
+		t.Fatalf("unexpected response: %#v", resp)
+	}
+
+	for i := 0; i < 2; i++ {
+		resp = login("approle")
+		if resp == nil || resp.Auth == nil {
+			t.Fatalf("expected login to succeed: %#v", resp)
+		}
+	}
+	if resp.Auth.Metadata["compartment_id"] != testCompartmentId || resp.Auth.Metadata["instance_id"] != testInstanceId || resp.Auth.Metadata["role_name"] != "approle" {
+		t.Fatalf("unexpected metadata: %#v", resp.Auth.Metadata)
+	}
+	policies := append([]string{}, resp.Auth.Policies...)
+	sort.Strings(policies)
+	if expected := []string{"app-payments", "default", "host-" + testInstanceId}; !reflect.DeepEqual(policies, expected) {
+		t.Fatalf("unexpected policies: %#v", policies)
+	}
+	if atomic.LoadInt32(&compartmentRequests) != 1 {
+		t.Fatalf("expected the compartment name to be cached: %d", compartmentRequests)
+	}
+
+	// A template can not use a metadata name that claim_mappings no longer has
+	if resp := writeRole(map[string]interface{}{"claim_mappings": map[string]interface{}{}}); resp == nil || !resp.IsError() {
+		t.Fatalf("expected the role to be rejected: %#v", resp)
+	}
+
+	resp, err := b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      "role/approle",
+		Storage:   config.StorageView,
+	})
+	if err != nil || resp == nil || resp.IsError() {
+		t.Fatalf("Role read failed. resp:%#v\n err:%v", resp, err)
+	}
+	if !reflect.DeepEqual(resp.Data["claim_mappings"], map[string]string{ClaimCompartment: "compartment_id", ClaimInstance: "instance_id"}) ||
+		!reflect.DeepEqual(resp.Data["token_policy_templates"], []string{"app-{{compartment_name}}", "host-{{instance_id}}"}) {
+		t.Fatalf("unexpected role: %#v", resp.Data)
+	}
+}
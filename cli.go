@@ -2,10 +2,12 @@
 package ociauth
 
 import (
+	"encoding/json"
 	"fmt"
 	"net/http"
 	"net/url"
 	"strings"
+	"time"
 
 	"github.com/hashicorp/vault/api"
 
@@ -40,6 +42,9 @@ Usage: vault login -method=oci auth_type=apikey
 		
 		$ vault login -method=oci auth_type=instance role=<RoleName>
 
+  The role may be omitted if role-less login is enabled on the auth method,
+  in which case the role is chosen from the roles the principal matches.
+
 Configuration:
   auth_type=<string>
       Enter one of following: 
@@ -56,13 +61,11 @@ func (h *CLIHandler) Auth(c *api.Client, m map[string]string) (*api.Secret, erro
 	}
 	mount = strings.TrimSuffix(mount, "/")
 
-	role, ok := m["role"]
-	if !ok {
-		return nil, fmt.Errorf("'role' is required")
+	// Without a role, the role is chosen by the plugin if role-less login is enabled
+	path := fmt.Sprintf(PathRolelessFormat, mount)
+	if role, ok := m["role"]; ok {
+		path = fmt.Sprintf(PathBaseFormat, mount, strings.ToLower(role))
 	}
-	role = strings.ToLower(role)
-
-	path := fmt.Sprintf(PathBaseFormat, mount, role)
 	signingPath := PathVersionBase + path
 
 	data, err := CreateLoginData(c.Address(), m, signingPath)
@@ -70,12 +73,51 @@ func (h *CLIHandler) Auth(c *api.Client, m map[string]string) (*api.Secret, erro
 		return nil, err
 	}
 
-	// Now try to login
-	secret, err := c.Logical().Write(path, data)
+	body, err := json.Marshal(data)
 	if err != nil {
 		return nil, err
 	}
-	return secret, nil
+
+	// Now try to login. The raw response is used so that the error code of a failed login is not lost.
+	resp, err := c.Logical().WriteRaw(path, body)
+	if resp != nil {
+		defer resp.Body.Close()
+	}
+	if err != nil {
+		return nil, loginErrorFromResponse(resp, err)
+	}
+	return api.ParseSecret(resp.Body)
+}
+
+// loginErrorFromResponse returns a LoginError for a failed login whose response has an error code,
+// so that callers can act on the reason of the failure. Other errors are returned as is.
+func loginErrorFromResponse(resp *api.Response, err error) error {
+	if resp == nil || resp.Body == nil {
+		return err
+	}
+
+	var errorBody struct {
+		Data struct {
+			Error      string `json:"error"`
+			ErrorCode  string `json:"error_code"`
+			RetryAfter int64  `json:"retry_after"`
+		} `json:"data"`
+	}
+	if json.NewDecoder(resp.Body).Decode(&errorBody) != nil || errorBody.Data.ErrorCode == "" {
+		return err
+	}
+
+	message := err.Error()
+	if errorBody.Data.Error != "" {
+		message = errorBody.Data.Error
+	}
+	return &LoginError{
+		Code:       errorBody.Data.ErrorCode,
+		StatusCode: resp.StatusCode,
+		Message:    message,
+		RetryAfter: time.Duration(errorBody.Data.RetryAfter) * time.Second,
+		err:        err,
+	}
 }
 
 // CreateLoginData creates the interface required for a login request, signed using the corresponding OCI Identity Principal
@@ -4,6 +4,7 @@
 package main
 
 import (
+	"context"
 	"os"
 
 	log "github.com/hashicorp/go-hclog"
@@ -11,6 +12,9 @@ import (
 	ociauth "github.com/hashicorp/vault-plugin-auth-oci"
 	"github.com/hashicorp/vault/api"
 	"github.com/hashicorp/vault/sdk/plugin"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
 )
 
 func main() {
@@ -21,6 +25,9 @@ func main() {
 	tlsConfig := apiClientMeta.GetTLSConfig()
 	tlsProviderFunc := api.VaultPluginTLSProvider(tlsConfig)
 
+	shutdownTracing := setupTracing()
+	defer shutdownTracing()
+
 	err := plugin.ServeMultiplex(&plugin.ServeOpts{
 		BackendFactoryFunc: ociauth.Factory,
 		// set the TLSProviderFunc so that the plugin maintains backwards
@@ -29,6 +36,31 @@ func main() {
 	})
 	if err != nil {
 		log.L().Error("plugin shutting down", "error", err)
+		shutdownTracing()
 		os.Exit(1)
 	}
 }
+
+// setupTracing exports the spans of the plugin with OTLP over HTTP when an endpoint is set through the
+// standard OpenTelemetry environment variables. Otherwise the spans are not recorded.
+// It returns a function that flushes the spans that were not exported yet.
+func setupTracing() func() {
+	if os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT") == "" && os.Getenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT") == "" {
+		return func() {}
+	}
+
+	exporter, err := otlptracehttp.New(context.Background())
+	if err != nil {
+		log.L().Error("unable to create the trace exporter", "error", err)
+		return func() {}
+	}
+
+	tracerProvider := sdktrace.NewTracerProvider(sdktrace.WithBatcher(exporter))
+	otel.SetTracerProvider(tracerProvider)
+
+	return func() {
+		if err := tracerProvider.Shutdown(context.Background()); err != nil {
+			log.L().Error("unable to flush the spans", "error", err)
+		}
+	}
+}
@@ -0,0 +1,146 @@
+// Copyright © 2019, Oracle and/or its affiliates.
+package ociauth
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/hashicorp/go-secure-stdlib/strutil"
+	lru "github.com/hashicorp/golang-lru"
+	"github.com/oracle/oci-go-sdk/v65/common"
+	"github.com/oracle/oci-go-sdk/v65/identity"
+)
+
+// These constants store the defaults of the compartment cache
+const (
+	compartmentCacheSize = 1024
+	compartmentCacheTTL  = time.Hour
+)
+
+// maxCompartmentDepth bounds the walk up the compartment tree. OCI nests compartments at most six levels deep.
+const maxCompartmentDepth = 10
+
+// claimCompartmentAncestors is the claim of the ancestors of the compartment of the principal, which are added to
+// the claims when a role with include_subcompartments needs them
+const claimCompartmentAncestors = "compartment_ancestors"
+
+// compartmentCacheEntry is a compartment looked up through Identity
+type compartmentCacheEntry struct {
+	name string
+
+	// parentId is the OCID of the parent compartment, or an empty string for the root compartment of the tenancy
+	parentId string
+	expiry   time.Time
+}
+
+func newCompartmentCache() *lru.Cache {
+	cache, _ := lru.New(compartmentCacheSize)
+	return cache
+}
+
+// getCompartment returns the compartment with the given OCID, from the cache if possible
+func (b *backend) getCompartment(ctx context.Context, requestId string, compartmentId string) (*compartmentCacheEntry, error) {
+	if value, ok := b.compartmentCache.Get(compartmentId); ok {
+		entry := value.(*compartmentCacheEntry)
+		if time.Now().Before(entry.expiry) {
+			emitCacheMetrics(metricCacheCompartment, true)
+			return entry, nil
+		}
+	}
+	emitCacheMetrics(metricCacheCompartment, false)
+
+	identityClient, err := b.getIdentityClient()
+	if err != nil {
+		return nil, err
+	}
+
+	response, err := identityClient.GetCompartment(ctx, identity.GetCompartmentRequest{
+		CompartmentId: common.String(compartmentId),
+		OpcRequestId:  common.String(requestId),
+	})
+	if err != nil {
+		return nil, err
+	}
+	if response.Name == nil || *response.Name == "" {
+		return nil, fmt.Errorf("compartment %q has no name", compartmentId)
+	}
+
+	entry := &compartmentCacheEntry{
+		name:   *response.Name,
+		expiry: time.Now().Add(compartmentCacheTTL),
+	}
+	if response.CompartmentId != nil {
+		entry.parentId = *response.CompartmentId
+	}
+	b.compartmentCache.Add(compartmentId, entry)
+
+	return entry, nil
+}
+
+// compartmentAncestors returns the ancestors of the compartment, from its parent up to the tenancy
+func (b *backend) compartmentAncestors(ctx context.Context, requestId string, compartmentId string) ([]string, error) {
+	var ancestors []string
+	for parentId := compartmentId; ; {
+		if parsed, err := parseOCID(parentId); err == nil && parsed.resourceType == ocidTypeTenancy {
+			return ancestors, nil
+		}
+		if len(ancestors) == maxCompartmentDepth {
+			return nil, fmt.Errorf("compartment %q is nested more than %d levels", compartmentId, maxCompartmentDepth)
+		}
+
+		compartment, err := b.getCompartment(ctx, requestId, parentId)
+		if err != nil {
+			return nil, err
+		}
+		if compartment.parentId == "" {
+			return ancestors, nil
+		}
+		parentId = compartment.parentId
+		ancestors = append(ancestors, parentId)
+	}
+}
+
+// compartmentAncestry is the ancestors of the compartment of a principal. They are derived with Identity rather than
+// authenticated with the request, so they are kept apart from the claims of the principal, and are looked up at most
+// once per login.
+type compartmentAncestry struct {
+	lookedUp  bool
+	ancestors []string
+	err       error
+}
+
+// withCompartmentAncestors returns the claims to validate the bindings of the role against. If the role covers
+// subcompartments and the compartment of the principal is not bound directly, they are a copy of the claims with the
+// ancestors of the compartment, which are looked up on first use. The claims of the principal are never modified.
+func (b *backend) withCompartmentAncestors(ctx context.Context, requestId string, roleEntry *OCIRoleEntry, claims InternalClaims, ancestry *compartmentAncestry, trace *loginTrace) (InternalClaims, error) {
+	compartmentId := claims.GetString(ClaimCompartment)
+	if !roleEntry.IncludeSubcompartments || compartmentId == "" ||
+		len(roleEntry.BoundCompartmentIds) == 0 || strutil.StrListContains(roleEntry.BoundCompartmentIds, compartmentId) {
+		return claims, nil
+	}
+
+	if !ancestry.lookedUp {
+		ancestry.lookedUp = true
+		ancestry.ancestors, ancestry.err = b.compartmentAncestors(ctx, requestId, compartmentId)
+		if ancestry.err != nil {
+			b.Logger().Warn("Unable to look up the ancestors of the compartment", "id", requestId, "compartment", compartmentId, "err", ancestry.err)
+			trace.set("compartment_ancestors_error", ancestry.err.Error())
+		} else {
+			trace.set("compartment_ancestors", ancestry.ancestors)
+		}
+	}
+	if ancestry.err != nil {
+		return nil, ancestry.err
+	}
+
+	roleClaims := make(InternalClaims, len(claims)+1)
+	for key, values := range claims {
+		roleClaims[key] = values
+	}
+	roleClaims[claimCompartmentAncestors] = make([]InternalClaim, 0, len(ancestry.ancestors))
+	for _, ancestor := range ancestry.ancestors {
+		roleClaims[claimCompartmentAncestors] = append(roleClaims[claimCompartmentAncestors], InternalClaim{Key: claimCompartmentAncestors, Value: ancestor})
+	}
+	return roleClaims, nil
+}
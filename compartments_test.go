@@ -0,0 +1,164 @@
+// Copyright © 2019, Oracle and/or its affiliates.
+package ociauth
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/oracle/oci-go-sdk/v65/common"
+	"github.com/oracle/oci-go-sdk/v65/identity"
+)
+
+func TestBackend_IncludeSubcompartments(t *testing.T) {
+	teamCompartmentId := "ocid1.compartment.oc1..team"
+	divisionCompartmentId := "ocid1.compartment.oc1..division"
+	parents := map[string]string{
+		testCompartmentId:     teamCompartmentId,
+		teamCompartmentId:     divisionCompartmentId,
+		divisionCompartmentId: testTenancyId,
+	}
+
+	var compartmentRequests, failures int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&compartmentRequests, 1)
+		if atomic.LoadInt32(&failures) > 0 {
+			// A status code that the SDK does not retry
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		segments := strings.Split(r.URL.Path, "/")
+		ocid := segments[len(segments)-1]
+		parentId, ok := parents[ocid]
+		if !ok {
+			t.Errorf("unexpected compartment: %s", ocid)
+		}
+		json.NewEncoder(w).Encode(identity.Compartment{Id: common.String(ocid), Name: common.String(ocid), CompartmentId: common.String(parentId)})
+	}))
+	defer server.Close()
+
+	b, config, login := newTestLocalLoginBackend(t, nil)
+	b.identityClient = newTestIdentityClient(t, server.URL)
+
+	writeRole := func(name string, data map[string]interface{}) *logical.Response {
+		data["token_policies"] = "default"
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.CreateOperation,
+			Path:      "role/" + name,
+			Storage:   config.StorageView,
+			Data:      data,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp
+	}
+
+	// Without include_subcompartments, only the compartment of the instance itself is bound
+	writeRole("divisionrole", map[string]interface{}{"bound_compartment_ids": divisionCompartmentId})
+	if code, errorCode := loginErrorCode(t, login("divisionrole")); code != http.StatusForbidden || errorCode != LoginErrorBindingFailed {
+		t.Fatalf("expected the binding to fail: %d %s", code, errorCode)
+	}
+
+	writeRole("divisionrole", map[string]interface{}{"include_subcompartments": true})
+	for i := 0; i < 2; i++ {
+		if resp := login("divisionrole"); resp == nil || resp.Auth == nil {
+			t.Fatalf("expected login to succeed: %#v", resp)
+		}
+	}
+	if atomic.LoadInt32(&compartmentRequests) != 3 {
+		t.Fatalf("expected the compartment tree to be looked up once: %d", compartmentRequests)
+	}
+
+	// The tenancy is the ancestor of every compartment, and a compartment that is bound directly needs no lookup
+	writeRole("tenancyrole", map[string]interface{}{"bound_compartment_ids": testTenancyId, "include_subcompartments": true})
+	if resp := login("tenancyrole"); resp == nil || resp.Auth == nil {
+		t.Fatalf("expected login to succeed: %#v", resp)
+	}
+	writeRole("directrole", map[string]interface{}{"bound_compartment_ids": testCompartmentId, "include_subcompartments": true})
+	atomic.StoreInt32(&failures, 1)
+	if resp := login("directrole"); resp == nil || resp.Auth == nil {
+		t.Fatalf("expected login to succeed: %#v", resp)
+	}
+
+	// A sibling compartment is not an ancestor
+	writeRole("siblingrole", map[string]interface{}{"bound_compartment_ids": "ocid1.compartment.oc1..sibling", "include_subcompartments": true})
+	if code, errorCode := loginErrorCode(t, login("siblingrole")); code != http.StatusForbidden || errorCode != LoginErrorBindingFailed {
+		t.Fatalf("expected the binding to fail: %d %s", code, errorCode)
+	}
+
+	// If the tree can not be looked up, the login fails instead of being denied
+	b.compartmentCache.Purge()
+	if code, errorCode := loginErrorCode(t, login("divisionrole")); code != http.StatusServiceUnavailable || errorCode != LoginErrorIdentityUnavailable {
+		t.Fatalf("expected the lookup to fail: %d %s", code, errorCode)
+	}
+	atomic.StoreInt32(&failures, 0)
+
+	resp, err := b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      "role/divisionrole",
+		Storage:   config.StorageView,
+	})
+	if err != nil || resp == nil || resp.IsError() {
+		t.Fatalf("Role read failed. resp:%#v\n err:%v", resp, err)
+	}
+	if resp.Data["include_subcompartments"] != true || !reflect.DeepEqual(resp.Data["bound_compartment_ids"], []string{divisionCompartmentId}) {
+		t.Fatalf("unexpected role: %#v", resp.Data)
+	}
+
+	if resp := writeRole("unboundrole", map[string]interface{}{"include_subcompartments": true, "force": true}); resp == nil || len(resp.Warnings) == 0 {
+		t.Fatalf("expected a warning: %#v", resp)
+	}
+}
+
+func TestBackend_ForgedCompartmentAncestors(t *testing.T) {
+	divisionCompartmentId := "ocid1.compartment.oc1..division"
+	var compartmentRequests int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&compartmentRequests, 1)
+		// A status code that the SDK does not retry
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer server.Close()
+
+	fake := newFakeIdentity(t)
+	b, config := initOfflineTest(t, fake, "ocid1.group.oc1..rolegroup")
+	b.identityClient = newTestIdentityClient(t, server.URL)
+	if err := createRole(map[string]interface{}{
+		"bound_compartment_ids":   divisionCompartmentId,
+		"include_subcompartments": true,
+		"token_policies":          "default",
+	}, "divisionrole", b, config); err != nil {
+		t.Fatal(err)
+	}
+
+	// The ancestors carried by the principal are not trusted, they are looked up
+	headers, keyId := signedAPIKeyHeaders(t, PathVersionBase+fmt.Sprintf(PathBaseFormat, "oci", "divisionrole"))
+	fake.addPrincipal(keyId, newFakePrincipal(testInstanceId, PrincipalTypeInstance, map[string]string{
+		ClaimCompartment:          testCompartmentId,
+		claimCompartmentAncestors: divisionCompartmentId,
+	}))
+	resp, err := b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "login/divisionrole",
+		Storage:   config.StorageView,
+		Data:      map[string]interface{}{"request_headers": headers},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if code, errorCode := loginErrorCode(t, resp); code != http.StatusServiceUnavailable || errorCode != LoginErrorIdentityUnavailable {
+		t.Fatalf("expected the lookup to fail: %d %s", code, errorCode)
+	}
+	if atomic.LoadInt32(&compartmentRequests) != 1 {
+		t.Fatalf("expected the compartment to be looked up: %d", compartmentRequests)
+	}
+}
@@ -0,0 +1,86 @@
+// Copyright © 2019, Oracle and/or its affiliates.
+package ociauth
+
+import (
+	"context"
+	"strings"
+)
+
+// entryCache holds the decoded config and role entries, so that logins do not read them from storage.
+// The cached entries are shared and must not be modified by callers.
+type entryCache struct {
+	config *OCIConfigEntry
+	roles  map[string]*OCIRoleEntry
+
+	// generation is incremented on every invalidation, so that an entry read from storage
+	// concurrently with a write is not cached after the write invalidated it
+	generation uint64
+}
+
+// cachedOCIConfig returns the cached config entry and the current cache generation
+func (b *backend) cachedOCIConfig() (*OCIConfigEntry, uint64) {
+	b.entryCacheMutex.RLock()
+	defer b.entryCacheMutex.RUnlock()
+
+	return b.entryCache.config, b.entryCache.generation
+}
+
+// cacheOCIConfig caches the config entry, unless it was invalidated since the given generation
+func (b *backend) cacheOCIConfig(configEntry *OCIConfigEntry, generation uint64) {
+	b.entryCacheMutex.Lock()
+	defer b.entryCacheMutex.Unlock()
+
+	if b.entryCache.generation == generation {
+		b.entryCache.config = configEntry
+	}
+}
+
+// cachedOCIRole returns the cached role entry and the current cache generation
+func (b *backend) cachedOCIRole(roleName string) (*OCIRoleEntry, uint64) {
+	b.entryCacheMutex.RLock()
+	defer b.entryCacheMutex.RUnlock()
+
+	return b.entryCache.roles[roleName], b.entryCache.generation
+}
+
+// cacheOCIRole caches the role entry, unless it was invalidated since the given generation
+func (b *backend) cacheOCIRole(roleName string, roleEntry *OCIRoleEntry, generation uint64) {
+	b.entryCacheMutex.Lock()
+	defer b.entryCacheMutex.Unlock()
+
+	if b.entryCache.generation == generation {
+		b.entryCache.roles[roleName] = roleEntry
+	}
+}
+
+// invalidateOCIConfig removes the config entry from the cache
+func (b *backend) invalidateOCIConfig() {
+	b.entryCacheMutex.Lock()
+	b.entryCache.config = nil
+	b.entryCache.generation++
+	b.entryCacheMutex.Unlock()
+
+	b.membershipCache.purge()
+	b.instanceCache.Purge()
+	b.userCache.Purge()
+}
+
+// invalidateOCIRole removes the role entry from the cache
+func (b *backend) invalidateOCIRole(roleName string) {
+	b.entryCacheMutex.Lock()
+	delete(b.entryCache.roles, roleName)
+	b.entryCache.generation++
+	b.entryCacheMutex.Unlock()
+
+	b.membershipCache.purge()
+}
+
+// invalidate is called when a storage key is modified, including by another node of the cluster
+func (b *backend) invalidate(ctx context.Context, key string) {
+	switch {
+	case key == "config":
+		b.invalidateOCIConfig()
+	case strings.HasPrefix(key, "role/"):
+		b.invalidateOCIRole(strings.TrimPrefix(key, "role/"))
+	}
+}
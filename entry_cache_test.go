@@ -0,0 +1,94 @@
+// Copyright © 2019, Oracle and/or its affiliates.
+package ociauth
+
+import (
+	"context"
+	"testing"
+
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+func TestBackend_EntryCache(t *testing.T) {
+	ctx := context.Background()
+	config := logical.TestBackendConfig()
+	config.StorageView = &logical.InmemStorage{}
+
+	b, err := Backend()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Setup(ctx, config); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := createRole(map[string]interface{}{"ocid_list": "ocid1.group.oc1..group1"}, "devrole", b, config); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.setOCIConfig(ctx, config.StorageView, &OCIConfigEntry{HomeTenancyId: testTenancyId}); err != nil {
+		t.Fatal(err)
+	}
+
+	roleEntry, err := b.getOCIRole(ctx, config.StorageView, "devrole")
+	if err != nil || roleEntry == nil {
+		t.Fatalf("failed to read the role: %v", err)
+	}
+	configEntry, err := b.getOCIConfig(ctx, config.StorageView)
+	if err != nil || configEntry == nil {
+		t.Fatalf("failed to read the config: %v", err)
+	}
+
+	// Simulate writes made by another node of the cluster, which bypass this backend
+	entry, _ := logical.StorageEntryJSON("role/devrole", &OCIRoleEntry{OcidList: []string{"ocid1.group.oc1..group2"}})
+	if err := config.StorageView.Put(ctx, entry); err != nil {
+		t.Fatal(err)
+	}
+	entry, _ = logical.StorageEntryJSON("config", &OCIConfigEntry{HomeTenancyId: "ocid1.tenancy.oc1..othertenancy"})
+	if err := config.StorageView.Put(ctx, entry); err != nil {
+		t.Fatal(err)
+	}
+
+	// The cached entries are served until they are invalidated
+	if cached, _ := b.getOCIRole(ctx, config.StorageView, "devrole"); cached != roleEntry {
+		t.Fatal("expected the cached role entry")
+	}
+	if cached, _ := b.getOCIConfig(ctx, config.StorageView); cached != configEntry {
+		t.Fatal("expected the cached config entry")
+	}
+
+	b.InvalidateKey(ctx, "role/devrole")
+	b.InvalidateKey(ctx, "config")
+
+	roleEntry, err = b.getOCIRole(ctx, config.StorageView, "devrole")
+	if err != nil || roleEntry == nil || roleEntry.OcidList[0] != "ocid1.group.oc1..group2" {
+		t.Fatalf("expected the updated role entry: %#v err:%v", roleEntry, err)
+	}
+	configEntry, err = b.getOCIConfig(ctx, config.StorageView)
+	if err != nil || configEntry == nil || configEntry.HomeTenancyId != "ocid1.tenancy.oc1..othertenancy" {
+		t.Fatalf("expected the updated config entry: %#v err:%v", configEntry, err)
+	}
+
+	// Deletes through the backend invalidate the cache
+	resp, err := b.HandleRequest(ctx, &logical.Request{
+		Operation: logical.DeleteOperation,
+		Path:      "role/devrole",
+		Storage:   config.StorageView,
+	})
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("role delete failed. resp:%#v\n err:%v", resp, err)
+	}
+	if roleEntry, err := b.getOCIRole(ctx, config.StorageView, "devrole"); err != nil || roleEntry != nil {
+		t.Fatalf("expected the role to be deleted: %#v err:%v", roleEntry, err)
+	}
+
+	resp, err = b.HandleRequest(ctx, &logical.Request{
+		Operation: logical.DeleteOperation,
+		Path:      "config",
+		Storage:   config.StorageView,
+	})
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("config delete failed. resp:%#v\n err:%v", resp, err)
+	}
+	if configEntry, err := b.getOCIConfig(ctx, config.StorageView); err != nil || configEntry != nil {
+		t.Fatalf("expected the config to be deleted: %#v err:%v", configEntry, err)
+	}
+}
@@ -0,0 +1,209 @@
+// Copyright © 2019, Oracle and/or its affiliates.
+package ociauth
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// These constants are the paths of the operations served by fakeIdentity
+const (
+	fakeIdentityAuthenticateClient    = "/authentication/authenticateClient"
+	fakeIdentityFilterGroupMembership = "/filterGroupMembership"
+)
+
+// fakeIdentity is an httptest server implementing the AuthenticateClient and FilterGroupMembership operations of
+// OCI Identity. Principals are registered with the keyId they sign their requests with and the groups they are
+// a member of. Signatures are not verified: a request is authenticated as the principal of its keyId.
+type fakeIdentity struct {
+	*httptest.Server
+
+	lock sync.Mutex
+
+	// principals are keyed on the keyId of the signature of the login request
+	principals map[string]Principal
+
+	// groups are the group OCIDs each subject is a member of
+	groups map[string]map[string]bool
+
+	// errors are the status codes returned by the operations, keyed on their path
+	errors map[string]int
+
+	// requests are the number of requests made to the operations, keyed on their path
+	requests map[string]int
+}
+
+// newFakeIdentity starts a fakeIdentity that is closed when the test ends
+func newFakeIdentity(t *testing.T) *fakeIdentity {
+	t.Helper()
+
+	f := &fakeIdentity{
+		principals: make(map[string]Principal),
+		groups:     make(map[string]map[string]bool),
+		errors:     make(map[string]int),
+		requests:   make(map[string]int),
+	}
+	f.Server = httptest.NewServer(http.HandlerFunc(f.serveHTTP))
+	t.Cleanup(f.Close)
+	return f
+}
+
+// client returns an AuthenticationClient that sends its requests to the fake
+func (f *fakeIdentity) client(t *testing.T) *AuthenticationClient {
+	return newTestAuthenticationClient(t, f.URL)
+}
+
+// addPrincipal registers the principal authenticated by the keyId, as a member of the given groups
+func (f *fakeIdentity) addPrincipal(keyId string, principal Principal, groupIds ...string) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	f.principals[keyId] = principal
+	members := f.groups[*principal.SubjectId]
+	if members == nil {
+		members = make(map[string]bool)
+		f.groups[*principal.SubjectId] = members
+	}
+	for _, groupId := range groupIds {
+		members[groupId] = true
+	}
+}
+
+// setMembership replaces the groups the subject is a member of
+func (f *fakeIdentity) setMembership(subjectId string, groupIds ...string) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	members := make(map[string]bool)
+	for _, groupId := range groupIds {
+		members[groupId] = true
+	}
+	f.groups[subjectId] = members
+}
+
+// setError makes the operation at the path fail with the status code, or succeed again if it is 0
+func (f *fakeIdentity) setError(path string, statusCode int) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	f.errors[path] = statusCode
+}
+
+// requestCount returns the number of requests made to the operation at the path
+func (f *fakeIdentity) requestCount(path string) int {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	return f.requests[path]
+}
+
+func (f *fakeIdentity) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	// The AuthenticationClient sends its requests under the version of the API
+	path := strings.TrimPrefix(r.URL.Path, "/v1")
+	f.requests[path]++
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("opc-request-id", fmt.Sprintf("fake%s/%d", path, f.requests[path]))
+
+	if statusCode := f.errors[path]; statusCode != 0 {
+		w.WriteHeader(statusCode)
+		json.NewEncoder(w).Encode(map[string]string{
+			"code":    http.StatusText(statusCode),
+			"message": "injected error",
+		})
+		return
+	}
+
+	switch path {
+	case fakeIdentityAuthenticateClient:
+		var details AuthenticateClientDetails
+		if err := json.NewDecoder(r.Body).Decode(&details); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		json.NewEncoder(w).Encode(f.authenticateClient(details))
+
+	case fakeIdentityFilterGroupMembership:
+		var details FilterGroupMembershipDetails
+		if err := json.NewDecoder(r.Body).Decode(&details); err != nil || details.Principal.SubjectId == nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		result := FilterGroupMembershipResult{Principal: details.Principal, GroupIds: []string{}}
+		for _, groupId := range details.GroupIds {
+			if f.groups[*details.Principal.SubjectId][groupId] {
+				result.GroupIds = append(result.GroupIds, groupId)
+			}
+		}
+		json.NewEncoder(w).Encode(result)
+
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+// authenticateClient returns the principal registered with the keyId of the request headers
+func (f *fakeIdentity) authenticateClient(details AuthenticateClientDetails) AuthenticateClientResult {
+	success, failure := true, false
+
+	params, err := parseSignatureParams(http.Header(details.RequestHeaders))
+	if err != nil {
+		message := err.Error()
+		return AuthenticateClientResult{ErrorMessage: &message, IsSuccess: &failure}
+	}
+	principal, ok := f.principals[params["keyId"]]
+	if !ok {
+		message := "unknown keyId"
+		return AuthenticateClientResult{ErrorMessage: &message, IsSuccess: &failure}
+	}
+	return AuthenticateClientResult{Principal: &principal, IsSuccess: &success}
+}
+
+// newFakePrincipal returns a principal of the test tenancy with the given claims
+func newFakePrincipal(subjectId, principalType string, claims map[string]string) Principal {
+	tenantId := testTenancyId
+	allClaims := map[string]string{
+		ClaimSubject:       subjectId,
+		ClaimPrincipalType: principalType,
+		ClaimTenant:        tenantId,
+	}
+	for key, value := range claims {
+		allClaims[key] = value
+	}
+
+	principal := Principal{SubjectId: &subjectId, TenantId: &tenantId}
+	issuer := "authService.oracle.com"
+	for key, value := range allClaims {
+		key, value := key, value
+		principal.Claims = append(principal.Claims, Claim{Key: &key, Value: &value, Issuer: &issuer})
+	}
+	return principal
+}
+
+// signedAPIKeyHeaders returns the headers of a login request to the path, signed with the API key of the
+// configuration provider, and the keyId of the signature
+func signedAPIKeyHeaders(t *testing.T, path string) (http.Header, string) {
+	t.Helper()
+
+	provider := newTestConfigurationProvider(t)
+	keyId, err := provider.KeyID()
+	if err != nil {
+		t.Fatal(err)
+	}
+	client, err := NewOciClientWithConfigurationProvider(provider)
+	if err != nil {
+		t.Fatal(err)
+	}
+	headers, err := getSignedRequestHeaders("http://127.0.0.1", &client, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return headers, keyId
+}
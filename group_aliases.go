@@ -0,0 +1,106 @@
+// Copyright © 2019, Oracle and/or its affiliates.
+package ociauth
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	lru "github.com/hashicorp/golang-lru"
+	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/oracle/oci-go-sdk/v65/common/auth"
+	"github.com/oracle/oci-go-sdk/v65/identity"
+)
+
+// These constants store the defaults of the group name cache
+const (
+	groupNameCacheSize = 1024
+	groupNameCacheTTL  = time.Hour
+)
+
+// groupNameCacheEntry is a group name resolved through Identity
+type groupNameCacheEntry struct {
+	name   string
+	expiry time.Time
+}
+
+func newGroupNameCache() *lru.Cache {
+	cache, _ := lru.New(groupNameCacheSize)
+	return cache
+}
+
+// setGroupAliases adds a group alias to the auth for each of the matched groups, named after
+// the OCID of the group or its name, as configured
+func (b *backend) setGroupAliases(ctx context.Context, req *logical.Request, configEntry *OCIConfigEntry, auth *logical.Auth, groupIds []string) {
+	groupAliases := configEntry.groupAliases()
+	if groupAliases == GroupAliasesDisabled {
+		return
+	}
+
+	for _, groupId := range groupIds {
+		aliasName := groupId
+		if groupAliases == GroupAliasesName {
+			name, err := b.resolveGroupName(ctx, req.ID, groupId)
+			if err != nil {
+				// The OCID is used so that the login does not fail because names can not be resolved
+				b.Logger().Warn("Unable to resolve the group name, using its OCID as the group alias", "id", req.ID, "group", groupId, "err", err)
+			} else {
+				aliasName = name
+			}
+		}
+		auth.GroupAliases = append(auth.GroupAliases, &logical.Alias{
+			Name: aliasName,
+		})
+	}
+}
+
+// resolveGroupName returns the name of the Group or Dynamic Group with the given OCID, from the cache if possible
+func (b *backend) resolveGroupName(ctx context.Context, requestId string, groupId string) (string, error) {
+	if value, ok := b.groupNameCache.Get(groupId); ok {
+		entry := value.(*groupNameCacheEntry)
+		if time.Now().Before(entry.expiry) {
+			emitCacheMetrics(metricCacheGroupName, true)
+			return entry.name, nil
+		}
+	}
+	emitCacheMetrics(metricCacheGroupName, false)
+
+	name, _, err := b.getGroup(ctx, requestId, groupId)
+	if err != nil {
+		return "", err
+	}
+
+	b.groupNameCache.Add(groupId, &groupNameCacheEntry{
+		name:   name,
+		expiry: time.Now().Add(groupNameCacheTTL),
+	})
+
+	return name, nil
+}
+
+// getIdentityClient returns the Identity client, creating it if one was not already created
+func (b *backend) getIdentityClient() (*identity.IdentityClient, error) {
+	b.identityClientMutex.Lock()
+	defer b.identityClientMutex.Unlock()
+
+	if b.identityClient != nil {
+		return b.identityClient, nil
+	}
+
+	// Create the instance principal provider
+	ip, err := auth.InstancePrincipalConfigurationProvider()
+	if err != nil {
+		b.Logger().Debug("Unable to create InstancePrincipalConfigurationProvider", "err", err)
+		return nil, fmt.Errorf("unable to create InstancePrincipalConfigurationProvider")
+	}
+
+	identityClient, err := identity.NewIdentityClientWithConfigurationProvider(ip)
+	if err != nil {
+		b.Logger().Debug("Unable to create identityClient", "err", err)
+		return nil, fmt.Errorf("unable to create identityClient")
+	}
+
+	b.identityClient = &identityClient
+
+	return b.identityClient, nil
+}
@@ -0,0 +1,174 @@
+// Copyright © 2019, Oracle and/or its affiliates.
+package ociauth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/oracle/oci-go-sdk/v65/common"
+	"github.com/oracle/oci-go-sdk/v65/identity"
+)
+
+// newTestIdentityClient returns an IdentityClient that sends its requests to the given host
+func newTestIdentityClient(t *testing.T, host string) *identity.IdentityClient {
+	t.Helper()
+
+	client, err := identity.NewIdentityClientWithConfigurationProvider(newTestConfigurationProvider(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.Host = host
+	return &client
+}
+
+func TestBackend_GroupAliases(t *testing.T) {
+	group := "ocid1.group.oc1..admins"
+	dynamicGroup := "ocid1.dynamicgroup.oc1..instances"
+	unnamedGroup := "ocid1.group.oc1..unnamed"
+	names := map[string]string{group: "admins", dynamicGroup: "instances"}
+
+	var nameRequests int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+
+		if r.Method == http.MethodGet {
+			atomic.AddInt32(&nameRequests, 1)
+			segments := strings.Split(r.URL.Path, "/")
+			ocid := segments[len(segments)-1]
+			name, ok := names[ocid]
+			if !ok {
+				w.WriteHeader(http.StatusNotFound)
+				json.NewEncoder(w).Encode(map[string]string{"code": "NotAuthorizedOrNotFound", "message": "not found"})
+				return
+			}
+			if segments[len(segments)-2] == "dynamicGroups" {
+				json.NewEncoder(w).Encode(identity.DynamicGroup{Id: common.String(ocid), Name: common.String(name)})
+			} else {
+				json.NewEncoder(w).Encode(identity.Group{Id: common.String(ocid), Name: common.String(name)})
+			}
+			return
+		}
+
+		var details FilterGroupMembershipDetails
+		if err := json.NewDecoder(r.Body).Decode(&details); err != nil {
+			t.Errorf("bad request body: %v", err)
+		}
+		json.NewEncoder(w).Encode(FilterGroupMembershipResult{Principal: details.Principal, GroupIds: details.GroupIds})
+	}))
+	defer server.Close()
+
+	config := logical.TestBackendConfig()
+	config.StorageView = &logical.InmemStorage{}
+	b, err := Backend()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Setup(context.Background(), config); err != nil {
+		t.Fatal(err)
+	}
+	b.authenticationClient = newTestAuthenticationClient(t, server.URL)
+	b.identityClient = newTestIdentityClient(t, server.URL)
+
+	ca := newTestCA(t)
+	writeConfig := func(groupAliases string) {
+		data := map[string]interface{}{
+			HomeTenancyIdConfigName:                testTenancyId,
+			InstancePrincipalCABundleConfigName:    ca.bundle(),
+			InstancePrincipalTokenSignerConfigName: testTokenSigner,
+			InstancePrincipalHostsConfigName:       testHost,
+		}
+		if groupAliases != "" {
+			data[GroupAliasesConfigName] = groupAliases
+		}
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.CreateOperation,
+			Path:      "config",
+			Storage:   config.StorageView,
+			Data:      data,
+		})
+		if err != nil || (resp != nil && resp.IsError()) {
+			t.Fatalf("Config write failed. resp:%#v\n err:%v", resp, err)
+		}
+	}
+
+	roleData := map[string]interface{}{
+		"ocid_list":      strings.Join([]string{group, dynamicGroup, unnamedGroup}, ","),
+		"token_policies": "policy1",
+	}
+	if err := createRole(roleData, "devrole", b, config); err != nil {
+		t.Fatal(err)
+	}
+
+	sessionKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	token := ca.securityToken(t, sessionKey, nil)
+
+	loginAliases := func() []string {
+		headers := signedInstanceHeaders(t, token, sessionKey, "get "+PathVersionBase+fmt.Sprintf(PathBaseFormat, "oci", "devrole"))
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      "login/devrole",
+			Storage:   config.StorageView,
+			Data: map[string]interface{}{
+				"request_headers": map[string][]string(headers),
+			},
+		})
+		if err != nil || resp == nil || resp.IsError() || resp.Auth == nil {
+			t.Fatalf("expected login to succeed. resp:%#v\n err:%v", resp, err)
+		}
+		var aliases []string
+		for _, alias := range resp.Auth.GroupAliases {
+			aliases = append(aliases, alias.Name)
+		}
+		return aliases
+	}
+
+	// Group aliases are disabled by default, so that group membership checks can stop at the first match
+	writeConfig("")
+	resp, err := b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      "config",
+		Storage:   config.StorageView,
+	})
+	if err != nil || resp == nil || resp.Data[GroupAliasesConfigName] != GroupAliasesDisabled {
+		t.Fatalf("expected group aliases to be disabled by default: %#v %v", resp, err)
+	}
+	if aliases := loginAliases(); len(aliases) != 0 {
+		t.Fatalf("expected no group aliases: %v", aliases)
+	}
+
+	// The aliases can be named after the group OCIDs
+	writeConfig(GroupAliasesOCID)
+	if aliases := loginAliases(); !reflect.DeepEqual(aliases, []string{group, dynamicGroup, unnamedGroup}) {
+		t.Fatalf("unexpected group aliases: %v", aliases)
+	}
+
+	// Groups whose names can not be resolved keep their OCID
+	writeConfig(GroupAliasesName)
+	for i := 0; i < 2; i++ {
+		if aliases := loginAliases(); !reflect.DeepEqual(aliases, []string{"admins", "instances", unnamedGroup}) {
+			t.Fatalf("unexpected group aliases: %v", aliases)
+		}
+	}
+	// The resolved names are cached, the failed resolution is retried
+	if nameRequests != 4 {
+		t.Fatalf("expected 4 name requests, got %d", nameRequests)
+	}
+
+	writeConfig(GroupAliasesDisabled)
+	if aliases := loginAliases(); len(aliases) != 0 {
+		t.Fatalf("expected no group aliases: %v", aliases)
+	}
+}
@@ -0,0 +1,162 @@
+// Copyright © 2019, Oracle and/or its affiliates.
+package ociauth
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/oracle/oci-go-sdk/v65/common"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// These constants control how group membership is checked for roles with many OCIDs
+const (
+	// filterGroupMembershipChunkSize is the maximum number of OCIDs sent in a single FilterGroupMembership request
+	filterGroupMembershipChunkSize = 100
+
+	// filterGroupMembershipWorkers bounds the number of concurrent FilterGroupMembership requests made for a login
+	filterGroupMembershipWorkers = 4
+)
+
+// filterGroupMembership returns the subset of the given OCIDs that the principal is a member of.
+// The OCIDs are split into chunks that are checked concurrently by a bounded pool of workers.
+// If stopOnMatch is set, the remaining chunks are abandoned as soon as a match is found,
+// so the result only contains the matches found until then.
+func (b *backend) filterGroupMembership(ctx context.Context, requestId string, principal Principal, ocids []string, stopOnMatch bool) ([]string, error) {
+	if len(ocids) == 0 {
+		return nil, nil
+	}
+
+	var chunks [][]string
+	for start := 0; start < len(ocids); start += filterGroupMembershipChunkSize {
+		end := start + filterGroupMembershipChunkSize
+		if end > len(ocids) {
+			end = len(ocids)
+		}
+		chunks = append(chunks, ocids[start:end])
+	}
+
+	// The requests in flight are not cancelled when the check stops early: they complete and their result is kept.
+	// They are cancelled with the context of the login, so that a login that is cancelled or times out stops calling
+	// Identity.
+	stopCh := make(chan struct{})
+	chunkCh := make(chan []string)
+	go func() {
+		defer close(chunkCh)
+		for _, chunk := range chunks {
+			select {
+			case chunkCh <- chunk:
+			case <-stopCh:
+				return
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	var (
+		lock     sync.Mutex
+		wg       sync.WaitGroup
+		matched  = make(map[string]string)
+		stopped  bool
+		firstErr error
+	)
+
+	// stop must be called with the lock held
+	stop := func() {
+		if !stopped {
+			stopped = true
+			close(stopCh)
+		}
+	}
+
+	workers := filterGroupMembershipWorkers
+	if len(chunks) < workers {
+		workers = len(chunks)
+	}
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for chunk := range chunkCh {
+				lock.Lock()
+				skip := stopped
+				lock.Unlock()
+				if skip {
+					continue
+				}
+
+				groupIds, err := b.filterGroupMembershipChunk(ctx, requestId, principal, chunk)
+
+				lock.Lock()
+				if err != nil {
+					if firstErr == nil && !stopped {
+						firstErr = err
+					}
+					stop()
+				} else {
+					addSliceToMap(groupIds, matched)
+					if stopOnMatch && len(matched) > 0 {
+						stop()
+					}
+				}
+				lock.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	if firstErr != nil {
+		return nil, firstErr
+	}
+	// The chunks that were not sent when the context was done are not part of the matches, which are incomplete
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	// Only return the requested OCIDs, in the order they were requested
+	result := make([]string, 0, len(matched))
+	for _, ocid := range ocids {
+		if _, present := matched[ocid]; present {
+			result = append(result, ocid)
+			delete(matched, ocid)
+		}
+	}
+	return result, nil
+}
+
+// filterGroupMembershipChunk makes a single FilterGroupMembership request to Identity
+func (b *backend) filterGroupMembershipChunk(ctx context.Context, requestId string, principal Principal, ocids []string) ([]string, error) {
+	filterGroupMembershipDetails := FilterGroupMembershipDetails{
+		Principal: principal,
+		GroupIds:  ocids,
+	}
+
+	filterGroupMembershipRequest := FilterGroupMembershipRequest{
+		FilterGroupMembershipDetails: filterGroupMembershipDetails,
+		OpcRequestId:                 &requestId,
+		RequestMetadata:              common.RequestMetadata{},
+	}
+
+	authenticationClient, err := b.getAuthClient()
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, span := startSpan(ctx, requestId, spanFilterGroupMembership)
+	start := time.Now()
+	filterGroupMembershipResponse, err := authenticationClient.FilterGroupMembership(ctx, filterGroupMembershipRequest)
+	emitIdentityMetrics(metricFilterGroupMembership, start, err)
+	opcRequestId := opcRequestId(filterGroupMembershipResponse.OpcRequestId, filterGroupMembershipResponse.RawResponse, err)
+	span.SetAttributes(
+		attribute.String(attributeOpcRequestId, opcRequestId),
+		attribute.Int(attributeGroupCount, len(ocids)),
+	)
+	endSpan(span, err)
+	b.Logger().Debug("FilterGroupMembership", "id", requestId, "opc_request_id", opcRequestId, "duration", time.Since(start), "err", err)
+	if err != nil {
+		return nil, err
+	}
+	return filterGroupMembershipResponse.GroupIds, nil
+}
@@ -0,0 +1,181 @@
+// Copyright © 2019, Oracle and/or its affiliates.
+package ociauth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/oracle/oci-go-sdk/v65/common"
+)
+
+// newTestConfigurationProvider returns a configuration provider with a generated API signing key
+func newTestConfigurationProvider(t *testing.T) common.ConfigurationProvider {
+	t.Helper()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	privateKey := string(pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)}))
+
+	return common.NewRawConfigurationProvider(testTenancyId, "ocid1.user.oc1..testuser", "us-phoenix-1", "20:3b:97:13:55:1c", privateKey, nil)
+}
+
+// newTestAuthenticationClient returns an AuthenticationClient that sends its requests to the given host
+func newTestAuthenticationClient(t *testing.T, host string) *AuthenticationClient {
+	t.Helper()
+
+	client, err := NewAuthenticationClientWithConfigurationProvider(newTestConfigurationProvider(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.SetHost(host)
+	return &client
+}
+
+func TestFilterGroupMembership_Chunks(t *testing.T) {
+	var ocids []string
+	for i := 0; i < 250; i++ {
+		ocids = append(ocids, fmt.Sprintf("ocid1.dynamicgroup.oc1..group%d", i))
+	}
+	members := map[string]bool{ocids[5]: true, ocids[120]: true, ocids[249]: true}
+
+	var (
+		lock     sync.Mutex
+		requests [][]string
+		inFlight int32
+	)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if n := atomic.AddInt32(&inFlight, 1); n > filterGroupMembershipWorkers {
+			t.Errorf("too many concurrent requests: %d", n)
+		}
+		defer atomic.AddInt32(&inFlight, -1)
+
+		var details FilterGroupMembershipDetails
+		if err := json.NewDecoder(r.Body).Decode(&details); err != nil {
+			t.Errorf("bad request body: %v", err)
+		}
+		result := FilterGroupMembershipResult{Principal: details.Principal, GroupIds: []string{}}
+		lock.Lock()
+		requests = append(requests, details.GroupIds)
+		for _, ocid := range details.GroupIds {
+			if members[ocid] {
+				result.GroupIds = append(result.GroupIds, ocid)
+			}
+		}
+		lock.Unlock()
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(result)
+	}))
+	defer server.Close()
+
+	b, err := Backend()
+	if err != nil {
+		t.Fatal(err)
+	}
+	b.authenticationClient = newTestAuthenticationClient(t, server.URL)
+
+	principal := Principal{TenantId: common.String(testTenancyId), SubjectId: common.String(testInstanceId), Claims: []Claim{}}
+	matched, err := b.filterGroupMembership(context.Background(), "requestid", principal, ocids, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := []string{ocids[5], ocids[120], ocids[249]}; !reflect.DeepEqual(matched, expected) {
+		t.Fatalf("expected %v, got %v", expected, matched)
+	}
+	if len(requests) != 3 {
+		t.Fatalf("expected 3 requests, got %d", len(requests))
+	}
+	for _, groupIds := range requests {
+		if len(groupIds) > filterGroupMembershipChunkSize {
+			t.Fatalf("request exceeded the chunk size: %d", len(groupIds))
+		}
+	}
+
+	// When stopping on the first match, at least one match is returned
+	matched, err = b.filterGroupMembership(context.Background(), "requestid", principal, ocids, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(matched) == 0 {
+		t.Fatal("expected at least one match")
+	}
+
+	// No matches and no errors for a principal outside every group
+	lock.Lock()
+	members = map[string]bool{}
+	lock.Unlock()
+	matched, err = b.filterGroupMembership(context.Background(), "requestid", principal, ocids, true)
+	if err != nil || len(matched) != 0 {
+		t.Fatalf("expected no matches: matched:%v err:%v", matched, err)
+	}
+}
+
+func TestFilterGroupMembership_Error(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(`{"code":"InternalError","message":"Identity is unavailable"}`))
+	}))
+	defer server.Close()
+
+	b, err := Backend()
+	if err != nil {
+		t.Fatal(err)
+	}
+	b.authenticationClient = newTestAuthenticationClient(t, server.URL)
+
+	var ocids []string
+	for i := 0; i < 300; i++ {
+		ocids = append(ocids, fmt.Sprintf("ocid1.group.oc1..group%d", i))
+	}
+	principal := Principal{TenantId: common.String(testTenancyId), SubjectId: common.String(testInstanceId), Claims: []Claim{}}
+	if _, err := b.filterGroupMembership(context.Background(), "requestid", principal, ocids, false); err == nil {
+		t.Fatal("expected an error")
+	}
+}
+
+func TestFilterGroupMembership_Cancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// The context is cancelled while the first chunks are checked, so the chunks in flight are cancelled and the
+	// remaining chunks are never sent. Run with -race, this checks that the cancelled requests do not race.
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var details FilterGroupMembershipDetails
+		if err := json.NewDecoder(r.Body).Decode(&details); err != nil {
+			t.Errorf("bad request body: %v", err)
+		}
+		cancel()
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(FilterGroupMembershipResult{Principal: details.Principal, GroupIds: details.GroupIds})
+	}))
+	defer server.Close()
+
+	b, err := Backend()
+	if err != nil {
+		t.Fatal(err)
+	}
+	b.authenticationClient = newTestAuthenticationClient(t, server.URL)
+
+	var ocids []string
+	for i := 0; i < 1000; i++ {
+		ocids = append(ocids, fmt.Sprintf("ocid1.group.oc1..group%d", i))
+	}
+	principal := Principal{TenantId: common.String(testTenancyId), SubjectId: common.String(testInstanceId), Claims: []Claim{}}
+	if matched, err := b.filterGroupMembership(ctx, "requestid", principal, ocids, false); err == nil {
+		t.Fatalf("expected an error instead of a partial result: %d matches", len(matched))
+	}
+}
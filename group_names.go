@@ -0,0 +1,143 @@
+// Copyright © 2019, Oracle and/or its affiliates.
+package ociauth
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/oracle/oci-go-sdk/v65/common"
+	"github.com/oracle/oci-go-sdk/v65/identity"
+)
+
+// resolveGroupOcids returns a map of the given Group or Dynamic Group names to their OCIDs.
+// The names are looked up among the active groups of the tenancy, and must not be ambiguous.
+func (b *backend) resolveGroupOcids(ctx context.Context, requestId string, tenancyId string, names []string) (map[string]string, error) {
+	if len(names) == 0 {
+		return map[string]string{}, nil
+	}
+
+	if tenancyId == "" {
+		return nil, fmt.Errorf("%s must be configured to resolve group names", HomeTenancyIdConfigName)
+	}
+
+	identityClient, err := b.getIdentityClient()
+	if err != nil {
+		return nil, err
+	}
+
+	groupOcids := make(map[string]string, len(names))
+	for _, name := range names {
+		groups, err := identityClient.ListGroups(ctx, identity.ListGroupsRequest{
+			CompartmentId:  common.String(tenancyId),
+			Name:           common.String(name),
+			LifecycleState: identity.GroupLifecycleStateActive,
+			OpcRequestId:   common.String(requestId),
+		})
+		if err != nil {
+			return nil, fmt.Errorf("unable to list the groups named %q: %w", name, err)
+		}
+
+		dynamicGroups, err := identityClient.ListDynamicGroups(ctx, identity.ListDynamicGroupsRequest{
+			CompartmentId:  common.String(tenancyId),
+			Name:           common.String(name),
+			LifecycleState: identity.DynamicGroupLifecycleStateActive,
+			OpcRequestId:   common.String(requestId),
+		})
+		if err != nil {
+			return nil, fmt.Errorf("unable to list the dynamic groups named %q: %w", name, err)
+		}
+
+		var ocids []string
+		for _, group := range groups.Items {
+			if group.Id != nil {
+				ocids = append(ocids, *group.Id)
+			}
+		}
+		for _, dynamicGroup := range dynamicGroups.Items {
+			if dynamicGroup.Id != nil {
+				ocids = append(ocids, *dynamicGroup.Id)
+			}
+		}
+
+		switch len(ocids) {
+		case 0:
+			return nil, fmt.Errorf("no group or dynamic group is named %q", name)
+		case 1:
+		default:
+			return nil, fmt.Errorf("the group name %q is ambiguous, use the OCID of the group in ocid_list instead", name)
+		}
+
+		groupOcids[name] = ocids[0]
+
+		// The resolved names are also used to name group aliases
+		b.groupNameCache.Add(ocids[0], &groupNameCacheEntry{
+			name:   name,
+			expiry: time.Now().Add(groupNameCacheTTL),
+		})
+	}
+
+	return groupOcids, nil
+}
+
+// getGroup returns the name and compartment of the Group or Dynamic Group with the given OCID
+func (b *backend) getGroup(ctx context.Context, requestId string, groupId string) (string, string, error) {
+	identityClient, err := b.getIdentityClient()
+	if err != nil {
+		return "", "", err
+	}
+
+	var name, compartmentId *string
+	if strings.HasPrefix(groupId, ocidVersion+"."+ocidTypeDynamicGroup+".") {
+		response, err := identityClient.GetDynamicGroup(ctx, identity.GetDynamicGroupRequest{
+			DynamicGroupId: common.String(groupId),
+			OpcRequestId:   common.String(requestId),
+		})
+		if err != nil {
+			return "", "", err
+		}
+		name, compartmentId = response.Name, response.CompartmentId
+	} else {
+		response, err := identityClient.GetGroup(ctx, identity.GetGroupRequest{
+			GroupId:      common.String(groupId),
+			OpcRequestId: common.String(requestId),
+		})
+		if err != nil {
+			return "", "", err
+		}
+		name, compartmentId = response.Name, response.CompartmentId
+	}
+
+	if name == nil || *name == "" {
+		return "", "", fmt.Errorf("group %q has no name", groupId)
+	}
+	if compartmentId == nil {
+		compartmentId = common.String("")
+	}
+
+	return *name, *compartmentId, nil
+}
+
+// validateGroupsExist checks with Identity that each of the given Groups or Dynamic Groups exists in the tenancy
+func (b *backend) validateGroupsExist(ctx context.Context, requestId string, tenancyId string, groupIds []string) error {
+	if tenancyId == "" {
+		return fmt.Errorf("%s must be configured to validate the groups", HomeTenancyIdConfigName)
+	}
+
+	for _, groupId := range groupIds {
+		_, compartmentId, err := b.getGroup(ctx, requestId, groupId)
+		if serviceErr, ok := common.IsServiceError(err); ok && serviceErr.GetHTTPStatusCode() == http.StatusNotFound {
+			return fmt.Errorf("the group %q does not exist", groupId)
+		}
+		if err != nil {
+			return fmt.Errorf("unable to validate the group %q: %w", groupId, err)
+		}
+		if compartmentId != tenancyId {
+			return fmt.Errorf("the group %q is not in the home tenancy", groupId)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,127 @@
+// Copyright © 2019, Oracle and/or its affiliates.
+package ociauth
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/oracle/oci-go-sdk/v65/common"
+	"github.com/oracle/oci-go-sdk/v65/identity"
+)
+
+func TestBackend_GroupNames(t *testing.T) {
+	var lock sync.Mutex
+	groups := map[string]string{"admins": "ocid1.group.oc1..admins", "shared": "ocid1.group.oc1..shared"}
+	dynamicGroups := map[string]string{"instances": "ocid1.dynamicgroup.oc1..instances", "shared": "ocid1.dynamicgroup.oc1..shared"}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lock.Lock()
+		defer lock.Unlock()
+
+		if r.URL.Query().Get("compartmentId") != testTenancyId {
+			t.Errorf("unexpected compartment: %s", r.URL.RawQuery)
+		}
+		name := r.URL.Query().Get("name")
+		w.Header().Set("Content-Type", "application/json")
+		if strings.HasSuffix(r.URL.Path, "/dynamicGroups/") || strings.HasSuffix(r.URL.Path, "/dynamicGroups") {
+			items := []identity.DynamicGroup{}
+			if ocid, ok := dynamicGroups[name]; ok {
+				items = append(items, identity.DynamicGroup{Id: common.String(ocid), Name: common.String(name)})
+			}
+			json.NewEncoder(w).Encode(items)
+			return
+		}
+		items := []identity.Group{}
+		if ocid, ok := groups[name]; ok {
+			items = append(items, identity.Group{Id: common.String(ocid), Name: common.String(name)})
+		}
+		json.NewEncoder(w).Encode(items)
+	}))
+	defer server.Close()
+
+	config := logical.TestBackendConfig()
+	config.StorageView = &logical.InmemStorage{}
+	b, err := Backend()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Setup(context.Background(), config); err != nil {
+		t.Fatal(err)
+	}
+	b.identityClient = newTestIdentityClient(t, server.URL)
+
+	// Names can not be resolved without the home tenancy
+	if err := createRole(map[string]interface{}{"group_names": "admins"}, "devrole", b, config); err == nil {
+		t.Fatal("expected the role write to fail without a config")
+	}
+
+	if err := b.setOCIConfig(context.Background(), config.StorageView, &OCIConfigEntry{HomeTenancyId: testTenancyId}); err != nil {
+		t.Fatal(err)
+	}
+
+	roleData := map[string]interface{}{
+		"ocid_list":   "ocid1.group.oc1..other",
+		"group_names": "admins,instances",
+	}
+	if err := createRole(roleData, "devrole", b, config); err != nil {
+		t.Fatal(err)
+	}
+
+	readGroupNames := func() interface{} {
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.ReadOperation,
+			Path:      "role/devrole",
+			Storage:   config.StorageView,
+		})
+		if err != nil || resp == nil || resp.IsError() {
+			t.Fatalf("Role read failed. resp:%#v\n err:%v", resp, err)
+		}
+		return resp.Data["group_names"]
+	}
+
+	expected := map[string]string{"admins": "ocid1.group.oc1..admins", "instances": "ocid1.dynamicgroup.oc1..instances"}
+	if groupNames := readGroupNames(); !reflect.DeepEqual(groupNames, expected) {
+		t.Fatalf("unexpected group_names: %#v", groupNames)
+	}
+
+	roleEntry, err := b.getOCIRole(context.Background(), config.StorageView, "devrole")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectedOcids := []string{"ocid1.group.oc1..other", "ocid1.dynamicgroup.oc1..instances", "ocid1.group.oc1..admins"}
+	if ocids := roleEntry.roleOcids(); !reflect.DeepEqual(ocids, expectedOcids) {
+		t.Fatalf("unexpected role OCIDs: %v", ocids)
+	}
+
+	// A group is recreated with the same name
+	lock.Lock()
+	groups["admins"] = "ocid1.group.oc1..newadmins"
+	lock.Unlock()
+
+	resp, err := b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "role/devrole/refresh",
+		Storage:   config.StorageView,
+	})
+	if err != nil || resp == nil || resp.IsError() {
+		t.Fatalf("Role refresh failed. resp:%#v\n err:%v", resp, err)
+	}
+	expected["admins"] = "ocid1.group.oc1..newadmins"
+	if groupNames := readGroupNames(); !reflect.DeepEqual(groupNames, expected) {
+		t.Fatalf("unexpected group_names after refresh: %#v", groupNames)
+	}
+
+	// Unknown and ambiguous names are rejected
+	for _, name := range []string{"unknown", "shared"} {
+		if err := createRole(map[string]interface{}{"group_names": name}, "badrole", b, config); err == nil {
+			t.Fatalf("expected the group name %q to be rejected", name)
+		}
+	}
+}
@@ -0,0 +1,114 @@
+// Copyright © 2019, Oracle and/or its affiliates.
+package ociauth
+
+import (
+	"fmt"
+	"sort"
+)
+
+// These constants are the modes of group_match, and the operators of group_rule
+const (
+	groupMatchAny = "any"
+	groupMatchAll = "all"
+)
+
+// maxGroupRuleDepth is the number of levels that group rules can be nested
+const maxGroupRuleDepth = 5
+
+// groupRule is a nested AND/OR set of Group or Dynamic Group OCIDs. A principal matches an "all" rule if it is a member
+// of every group and matches every nested rule, and an "any" rule if it is a member of one of the groups or matches
+// one of the nested rules.
+type groupRule struct {
+	Operator string       `json:"operator"`
+	GroupIds []string     `json:"group_ids,omitempty"`
+	Rules    []*groupRule `json:"rules,omitempty"`
+}
+
+// parseGroupRule parses a group rule of the form {"all": [<OCID or rule>, ...]} or {"any": [<OCID or rule>, ...]}
+func parseGroupRule(input map[string]interface{}, depth int) (*groupRule, error) {
+	if depth > maxGroupRuleDepth {
+		return nil, fmt.Errorf("group_rule is nested more than %d levels", maxGroupRuleDepth)
+	}
+	if len(input) != 1 {
+		return nil, fmt.Errorf("a rule of group_rule must have exactly one of %q or %q", groupMatchAll, groupMatchAny)
+	}
+
+	rule := &groupRule{}
+	var terms interface{}
+	for operator, value := range input {
+		rule.Operator, terms = operator, value
+	}
+	if rule.Operator != groupMatchAll && rule.Operator != groupMatchAny {
+		return nil, fmt.Errorf("invalid operator %q in group_rule, expected %q or %q", rule.Operator, groupMatchAll, groupMatchAny)
+	}
+
+	termList, ok := terms.([]interface{})
+	if !ok || len(termList) == 0 {
+		return nil, fmt.Errorf("the %q of group_rule must be a non-empty list of OCIDs or rules", rule.Operator)
+	}
+	for _, term := range termList {
+		switch term := term.(type) {
+		case string:
+			if term == "" {
+				return nil, fmt.Errorf("group_rule contains an empty OCID")
+			}
+			rule.GroupIds = append(rule.GroupIds, term)
+		case map[string]interface{}:
+			nested, err := parseGroupRule(term, depth+1)
+			if err != nil {
+				return nil, err
+			}
+			rule.Rules = append(rule.Rules, nested)
+		default:
+			return nil, fmt.Errorf("the %q of group_rule must be a non-empty list of OCIDs or rules", rule.Operator)
+		}
+	}
+
+	return rule, nil
+}
+
+// matches evaluates the rule against the groups that the principal is a member of
+func (rule *groupRule) matches(groupIds map[string]string) bool {
+	all := rule.Operator == groupMatchAll
+	for _, groupId := range rule.GroupIds {
+		if _, present := groupIds[groupId]; present != all {
+			return !all
+		}
+	}
+	for _, nested := range rule.Rules {
+		if nested.matches(groupIds) != all {
+			return !all
+		}
+	}
+	return all
+}
+
+// ocids returns the sorted OCIDs of the rule and its nested rules, without duplicates
+func (rule *groupRule) ocids() []string {
+	ocids := make(map[string]string)
+	rule.addOcids(ocids)
+	ocidList := mapToSlice(ocids)
+	sort.Strings(ocidList)
+	return ocidList
+}
+
+func (rule *groupRule) addOcids(ocids map[string]string) {
+	addSliceToMap(rule.GroupIds, ocids)
+	for _, nested := range rule.Rules {
+		nested.addOcids(ocids)
+	}
+}
+
+// data returns the rule in the form it is written, for responses
+func (rule *groupRule) data() map[string]interface{} {
+	terms := make([]interface{}, 0, len(rule.GroupIds)+len(rule.Rules))
+	for _, groupId := range rule.GroupIds {
+		terms = append(terms, groupId)
+	}
+	for _, nested := range rule.Rules {
+		terms = append(terms, nested.data())
+	}
+	return map[string]interface{}{
+		rule.Operator: terms,
+	}
+}
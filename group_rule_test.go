@@ -0,0 +1,61 @@
+// Copyright © 2019, Oracle and/or its affiliates.
+package ociauth
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestGroupRule(t *testing.T) {
+	var input map[string]interface{}
+	if err := json.Unmarshal([]byte(`{"all": ["prod", {"any": ["pci", "audit"]}]}`), &input); err != nil {
+		t.Fatal(err)
+	}
+	rule, err := parseGroupRule(input, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(rule.data(), input) {
+		t.Fatalf("unexpected data: %#v", rule.data())
+	}
+	if !reflect.DeepEqual(rule.ocids(), []string{"audit", "pci", "prod"}) {
+		t.Fatalf("unexpected OCIDs: %#v", rule.ocids())
+	}
+
+	testCases := []struct {
+		groupIds []string
+		expected bool
+	}{
+		{[]string{"prod", "pci"}, true},
+		{[]string{"prod", "audit", "other"}, true},
+		{[]string{"prod"}, false},
+		{[]string{"pci", "audit"}, false},
+		{nil, false},
+	}
+	for _, tc := range testCases {
+		if matches := rule.matches(sliceToMap(tc.groupIds)); matches != tc.expected {
+			t.Fatalf("expected %v to match %v, got %v", tc.groupIds, tc.expected, matches)
+		}
+	}
+
+	invalidRules := []string{
+		`{}`,
+		`{"all": ["prod"], "any": ["pci"]}`,
+		`{"none": ["prod"]}`,
+		`{"all": []}`,
+		`{"all": "prod"}`,
+		`{"all": [""]}`,
+		`{"all": [1]}`,
+		`{"any": [{"any": [{"any": [{"any": [{"any": [{"any": ["prod"]}]}]}]}]}]}`,
+	}
+	for _, invalidRule := range invalidRules {
+		var input map[string]interface{}
+		if err := json.Unmarshal([]byte(invalidRule), &input); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := parseGroupRule(input, 1); err == nil {
+			t.Fatalf("expected %s to be rejected", invalidRule)
+		}
+	}
+}
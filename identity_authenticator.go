@@ -0,0 +1,23 @@
+// Copyright © 2019, Oracle and/or its affiliates.
+package ociauth
+
+import "context"
+
+// IdentityAuthenticator is the part of OCI Identity used by the backend to authenticate
+// login requests and to check the group membership of principals.
+// AuthenticationClient implements it.
+type IdentityAuthenticator interface {
+	AuthenticateClient(ctx context.Context, request AuthenticateClientRequest) (AuthenticateClientResponse, error)
+	FilterGroupMembership(ctx context.Context, request FilterGroupMembershipRequest) (FilterGroupMembershipResponse, error)
+}
+
+// BackendOption configures the backend returned by Backend
+type BackendOption func(b *backend)
+
+// WithIdentityAuthenticator makes the backend use the given IdentityAuthenticator instead of
+// an AuthenticationClient created with the instance principal of the Vault server
+func WithIdentityAuthenticator(authenticator IdentityAuthenticator) BackendOption {
+	return func(b *backend) {
+		b.authenticationClient = authenticator
+	}
+}
@@ -0,0 +1,247 @@
+// Copyright © 2019, Oracle and/or its affiliates.
+package ociauth
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	lru "github.com/hashicorp/golang-lru"
+	"github.com/oracle/oci-go-sdk/v65/common"
+	"github.com/oracle/oci-go-sdk/v65/common/auth"
+	"github.com/oracle/oci-go-sdk/v65/core"
+)
+
+// These constants store the defaults of the instance cache
+const (
+	instanceCacheSize = 4096
+
+	// The tags of an instance are used to authorize its logins, so they are cached for a short time
+	instanceCacheTTL = 5 * time.Minute
+)
+
+// These constants are the prefixes of the claims and the token metadata of the tags of an instance:
+// tag.<namespace>.<key> for defined tags, as in the matching rules of OCI dynamic groups, and freeform_tag.<key>.
+// The names of tags are not case sensitive in OCI, so they are lowercased.
+const (
+	definedTagClaimPrefix  = "tag."
+	freeformTagClaimPrefix = "freeform_tag."
+)
+
+// instanceCacheEntry is the tags of an instance looked up through the Compute API
+type instanceCacheEntry struct {
+	definedTags  map[string]string
+	freeformTags map[string]string
+	expiry       time.Time
+}
+
+func newInstanceCache() *lru.Cache {
+	cache, _ := lru.New(instanceCacheSize)
+	return cache
+}
+
+// definedTagClaim returns the claim of the defined tag <namespace>.<key>
+func definedTagClaim(tag string) string {
+	return definedTagClaimPrefix + strings.ToLower(tag)
+}
+
+// freeformTagClaim returns the claim of the freeform tag
+func freeformTagClaim(key string) string {
+	return freeformTagClaimPrefix + strings.ToLower(key)
+}
+
+// addInstanceTags looks up the instance of an instance principal with the Compute API, if instance_lookup is
+// enabled, and adds its tags to the claims so that roles can be bound to them. Principals that are not instances
+// are left as they are.
+func (b *backend) addInstanceTags(ctx context.Context, requestId string, configEntry *OCIConfigEntry, claims InternalClaims) error {
+	if !configEntry.instanceLookup() || claims.GetString(ClaimPrincipalType) != PrincipalTypeInstance {
+		return nil
+	}
+	instanceId := claims.GetString(ClaimInstance)
+	if instanceId == "" {
+		return nil
+	}
+
+	instance, err := b.getInstance(ctx, requestId, configEntry, instanceId)
+	if err != nil {
+		b.Logger().Warn("Unable to look up the instance", "id", requestId, "instance", instanceId, "err", err)
+		return err
+	}
+
+	for tag, value := range instance.definedTags {
+		key := definedTagClaim(tag)
+		claims[key] = []InternalClaim{{Key: key, Value: value}}
+	}
+	for tag, value := range instance.freeformTags {
+		key := freeformTagClaim(tag)
+		claims[key] = []InternalClaim{{Key: key, Value: value}}
+	}
+	return nil
+}
+
+// instanceTagMetadata returns the tags that were added to the claims, as token metadata
+func instanceTagMetadata(claims InternalClaims) map[string]string {
+	metadata := make(map[string]string)
+	for key := range claims {
+		if isInstanceTagName(key) {
+			metadata[key] = claims.GetString(key)
+		}
+	}
+	return metadata
+}
+
+// getInstance returns the tags of the instance with the given OCID, from the cache if possible
+func (b *backend) getInstance(ctx context.Context, requestId string, configEntry *OCIConfigEntry, instanceId string) (*instanceCacheEntry, error) {
+	if value, ok := b.instanceCache.Get(instanceId); ok {
+		entry := value.(*instanceCacheEntry)
+		if time.Now().Before(entry.expiry) {
+			emitCacheMetrics(metricCacheInstance, true)
+			return entry, nil
+		}
+	}
+	emitCacheMetrics(metricCacheInstance, false)
+
+	computeClient, err := b.getComputeClient(configEntry.ComputeEndpoint, instanceRegion(instanceId))
+	if err != nil {
+		return nil, err
+	}
+
+	response, err := computeClient.GetInstance(ctx, core.GetInstanceRequest{
+		InstanceId:   common.String(instanceId),
+		OpcRequestId: common.String(requestId),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	entry := &instanceCacheEntry{
+		definedTags:  make(map[string]string),
+		freeformTags: make(map[string]string),
+		expiry:       time.Now().Add(instanceCacheTTL),
+	}
+	for namespace, tags := range response.DefinedTags {
+		for key, value := range tags {
+			entry.definedTags[namespace+"."+key] = fmt.Sprint(value)
+		}
+	}
+	for key, value := range response.FreeformTags {
+		entry.freeformTags[key] = value
+	}
+	b.instanceCache.Add(instanceId, entry)
+
+	return entry, nil
+}
+
+// instanceRegion returns the region of an instance from its OCID, or an empty string if the OCID has none
+func instanceRegion(instanceId string) string {
+	parts := strings.Split(instanceId, ".")
+	if len(parts) < 5 {
+		return ""
+	}
+	return parts[3]
+}
+
+// getComputeClient returns a client of the Compute API in the region of the instance, or at the configured endpoint
+func (b *backend) getComputeClient(endpoint string, region string) (*core.ComputeClient, error) {
+	b.computeClientMutex.Lock()
+	defer b.computeClientMutex.Unlock()
+
+	clientKey := endpoint
+	if clientKey == "" {
+		clientKey = region
+	}
+	if client, ok := b.computeClients[clientKey]; ok {
+		return client, nil
+	}
+
+	configurationProvider := b.computeConfigurationProvider
+	if configurationProvider == nil {
+		// Create the instance principal provider
+		ip, err := auth.InstancePrincipalConfigurationProvider()
+		if err != nil {
+			b.Logger().Debug("Unable to create InstancePrincipalConfigurationProvider", "err", err)
+			return nil, fmt.Errorf("unable to create InstancePrincipalConfigurationProvider")
+		}
+		configurationProvider = ip
+	}
+
+	computeClient, err := core.NewComputeClientWithConfigurationProvider(configurationProvider)
+	if err != nil {
+		b.Logger().Debug("Unable to create computeClient", "err", err)
+		return nil, fmt.Errorf("unable to create computeClient")
+	}
+	if endpoint != "" {
+		computeClient.Host = endpoint
+	} else if region != "" {
+		computeClient.SetRegion(string(common.StringToRegion(region)))
+	}
+
+	if b.computeClients == nil {
+		b.computeClients = make(map[string]*core.ComputeClient)
+	}
+	b.computeClients[clientKey] = &computeClient
+
+	return &computeClient, nil
+}
+
+// tagBindings returns the bindings of the role to the defined and freeform tags of instances
+func (roleEntry *OCIRoleEntry) tagBindings() []roleBinding {
+	var bindings []roleBinding
+	for _, tag := range sortedKeys(roleEntry.BoundDefinedTags) {
+		value := roleEntry.BoundDefinedTags[tag]
+		bindings = append(bindings, roleBinding{
+			field:      "bound_defined_tags",
+			claim:      definedTagClaim(tag),
+			values:     []string{value},
+			errMessage: fmt.Sprintf("Entity does not have the Role defined tag %s=%s", tag, value),
+		})
+	}
+	for _, key := range sortedKeys(roleEntry.BoundFreeformTags) {
+		value := roleEntry.BoundFreeformTags[key]
+		bindings = append(bindings, roleBinding{
+			field:      "bound_freeform_tags",
+			claim:      freeformTagClaim(key),
+			values:     []string{value},
+			errMessage: fmt.Sprintf("Entity does not have the Role freeform tag %s=%s", key, value),
+		})
+	}
+	return bindings
+}
+
+// hasTagBindings returns true if the role is bound to the tags of instances, which need the instance lookup
+func (roleEntry *OCIRoleEntry) hasTagBindings() bool {
+	return len(roleEntry.BoundDefinedTags) > 0 || len(roleEntry.BoundFreeformTags) > 0
+}
+
+// parseTagBindings validates the tags of bound_defined_tags, whose names are of the form <namespace>.<key>, or of
+// bound_freeform_tags, and returns them with lowercased names
+func parseTagBindings(field string, tags map[string]string) (map[string]string, error) {
+	parsed := make(map[string]string, len(tags))
+	for tag, value := range tags {
+		if field == "bound_defined_tags" {
+			namespace, key, found := strings.Cut(tag, ".")
+			if !found || namespace == "" || key == "" || strings.Contains(key, ".") {
+				return nil, fmt.Errorf("invalid defined tag %q in %s, expected <namespace>.<key>", tag, field)
+			}
+		} else if tag == "" {
+			return nil, fmt.Errorf("%s contains an empty tag", field)
+		}
+		if value == "" {
+			return nil, fmt.Errorf("the tag %q of %s has no value", tag, field)
+		}
+		parsed[strings.ToLower(tag)] = value
+	}
+	return parsed, nil
+}
+
+// sortedKeys returns the sorted keys of the map
+func sortedKeys(input map[string]string) []string {
+	keys := make([]string, 0, len(input))
+	for key := range input {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,211 @@
+// Copyright © 2019, Oracle and/or its affiliates.
+package ociauth
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/oracle/oci-go-sdk/v65/common"
+	"github.com/oracle/oci-go-sdk/v65/core"
+)
+
+func TestParseTagBindings(t *testing.T) {
+	tags, err := parseTagBindings("bound_defined_tags", map[string]string{"Operations.Team": "payments"})
+	if err != nil || !reflect.DeepEqual(tags, map[string]string{"operations.team": "payments"}) {
+		t.Fatalf("unexpected tags: %#v %v", tags, err)
+	}
+
+	invalidTags := []map[string]string{
+		{"team": "payments"},
+		{"operations.": "payments"},
+		{".team": "payments"},
+		{"operations.team.name": "payments"},
+		{"operations.team": ""},
+	}
+	for _, tags := range invalidTags {
+		if _, err := parseTagBindings("bound_defined_tags", tags); err == nil {
+			t.Fatalf("expected %v to be rejected", tags)
+		}
+	}
+	if _, err := parseTagBindings("bound_freeform_tags", map[string]string{"": "payments"}); err == nil {
+		t.Fatal("expected an empty freeform tag to be rejected")
+	}
+}
+
+func TestBackend_InstanceTags(t *testing.T) {
+	var instanceRequests, failures int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&instanceRequests, 1)
+		if atomic.LoadInt32(&failures) > 0 {
+			atomic.AddInt32(&failures, -1)
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if !strings.HasSuffix(r.URL.Path, "/instances/"+testInstanceId) {
+			t.Errorf("unexpected path: %s", r.URL.Path)
+		}
+		json.NewEncoder(w).Encode(core.Instance{
+			Id:            common.String(testInstanceId),
+			CompartmentId: common.String(testCompartmentId),
+			DefinedTags:   map[string]map[string]interface{}{"Operations": {"Team": "payments", "Tier": 2}},
+			FreeformTags:  map[string]string{"env": "prod"},
+		})
+	}))
+	defer server.Close()
+
+	b, config, login := newTestLocalLoginBackend(t, nil)
+	b.computeConfigurationProvider = newTestConfigurationProvider(t)
+
+	writeRole := func(name string, data map[string]interface{}) *logical.Response {
+		data["token_policies"] = "default"
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.CreateOperation,
+			Path:      "role/" + name,
+			Storage:   config.StorageView,
+			Data:      data,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp
+	}
+
+	// The role warns that its tags are not known until the lookup is enabled, and can not be taken
+	resp := writeRole("tagrole", map[string]interface{}{
+		"bound_defined_tags":  map[string]interface{}{"operations.team": "payments"},
+		"bound_freeform_tags": map[string]interface{}{"Env": "prod"},
+	})
+	if resp == nil || resp.IsError() || len(resp.Warnings) != 1 || !strings.Contains(resp.Warnings[0], InstanceLookupConfigName) {
+		t.Fatalf("expected a warning: %#v", resp)
+	}
+	if code, errorCode := loginErrorCode(t, login("tagrole")); code != http.StatusForbidden || errorCode != LoginErrorBindingFailed {
+		t.Fatalf("expected the binding to fail: %d %s", code, errorCode)
+	}
+	if atomic.LoadInt32(&instanceRequests) != 0 {
+		t.Fatalf("expected no instance lookup: %d", instanceRequests)
+	}
+
+	resp, err := b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "config",
+		Storage:   config.StorageView,
+		Data: map[string]interface{}{
+			InstanceLookupConfigName:  true,
+			ComputeEndpointConfigName: server.URL + "/",
+		},
+	})
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("Config update failed. resp:%#v\n err:%v", resp, err)
+	}
+
+	for i := 0; i < 2; i++ {
+		resp = login("tagrole")
+		if resp == nil || resp.Auth == nil {
+			t.Fatalf("expected login to succeed: %#v", resp)
+		}
+	}
+	expectedMetadata := map[string]string{
+		"role_name":           "tagrole",
+		"tag.operations.team": "payments",
+		"tag.operations.tier": "2",
+		"freeform_tag.env":    "prod",
+	}
+	if !reflect.DeepEqual(resp.Auth.Metadata, expectedMetadata) {
+		t.Fatalf("unexpected metadata: %#v", resp.Auth.Metadata)
+	}
+	if atomic.LoadInt32(&instanceRequests) != 1 {
+		t.Fatalf("expected the instance to be cached: %d", instanceRequests)
+	}
+
+	// The tags can be used by matching rules, and a tag with another value fails the binding
+	writeRole("rulerole", map[string]interface{}{"matching_rule": "tag.operations.tier.value = '2'"})
+	if resp := login("rulerole"); resp == nil || resp.Auth == nil {
+		t.Fatalf("expected login to succeed: %#v", resp)
+	}
+	writeRole("otherrole", map[string]interface{}{"bound_defined_tags": map[string]interface{}{"operations.team": "billing"}})
+	if code, errorCode := loginErrorCode(t, login("otherrole")); code != http.StatusForbidden || errorCode != LoginErrorBindingFailed {
+		t.Fatalf("expected the binding to fail: %d %s", code, errorCode)
+	}
+
+	// If the lookup fails, only the roles bound to tags can not be taken
+	if _, err := b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "cache/purge",
+		Storage:   config.StorageView,
+	}); err != nil {
+		t.Fatal(err)
+	}
+	atomic.StoreInt32(&failures, 100)
+	if code, errorCode := loginErrorCode(t, login("tagrole")); code != http.StatusServiceUnavailable || errorCode != LoginErrorIdentityUnavailable {
+		t.Fatalf("expected the lookup to fail: %d %s", code, errorCode)
+	}
+	writeRole("plainrole", map[string]interface{}{"bound_compartment_ids": testCompartmentId})
+	if resp := login("plainrole"); resp == nil || resp.Auth == nil {
+		t.Fatalf("expected login to succeed: %#v", resp)
+	}
+	atomic.StoreInt32(&failures, 0)
+
+	resp, err = b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      "role/tagrole",
+		Storage:   config.StorageView,
+	})
+	if err != nil || resp == nil || resp.IsError() {
+		t.Fatalf("Role read failed. resp:%#v\n err:%v", resp, err)
+	}
+	if !reflect.DeepEqual(resp.Data["bound_defined_tags"], map[string]string{"operations.team": "payments"}) ||
+		!reflect.DeepEqual(resp.Data["bound_freeform_tags"], map[string]string{"env": "prod"}) {
+		t.Fatalf("unexpected role: %#v", resp.Data)
+	}
+
+	resp, err = b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "config",
+		Storage:   config.StorageView,
+		Data:      map[string]interface{}{ComputeEndpointConfigName: "compute.example.com"},
+	})
+	if err != nil || resp == nil || !resp.IsError() {
+		t.Fatalf("expected the endpoint to be rejected: %#v %v", resp, err)
+	}
+}
+
+func TestBackend_ForgedInstanceTags(t *testing.T) {
+	fake := newFakeIdentity(t)
+	b, config := initOfflineTest(t, fake, "ocid1.group.oc1..rolegroup")
+	if err := createRole(map[string]interface{}{
+		"bound_defined_tags":  map[string]interface{}{"operations.team": "payments"},
+		"bound_freeform_tags": map[string]interface{}{"env": "prod"},
+		"token_policies":      "default",
+	}, "tagrole", b, config); err != nil {
+		t.Fatal(err)
+	}
+
+	// The tags carried by the principal are not trusted, only the tags looked up with the Compute API are
+	headers, keyId := signedAPIKeyHeaders(t, PathVersionBase+fmt.Sprintf(PathBaseFormat, "oci", "tagrole"))
+	fake.addPrincipal(keyId, newFakePrincipal(testInstanceId, PrincipalTypeInstance, map[string]string{
+		ClaimInstance:         testInstanceId,
+		"tag.operations.team": "payments",
+		"freeform_tag.env":    "prod",
+	}))
+	resp, err := b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "login/tagrole",
+		Storage:   config.StorageView,
+		Data:      map[string]interface{}{"request_headers": headers},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if code, errorCode := loginErrorCode(t, resp); code != http.StatusForbidden || errorCode != LoginErrorBindingFailed {
+		t.Fatalf("expected the binding to fail: %d %s", code, errorCode)
+	}
+}
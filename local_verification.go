@@ -0,0 +1,407 @@
+// Copyright © 2019, Oracle and/or its affiliates.
+package ociauth
+
+import (
+	"crypto"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/go-secure-stdlib/strutil"
+	"github.com/oracle/oci-go-sdk/v65/common"
+)
+
+// These constants store information about the security token carried by instance principal requests
+const (
+	// securityTokenKeyIdPrefix is the keyId prefix used when a request is signed with a security token
+	securityTokenKeyIdPrefix = "ST$"
+
+	// maxRequestClockSkew is the maximum accepted difference between the signed Date header and the local clock
+	maxRequestClockSkew = 5 * time.Minute
+)
+
+// These constants define the claims of the security token that are used for local verification
+const (
+	ClaimSubject     = "sub"
+	ClaimIssuer      = "iss"
+	ClaimTenant      = "opc-tenant"
+	ClaimCompartment = "opc-compartment"
+	ClaimInstance    = "opc-instance"
+	claimJWK         = "jwk"
+	claimExpiry      = "exp"
+	claimNotBefore   = "nbf"
+)
+
+// requiredSignedHeaders are the headers that must be covered by the signature of a locally verified request
+var requiredSignedHeaders = []string{HdrRequestTarget, "host", "date"}
+
+var signatureParamRegex = regexp.MustCompile(`([a-zA-Z]+)="([^"]*)"`)
+
+// securityTokenHeader is the JOSE header of a security token
+type securityTokenHeader struct {
+	Algorithm string   `json:"alg"`
+	KeyId     string   `json:"kid"`
+	X5c       []string `json:"x5c"`
+}
+
+// sessionKey is the JWK of the session key that signs the request
+type sessionKey struct {
+	KeyType  string `json:"kty"`
+	Modulus  string `json:"n"`
+	Exponent string `json:"e"`
+}
+
+// parseCABundle parses a PEM encoded bundle of certificates
+func parseCABundle(bundle string) ([]*x509.Certificate, error) {
+	var certs []*x509.Certificate
+	rest := []byte(bundle)
+	for {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		certs = append(certs, cert)
+	}
+
+	if len(certs) == 0 {
+		return nil, fmt.Errorf("no certificates found in the CA bundle")
+	}
+	return certs, nil
+}
+
+// parseSignatureParams returns the parameters of the Signature Authorization header
+func parseSignatureParams(requestHeaders http.Header) (map[string]string, error) {
+	authorization := requestHeaders.Get("Authorization")
+	if !strings.HasPrefix(authorization, "Signature ") {
+		return nil, fmt.Errorf("no signature specified in header")
+	}
+
+	params := make(map[string]string)
+	for _, match := range signatureParamRegex.FindAllStringSubmatch(authorization, -1) {
+		params[match[1]] = match[2]
+	}
+
+	for _, name := range []string{"headers", "keyId", "algorithm", "signature"} {
+		if params[name] == "" {
+			return nil, fmt.Errorf("incorrect signature specified in header")
+		}
+	}
+	return params, nil
+}
+
+// securityTokenFromKeyId returns the security token carried by a keyId, if any
+func securityTokenFromKeyId(keyId string) (string, bool) {
+	if !strings.HasPrefix(keyId, securityTokenKeyIdPrefix) {
+		return "", false
+	}
+	return strings.TrimPrefix(keyId, securityTokenKeyIdPrefix), true
+}
+
+// decodeSecurityToken splits a security token into its header and claims, without verifying it
+func decodeSecurityToken(token string) (header securityTokenHeader, claims map[string]interface{}, err error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return header, nil, fmt.Errorf("malformed security token")
+	}
+
+	headerBytes, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return header, nil, fmt.Errorf("malformed security token header")
+	}
+	if err := json.Unmarshal(headerBytes, &header); err != nil {
+		return header, nil, fmt.Errorf("malformed security token header")
+	}
+
+	claimBytes, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return header, nil, fmt.Errorf("malformed security token claims")
+	}
+	if err := json.Unmarshal(claimBytes, &claims); err != nil {
+		return header, nil, fmt.Errorf("malformed security token claims")
+	}
+
+	return header, claims, nil
+}
+
+// verifySecurityToken verifies the signature and validity of a security token against the trusted certificates.
+// If the token header carries a certificate chain, the chain must verify up to one of the trusted certificates;
+// otherwise the token must be signed directly by one of the trusted certificates. In both cases the signer must be
+// the token signing certificate named signerName: the certificates of instances also chain up to the trusted CAs, and
+// must not be able to sign tokens with claims of their choice.
+func verifySecurityToken(token string, trusted []*x509.Certificate, signerName string, now time.Time) (map[string]interface{}, error) {
+	header, claims, err := decodeSecurityToken(token)
+	if err != nil {
+		return nil, err
+	}
+	if header.Algorithm != "RS256" {
+		return nil, fmt.Errorf("unsupported security token algorithm %q", header.Algorithm)
+	}
+
+	parts := strings.Split(token, ".")
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("malformed security token signature")
+	}
+	hashed := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+
+	var signers []*x509.Certificate
+	if len(header.X5c) > 0 {
+		leaf, err := verifyCertificateChain(header.X5c, trusted, now)
+		if err != nil {
+			return nil, err
+		}
+		signers = []*x509.Certificate{leaf}
+	} else {
+		signers = trusted
+	}
+
+	verified := false
+	for _, cert := range signers {
+		if !isTokenSigner(cert, signerName) {
+			continue
+		}
+		publicKey, ok := cert.PublicKey.(*rsa.PublicKey)
+		if !ok {
+			continue
+		}
+		if rsa.VerifyPKCS1v15(publicKey, crypto.SHA256, hashed[:], signature) == nil {
+			verified = true
+			break
+		}
+	}
+	if !verified {
+		return nil, fmt.Errorf("security token signature is invalid")
+	}
+
+	if exp, ok := claims[claimExpiry].(float64); !ok || now.After(time.Unix(int64(exp), 0)) {
+		return nil, fmt.Errorf("security token is expired")
+	}
+	if nbf, ok := claims[claimNotBefore].(float64); ok && now.Before(time.Unix(int64(nbf), 0)) {
+		return nil, fmt.Errorf("security token is not yet valid")
+	}
+
+	return claims, nil
+}
+
+// isTokenSigner returns true if the certificate is the token signing certificate with the given common name
+func isTokenSigner(cert *x509.Certificate, signerName string) bool {
+	return signerName != "" && !cert.IsCA && cert.Subject.CommonName == signerName
+}
+
+// verifyCertificateChain verifies a base64 DER encoded chain of certificates up to one of the trusted certificates and
+// returns the leaf certificate.
+func verifyCertificateChain(chain []string, trusted []*x509.Certificate, now time.Time) (*x509.Certificate, error) {
+	roots := x509.NewCertPool()
+	for _, cert := range trusted {
+		roots.AddCert(cert)
+	}
+
+	intermediates := x509.NewCertPool()
+	var leaf *x509.Certificate
+	for i, encoded := range chain {
+		der, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, fmt.Errorf("malformed certificate in security token")
+		}
+		cert, err := x509.ParseCertificate(der)
+		if err != nil {
+			return nil, fmt.Errorf("malformed certificate in security token")
+		}
+		if i == 0 {
+			leaf = cert
+		} else {
+			intermediates.AddCert(cert)
+		}
+	}
+
+	_, err := leaf.Verify(x509.VerifyOptions{
+		Roots:         roots,
+		Intermediates: intermediates,
+		CurrentTime:   now,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("security token certificate chain is not trusted: %w", err)
+	}
+	return leaf, nil
+}
+
+// sessionPublicKey returns the public key of the session key embedded in the security token claims
+func sessionPublicKey(claims map[string]interface{}) (*rsa.PublicKey, error) {
+	var jwk sessionKey
+	switch value := claims[claimJWK].(type) {
+	case string:
+		if err := json.Unmarshal([]byte(value), &jwk); err != nil {
+			return nil, fmt.Errorf("malformed session key in security token")
+		}
+	case map[string]interface{}:
+		encoded, err := json.Marshal(value)
+		if err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(encoded, &jwk); err != nil {
+			return nil, fmt.Errorf("malformed session key in security token")
+		}
+	default:
+		return nil, fmt.Errorf("no session key in security token")
+	}
+
+	if jwk.KeyType != "RSA" {
+		return nil, fmt.Errorf("unsupported session key type %q", jwk.KeyType)
+	}
+	modulus, err := base64.RawURLEncoding.DecodeString(jwk.Modulus)
+	if err != nil {
+		return nil, fmt.Errorf("malformed session key in security token")
+	}
+	exponent, err := base64.RawURLEncoding.DecodeString(jwk.Exponent)
+	if err != nil || len(exponent) == 0 || len(exponent) > 4 {
+		return nil, fmt.Errorf("malformed session key in security token")
+	}
+
+	return &rsa.PublicKey{
+		N: new(big.Int).SetBytes(modulus),
+		E: int(new(big.Int).SetBytes(exponent).Int64()),
+	}, nil
+}
+
+// verifyRequestSignature verifies the signature of the signed request headers with the given public key
+func verifyRequestSignature(requestHeaders http.Header, params map[string]string, publicKey *rsa.PublicKey, now time.Time) error {
+	if strings.ToLower(params["algorithm"]) != "rsa-sha256" {
+		return fmt.Errorf("unsupported signature algorithm %q", params["algorithm"])
+	}
+
+	signedHeaders := strings.Fields(strings.ToLower(params["headers"]))
+	signingParts := make([]string, 0, len(signedHeaders))
+	signed := make(map[string]bool, len(signedHeaders))
+	for _, name := range signedHeaders {
+		var value string
+		switch name {
+		case HdrRequestTarget:
+			if len(requestHeaders[HdrRequestTarget]) == 0 {
+				return fmt.Errorf("no (request-target) specified in header")
+			}
+			value = requestHeaders[HdrRequestTarget][0]
+		default:
+			value = requestHeaders.Get(name)
+		}
+		signed[name] = true
+		signingParts = append(signingParts, fmt.Sprintf("%s: %s", name, value))
+	}
+	// Without these headers, a signature of any other request of the principal could be replayed to log in
+	for _, name := range requiredSignedHeaders {
+		if !signed[name] {
+			return fmt.Errorf("%s header is not signed", name)
+		}
+	}
+
+	date, err := http.ParseTime(requestHeaders.Get("Date"))
+	if err != nil {
+		return fmt.Errorf("incorrect date specified in header")
+	}
+	if skew := now.Sub(date); skew > maxRequestClockSkew || skew < -maxRequestClockSkew {
+		return fmt.Errorf("date specified in header is outside the allowed clock skew")
+	}
+
+	signature, err := base64.StdEncoding.DecodeString(params["signature"])
+	if err != nil {
+		return fmt.Errorf("incorrect signature specified in header")
+	}
+	hashed := sha256.Sum256([]byte(strings.Join(signingParts, "\n")))
+	if err := rsa.VerifyPKCS1v15(publicKey, crypto.SHA256, hashed[:], signature); err != nil {
+		return fmt.Errorf("request signature is invalid")
+	}
+
+	return nil
+}
+
+// principalFromSecurityToken builds a Principal from the verified claims of a security token
+func principalFromSecurityToken(claims map[string]interface{}) (*Principal, error) {
+	subjectId, _ := claims[ClaimSubject].(string)
+	tenantId, _ := claims[ClaimTenant].(string)
+	if subjectId == "" || tenantId == "" {
+		return nil, fmt.Errorf("security token is missing the subject or tenant")
+	}
+	issuer, _ := claims[ClaimIssuer].(string)
+
+	principal := &Principal{
+		TenantId:  &tenantId,
+		SubjectId: &subjectId,
+	}
+	for key, value := range claims {
+		stringValue, ok := value.(string)
+		if !ok || key == claimJWK {
+			continue
+		}
+		principal.Claims = append(principal.Claims, Claim{
+			Key:    common.String(key),
+			Value:  common.String(stringValue),
+			Issuer: common.String(issuer),
+		})
+	}
+	return principal, nil
+}
+
+// authenticateInstancePrincipalLocally verifies an instance principal request against the trusted CA bundle and token
+// signer without calling OCI Identity, and checks that it was signed for one of the hosts. It returns false if the
+// request is not signed with an instance principal security token, in which case the caller must authenticate the
+// request with Identity.
+func authenticateInstancePrincipalLocally(requestHeaders http.Header, caBundle string, signerName string, hosts []string, now time.Time) (*Principal, bool, error) {
+	params, err := parseSignatureParams(requestHeaders)
+	if err != nil {
+		return nil, false, nil
+	}
+	token, ok := securityTokenFromKeyId(params["keyId"])
+	if !ok {
+		return nil, false, nil
+	}
+	_, unverifiedClaims, err := decodeSecurityToken(token)
+	if err != nil {
+		return nil, true, err
+	}
+	if ptype, _ := unverifiedClaims[ClaimPrincipalType].(string); ptype != PrincipalTypeInstance {
+		return nil, false, nil
+	}
+
+	trusted, err := parseCABundle(caBundle)
+	if err != nil {
+		return nil, true, err
+	}
+	claims, err := verifySecurityToken(token, trusted, signerName, now)
+	if err != nil {
+		return nil, true, err
+	}
+	publicKey, err := sessionPublicKey(claims)
+	if err != nil {
+		return nil, true, err
+	}
+	if err := verifyRequestSignature(requestHeaders, params, publicKey, now); err != nil {
+		return nil, true, err
+	}
+	// The host header is signed, so a request signed for another service can not be replayed here
+	if host := requestHeaders.Get("host"); !strutil.StrListContains(hosts, strings.ToLower(host)) {
+		return nil, true, fmt.Errorf("request is signed for host %q", host)
+	}
+
+	principal, err := principalFromSecurityToken(claims)
+	if err != nil {
+		return nil, true, err
+	}
+	return principal, true, nil
+}
@@ -0,0 +1,398 @@
+// Copyright © 2019, Oracle and/or its affiliates.
+package ociauth
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+const (
+	testTenancyId     = "ocid1.tenancy.oc1..testtenancy"
+	testCompartmentId = "ocid1.compartment.oc1..testcompartment"
+	testInstanceId    = "ocid1.instance.oc1.phx.testinstance"
+	testTokenSigner   = "Test Token Signer"
+	testHost          = "127.0.0.1"
+)
+
+// testCA is a certificate authority that issues instance principal security tokens for tests
+type testCA struct {
+	cert       *x509.Certificate
+	key        *rsa.PrivateKey
+	signerCert *x509.Certificate
+	signerKey  *rsa.PrivateKey
+}
+
+func newTestCA(t *testing.T) *testCA {
+	t.Helper()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "Test Intermediate CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ca := &testCA{cert: cert, key: key}
+	ca.signerCert, ca.signerKey = ca.issueCertificate(t, testTokenSigner)
+	return ca
+}
+
+// issueCertificate issues a leaf certificate with the given common name
+func (ca *testCA) issueCertificate(t *testing.T, commonName string) (*x509.Certificate, *rsa.PrivateKey) {
+	t.Helper()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: commonName},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert, key
+}
+
+func (ca *testCA) bundle() string {
+	return string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ca.cert.Raw}))
+}
+
+// securityToken issues a security token for the given session key and claims
+func (ca *testCA) securityToken(t *testing.T, sessionKey *rsa.PrivateKey, claims map[string]interface{}) string {
+	t.Helper()
+	return signSecurityToken(t, ca.signerCert, ca.signerKey, sessionKey, claims)
+}
+
+// signSecurityToken signs a security token with the given key. The certificate is carried in the token header if it
+// is set.
+func signSecurityToken(t *testing.T, cert *x509.Certificate, key *rsa.PrivateKey, sessionKey *rsa.PrivateKey, claims map[string]interface{}) string {
+	t.Helper()
+
+	jwk, err := json.Marshal(map[string]string{
+		"kty": "RSA",
+		"n":   base64.RawURLEncoding.EncodeToString(sessionKey.PublicKey.N.Bytes()),
+		"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(sessionKey.PublicKey.E)).Bytes()),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	allClaims := map[string]interface{}{
+		ClaimSubject:       testInstanceId,
+		ClaimIssuer:        "authService.oracle.com",
+		ClaimPrincipalType: PrincipalTypeInstance,
+		ClaimTenant:        testTenancyId,
+		ClaimCompartment:   testCompartmentId,
+		ClaimInstance:      testInstanceId,
+		claimJWK:           string(jwk),
+		claimExpiry:        time.Now().Add(time.Hour).Unix(),
+	}
+	for key, value := range claims {
+		allClaims[key] = value
+	}
+
+	tokenHeader := map[string]interface{}{
+		"alg": "RS256",
+		"kid": "asw",
+	}
+	if cert != nil {
+		tokenHeader["x5c"] = []string{base64.StdEncoding.EncodeToString(cert.Raw)}
+	}
+	header, err := json.Marshal(tokenHeader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	payload, err := json.Marshal(allClaims)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	signingInput := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	hashed := sha256.Sum256([]byte(signingInput))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, hashed[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+// signedInstanceHeaders returns the request headers of a login request signed with the session key
+func signedInstanceHeaders(t *testing.T, token string, sessionKey *rsa.PrivateKey, requestTarget string) http.Header {
+	t.Helper()
+
+	headers := http.Header{}
+	headers.Set("Date", time.Now().UTC().Format(http.TimeFormat))
+	headers.Set("Host", testHost)
+	headers[HdrRequestTarget] = []string{requestTarget}
+
+	signingString := strings.Join([]string{
+		"date: " + headers.Get("Date"),
+		"(request-target): " + requestTarget,
+		"host: " + headers.Get("Host"),
+	}, "\n")
+	hashed := sha256.Sum256([]byte(signingString))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, sessionKey, crypto.SHA256, hashed[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	headers.Set("Authorization", fmt.Sprintf(`Signature version="1",headers="date (request-target) host",keyId="%s%s",algorithm="rsa-sha256",signature="%s"`,
+		securityTokenKeyIdPrefix, token, base64.StdEncoding.EncodeToString(signature)))
+	return headers
+}
+
+func TestAuthenticateInstancePrincipalLocally(t *testing.T) {
+	ca := newTestCA(t)
+	sessionKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	requestTarget := "get /v1/auth/oci/login/devrole"
+
+	token := ca.securityToken(t, sessionKey, nil)
+	headers := signedInstanceHeaders(t, token, sessionKey, requestTarget)
+
+	principal, ok, err := authenticateInstancePrincipalLocally(headers, ca.bundle(), testTokenSigner, []string{testHost}, time.Now())
+	if err != nil || !ok {
+		t.Fatalf("local verification failed: ok:%v err:%v", ok, err)
+	}
+	if *principal.SubjectId != testInstanceId || *principal.TenantId != testTenancyId {
+		t.Fatalf("unexpected principal: %s", principal)
+	}
+	claims := FromClaims(principal.Claims)
+	if claims.GetString(ClaimCompartment) != testCompartmentId || claims.GetString(ClaimPrincipalType) != PrincipalTypeInstance {
+		t.Fatalf("unexpected claims: %#v", claims)
+	}
+	if claims.GetString(claimJWK) != "" {
+		t.Fatalf("session key should not be part of the claims")
+	}
+
+	// A request target that differs from the signed one must be rejected
+	tampered := headers.Clone()
+	tampered[HdrRequestTarget] = []string{"get /v1/auth/oci/login/opsrole"}
+	if _, _, err := authenticateInstancePrincipalLocally(tampered, ca.bundle(), testTokenSigner, []string{testHost}, time.Now()); err == nil {
+		t.Fatal("expected tampered request to fail verification")
+	}
+
+	// A signature that does not cover the request target and host must be rejected
+	dateOnly := headers.Clone()
+	dateOnly.Set("Authorization", strings.Replace(headers.Get("Authorization"), `headers="date (request-target) host"`, `headers="date"`, 1))
+	if _, _, err := authenticateInstancePrincipalLocally(dateOnly, ca.bundle(), testTokenSigner, []string{testHost}, time.Now()); err == nil || !strings.Contains(err.Error(), "not signed") {
+		t.Fatalf("expected request that only signs the date to fail verification: %v", err)
+	}
+
+	// A request signed for another host must be rejected
+	if _, _, err := authenticateInstancePrincipalLocally(headers, ca.bundle(), testTokenSigner, []string{"vault.example.com"}, time.Now()); err == nil || !strings.Contains(err.Error(), "signed for host") {
+		t.Fatalf("expected request signed for another host to fail verification: %v", err)
+	}
+
+	// A token issued by an untrusted CA must be rejected
+	if _, _, err := authenticateInstancePrincipalLocally(headers, newTestCA(t).bundle(), testTokenSigner, []string{testHost}, time.Now()); err == nil {
+		t.Fatal("expected untrusted CA to fail verification")
+	}
+
+	// An expired token must be rejected
+	expired := ca.securityToken(t, sessionKey, map[string]interface{}{claimExpiry: time.Now().Add(-time.Minute).Unix()})
+	if _, _, err := authenticateInstancePrincipalLocally(signedInstanceHeaders(t, expired, sessionKey, requestTarget), ca.bundle(), testTokenSigner, []string{testHost}, time.Now()); err == nil {
+		t.Fatal("expected expired token to fail verification")
+	}
+
+	// A stale request must be rejected
+	if _, _, err := authenticateInstancePrincipalLocally(headers, ca.bundle(), testTokenSigner, []string{testHost}, time.Now().Add(time.Hour)); err == nil {
+		t.Fatal("expected stale request to fail verification")
+	}
+
+	// An instance certificate chains up to the trusted CA, but must not be able to sign tokens with foreign claims
+	instanceCert, instanceKey := ca.issueCertificate(t, testInstanceId)
+	forged := signSecurityToken(t, instanceCert, instanceKey, sessionKey, map[string]interface{}{
+		ClaimTenant:      "ocid1.tenancy.oc1..othertenancy",
+		ClaimCompartment: "ocid1.compartment.oc1..othercompartment",
+	})
+	if _, _, err := authenticateInstancePrincipalLocally(signedInstanceHeaders(t, forged, sessionKey, requestTarget), ca.bundle(), testTokenSigner, []string{testHost}, time.Now()); err == nil {
+		t.Fatal("expected token signed by an instance certificate to fail verification")
+	}
+
+	// A certificate issued with the name of the token signer by another CA is not trusted
+	otherCA := newTestCA(t)
+	if _, _, err := authenticateInstancePrincipalLocally(signedInstanceHeaders(t, otherCA.securityToken(t, sessionKey, nil), sessionKey, requestTarget), ca.bundle(), testTokenSigner, []string{testHost}, time.Now()); err == nil {
+		t.Fatal("expected token signed by another CA to fail verification")
+	}
+
+	// Without a certificate chain, the CA key itself must not be able to sign tokens
+	caSigned := signSecurityToken(t, nil, ca.key, sessionKey, nil)
+	if _, _, err := authenticateInstancePrincipalLocally(signedInstanceHeaders(t, caSigned, sessionKey, requestTarget), ca.bundle(), testTokenSigner, []string{testHost}, time.Now()); err == nil {
+		t.Fatal("expected token signed by the CA to fail verification")
+	}
+
+	// A token signer pinned to another name is not trusted
+	if _, _, err := authenticateInstancePrincipalLocally(headers, ca.bundle(), "Other Token Signer", []string{testHost}, time.Now()); err == nil {
+		t.Fatal("expected token signed by another signer to fail verification")
+	}
+
+	// Requests that are not signed by an instance principal are left to Identity
+	userToken := ca.securityToken(t, sessionKey, map[string]interface{}{ClaimPrincipalType: PrincipalTypeUser})
+	if _, ok, err := authenticateInstancePrincipalLocally(signedInstanceHeaders(t, userToken, sessionKey, requestTarget), ca.bundle(), testTokenSigner, []string{testHost}, time.Now()); ok || err != nil {
+		t.Fatalf("expected user token to be left to Identity: ok:%v err:%v", ok, err)
+	}
+	apiKeyHeaders := headers.Clone()
+	apiKeyHeaders.Set("Authorization", `Signature version="1",headers="date (request-target) host",keyId="tenancy/user/fingerprint",algorithm="rsa-sha256",signature="c2lnbmF0dXJl"`)
+	if _, ok, err := authenticateInstancePrincipalLocally(apiKeyHeaders, ca.bundle(), testTokenSigner, []string{testHost}, time.Now()); ok || err != nil {
+		t.Fatalf("expected api key request to be left to Identity: ok:%v err:%v", ok, err)
+	}
+}
+
+// newTestLocalLoginBackend returns a backend that verifies instance principals locally, with a role bound to
+// each of the given compartments, and a function that logs in the test instance to a role
+func newTestLocalLoginBackend(t *testing.T, roles map[string]string) (*backend, *logical.BackendConfig, func(roleName string) *logical.Response) {
+	t.Helper()
+
+	config := logical.TestBackendConfig()
+	config.StorageView = &logical.InmemStorage{}
+
+	b, err := Backend()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Setup(context.Background(), config); err != nil {
+		t.Fatal(err)
+	}
+
+	ca := newTestCA(t)
+	resp, err := b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.CreateOperation,
+		Path:      "config",
+		Storage:   config.StorageView,
+		Data: map[string]interface{}{
+			HomeTenancyIdConfigName:                testTenancyId,
+			InstancePrincipalCABundleConfigName:    ca.bundle(),
+			InstancePrincipalTokenSignerConfigName: testTokenSigner,
+			InstancePrincipalHostsConfigName:       testHost,
+		},
+	})
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("Config creation failed. resp:%#v\n err:%v", resp, err)
+	}
+
+	for roleName, compartmentId := range roles {
+		roleData := map[string]interface{}{
+			"bound_compartment_ids": compartmentId,
+			"token_policies":        "policy1",
+			"force":                 compartmentId == "",
+		}
+		if err := createRole(roleData, roleName, b, config); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	sessionKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	token := ca.securityToken(t, sessionKey, nil)
+
+	login := func(roleName string) *logical.Response {
+		headers := signedInstanceHeaders(t, token, sessionKey, "get "+PathVersionBase+fmt.Sprintf(PathBaseFormat, "oci", roleName))
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{
+			ID:        "requestid",
+			Operation: logical.UpdateOperation,
+			Path:      "login/" + roleName,
+			Storage:   config.StorageView,
+			Data: map[string]interface{}{
+				"request_headers": map[string][]string(headers),
+			},
+		})
+		if err != nil {
+			t.Fatalf("login failed: %v", err)
+		}
+		return resp
+	}
+
+	return b, config, login
+}
+
+func TestBackend_LocalInstancePrincipalLogin(t *testing.T) {
+	b, config, login := newTestLocalLoginBackend(t, map[string]string{
+		"boundrole":   testCompartmentId,
+		"otherrole":   "ocid1.compartment.oc1..othercompartment",
+		"unboundrole": "",
+	})
+
+	resp := login("boundrole")
+	if resp == nil || resp.IsError() || resp.Auth == nil {
+		t.Fatalf("expected login to succeed: %#v", resp)
+	}
+	if !strings.Contains(strings.Join(resp.Auth.Policies, ","), "policy1") {
+		t.Fatalf("unexpected policies: %#v", resp.Auth.Policies)
+	}
+
+	if statusCode, code := loginErrorCode(t, login("otherrole")); statusCode != http.StatusForbidden || code != LoginErrorBindingFailed {
+		t.Fatalf("expected login outside the bound compartment to fail: %d %s", statusCode, code)
+	}
+
+	if statusCode, code := loginErrorCode(t, login("unboundrole")); statusCode != http.StatusForbidden || code != LoginErrorNotInGroup {
+		t.Fatalf("expected login to a role that can never match to fail: %d %s", statusCode, code)
+	}
+
+	// The CA bundle can not be used without pinning the token signer
+	resp, err := b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "config",
+		Storage:   config.StorageView,
+		Data:      map[string]interface{}{InstancePrincipalTokenSignerConfigName: ""},
+	})
+	if err != nil || resp == nil || !resp.IsError() {
+		t.Fatalf("expected the config without a token signer to be rejected: %#v %v", resp, err)
+	}
+
+	// Nor without the hosts that the requests must be signed for
+	resp, err = b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "config",
+		Storage:   config.StorageView,
+		Data:      map[string]interface{}{InstancePrincipalHostsConfigName: ""},
+	})
+	if err != nil || resp == nil || !resp.IsError() {
+		t.Fatalf("expected the config without hosts to be rejected: %#v %v", resp, err)
+	}
+}
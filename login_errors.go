@@ -0,0 +1,144 @@
+// Copyright © 2019, Oracle and/or its affiliates.
+package ociauth
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	log "github.com/hashicorp/go-hclog"
+	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/oracle/oci-go-sdk/v65/common"
+)
+
+// These constants are the stable codes of the reasons a login fails
+const (
+	LoginErrorBadHeaders           = "bad_headers"
+	LoginErrorInvalidRole          = "invalid_role"
+	LoginErrorSignatureRejected    = "signature_rejected"
+	LoginErrorUnsupportedPrincipal = "unsupported_principal"
+	LoginErrorTenancyMismatch      = "tenancy_mismatch"
+	LoginErrorNotInGroup           = "not_in_group"
+	LoginErrorBindingFailed        = "binding_failed"
+	LoginErrorDenied               = "denied"
+	LoginErrorThrottled            = "throttled"
+	LoginErrorLockedOut            = "locked_out"
+	LoginErrorIdentityUnavailable  = "identity_unavailable"
+	LoginErrorNotConfigured        = "not_configured"
+	LoginErrorInternal             = "internal_error"
+)
+
+// internalLoginErrorMessage is the message returned for internal errors instead of their detail
+const internalLoginErrorMessage = "Internal error, see the server log for the request ID"
+
+// loginErrorStatusCodes maps the login error codes to HTTP status codes
+var loginErrorStatusCodes = map[string]int{
+	LoginErrorBadHeaders:           http.StatusBadRequest,
+	LoginErrorInvalidRole:          http.StatusBadRequest,
+	LoginErrorSignatureRejected:    http.StatusUnauthorized,
+	LoginErrorUnsupportedPrincipal: http.StatusForbidden,
+	LoginErrorTenancyMismatch:      http.StatusForbidden,
+	LoginErrorNotInGroup:           http.StatusForbidden,
+	LoginErrorBindingFailed:        http.StatusForbidden,
+	LoginErrorDenied:               http.StatusForbidden,
+	LoginErrorThrottled:            http.StatusTooManyRequests,
+	LoginErrorLockedOut:            http.StatusForbidden,
+	LoginErrorIdentityUnavailable:  http.StatusServiceUnavailable,
+	LoginErrorNotConfigured:        http.StatusInternalServerError,
+	LoginErrorInternal:             http.StatusInternalServerError,
+}
+
+// LoginError is the reason a login failed. Code is stable and meant for clients to act on,
+// while Message is meant for humans and may change.
+type LoginError struct {
+	Code       string
+	StatusCode int
+	Message    string
+
+	// RetryAfter is how long to wait before retrying a throttled or locked out login, if known
+	RetryAfter time.Duration
+
+	err error
+}
+
+func (e *LoginError) Error() string {
+	return e.Message
+}
+
+func (e *LoginError) Unwrap() error {
+	return e.err
+}
+
+// newLoginError returns a LoginError with the code and the message of err
+func newLoginError(code string, err error) *LoginError {
+	statusCode, ok := loginErrorStatusCodes[code]
+	if !ok {
+		code, statusCode = LoginErrorInternal, http.StatusInternalServerError
+	}
+	return &LoginError{
+		Code:       code,
+		StatusCode: statusCode,
+		Message:    err.Error(),
+		err:        err,
+	}
+}
+
+// asLoginError returns the LoginError of err. Errors that were not classified are internal errors.
+func asLoginError(err error) *LoginError {
+	var loginErr *LoginError
+	if errors.As(err, &loginErr) {
+		return loginErr
+	}
+	return newLoginError(LoginErrorInternal, err)
+}
+
+// identityError classifies an error returned by OCI Identity. Throttling and server errors are
+// reported as such, other errors returned by Identity get the given code.
+func identityError(err error, code string) *LoginError {
+	serviceErr, ok := common.IsServiceError(err)
+	switch {
+	case !ok:
+		// The request did not get a response from Identity
+		return newLoginError(LoginErrorIdentityUnavailable, err)
+	case serviceErr.GetHTTPStatusCode() == http.StatusTooManyRequests:
+		return newLoginError(LoginErrorThrottled, err)
+	case serviceErr.GetHTTPStatusCode() >= http.StatusInternalServerError:
+		return newLoginError(LoginErrorIdentityUnavailable, err)
+	default:
+		return newLoginError(code, err)
+	}
+}
+
+// loginErrorResponse returns the response to a failed login, with the status code of the failure. The error response
+// is returned as by any other Vault endpoint, with the error code and the time to wait added to its data.
+func loginErrorResponse(req *logical.Request, logger log.Logger, err error) (*logical.Response, error) {
+	loginErr := asLoginError(err)
+	logger.Trace(req.ID, ": Failed with error:", loginErr, "code", loginErr.Code)
+
+	// Internal errors may come from storage or from the backend itself, so their detail is only logged with the
+	// request ID, which the body returns to find it
+	message := loginErr.Message
+	if loginErr.Code == LoginErrorInternal {
+		logger.Error("Login failed with an internal error", "id", req.ID, "err", loginErr.err)
+		message = internalLoginErrorMessage
+	}
+
+	errorResp := logical.ErrorResponse(message)
+	errorResp.Data["error_code"] = loginErr.Code
+	var headers map[string][]string
+	if loginErr.RetryAfter > 0 {
+		retryAfter := int64(loginErr.RetryAfter.Seconds())
+		errorResp.Data["retry_after"] = retryAfter
+		headers = map[string][]string{
+			"Retry-After": {strconv.FormatInt(retryAfter, 10)},
+		}
+	}
+
+	resp, respErr := logical.RespondWithStatusCode(errorResp, req, loginErr.StatusCode)
+	if respErr != nil {
+		return nil, respErr
+	}
+	resp.Headers = headers
+	return resp, nil
+}
@@ -0,0 +1,156 @@
+// Copyright © 2019, Oracle and/or its affiliates.
+package ociauth
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/hashicorp/vault/api"
+	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/oracle/oci-go-sdk/v65/common"
+	"github.com/oracle/oci-go-sdk/v65/identity"
+)
+
+// loginErrorCode returns the status code and the error code of a failed login response
+func loginErrorCode(t *testing.T, resp *logical.Response) (int, string) {
+	t.Helper()
+
+	if resp == nil || resp.Data == nil {
+		t.Fatalf("expected a login error response: %#v", resp)
+	}
+	statusCode, _ := resp.Data[logical.HTTPStatusCode].(int)
+	body, _ := resp.Data[logical.HTTPRawBody].(string)
+
+	var errorBody struct {
+		RequestID string `json:"request_id"`
+		Data      struct {
+			Error     string `json:"error"`
+			ErrorCode string `json:"error_code"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal([]byte(body), &errorBody); err != nil || errorBody.Data.Error == "" {
+		t.Fatalf("unexpected login error body: %q err:%v", body, err)
+	}
+	return statusCode, errorBody.Data.ErrorCode
+}
+
+func TestLoginErrorResponse(t *testing.T) {
+	req := &logical.Request{ID: "requestid"}
+
+	testCases := []struct {
+		name       string
+		err        error
+		code       string
+		statusCode int
+	}{
+		{"bad headers", newLoginError(LoginErrorBadHeaders, fmt.Errorf("bad")), LoginErrorBadHeaders, http.StatusBadRequest},
+		{"signature", newLoginError(LoginErrorSignatureRejected, fmt.Errorf("bad")), LoginErrorSignatureRejected, http.StatusUnauthorized},
+		{"tenancy", newLoginError(LoginErrorTenancyMismatch, fmt.Errorf("bad")), LoginErrorTenancyMismatch, http.StatusForbidden},
+		{"wrapped", fmt.Errorf("wrapped: %w", newLoginError(LoginErrorNotInGroup, fmt.Errorf("bad"))), LoginErrorNotInGroup, http.StatusForbidden},
+		{"unclassified", fmt.Errorf("storage failed"), LoginErrorInternal, http.StatusInternalServerError},
+		{"unknown code", newLoginError("unknown", fmt.Errorf("bad")), LoginErrorInternal, http.StatusInternalServerError},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			resp, err := loginErrorResponse(req, hclog.NewNullLogger(), tc.err)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if resp.IsError() {
+				t.Fatal("expected a raw response")
+			}
+			statusCode, code := loginErrorCode(t, resp)
+			if statusCode != tc.statusCode || code != tc.code {
+				t.Fatalf("expected %d %s, got %d %s", tc.statusCode, tc.code, statusCode, code)
+			}
+
+			// The detail of internal errors is not returned
+			body := resp.Data[logical.HTTPRawBody].(string)
+			if code == LoginErrorInternal && (strings.Contains(body, "storage failed") || !strings.Contains(body, internalLoginErrorMessage)) {
+				t.Fatalf("expected the internal error to be hidden: %s", body)
+			}
+		})
+	}
+}
+
+func TestIdentityError(t *testing.T) {
+	// A local server returns the given status code to the Identity client
+	newServiceError := func(statusCode int) error {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(statusCode)
+			io.WriteString(w, `{"code":"Error","message":"failed"}`)
+		}))
+		defer server.Close()
+
+		client := newTestIdentityClient(t, server.URL)
+		noRetry := common.NoRetryPolicy()
+		_, err := client.GetGroup(t.Context(), identity.GetGroupRequest{
+			GroupId:         common.String("ocid1.group.oc1..test"),
+			RequestMetadata: common.RequestMetadata{RetryPolicy: &noRetry},
+		})
+		if _, ok := common.IsServiceError(err); !ok {
+			t.Fatalf("expected a service error, got %v", err)
+		}
+		return err
+	}
+
+	testCases := []struct {
+		name string
+		err  error
+		code string
+	}{
+		{"not a service error", errors.New("connection refused"), LoginErrorIdentityUnavailable},
+		{"throttled", newServiceError(http.StatusTooManyRequests), LoginErrorThrottled},
+		{"server error", newServiceError(http.StatusBadGateway), LoginErrorIdentityUnavailable},
+		{"rejected", newServiceError(http.StatusUnauthorized), LoginErrorSignatureRejected},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if loginErr := identityError(tc.err, LoginErrorSignatureRejected); loginErr.Code != tc.code {
+				t.Fatalf("expected %s, got %s", tc.code, loginErr.Code)
+			}
+		})
+	}
+}
+
+func TestCLIHandler_LoginError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusForbidden)
+		io.WriteString(w, `{"request_id":"requestid","data":{"error":"Invalid Tenancy","error_code":"tenancy_mismatch"}}`)
+	}))
+	defer server.Close()
+
+	client, err := api.NewClient(&api.Config{Address: server.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resp, err := client.Logical().WriteRaw("auth/oci/login/devrole", []byte("{}"))
+	if resp != nil {
+		defer resp.Body.Close()
+	}
+	err = loginErrorFromResponse(resp, err)
+
+	var loginErr *LoginError
+	if !errors.As(err, &loginErr) {
+		t.Fatalf("expected a LoginError, got %v", err)
+	}
+	if loginErr.Code != LoginErrorTenancyMismatch || loginErr.StatusCode != http.StatusForbidden || loginErr.Message != "Invalid Tenancy" {
+		t.Fatalf("unexpected login error: %#v", loginErr)
+	}
+	var responseErr *api.ResponseError
+	if !errors.As(err, &responseErr) || responseErr.StatusCode != http.StatusForbidden {
+		t.Fatalf("expected the response error to be wrapped: %v", err)
+	}
+}
@@ -0,0 +1,266 @@
+// Copyright © 2019, Oracle and/or its affiliates.
+package ociauth
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	lru "github.com/hashicorp/golang-lru"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+// These constants are the kinds of lockouts, used in the keys of the lockout entries and in the metrics
+const (
+	lockoutKindKey     = "key"
+	lockoutKindSubject = "subject"
+)
+
+// These constants store the defaults of the lockout policy
+const (
+	defaultLockoutDuration     = 15 * time.Minute
+	defaultLockoutCounterReset = 15 * time.Minute
+
+	// lockoutCacheSize is the number of keyIds and subjects whose failed logins are tracked
+	lockoutCacheSize = 10000
+)
+
+// lockoutFailureCodes are the login errors that count as failed logins of the keyId and of the subject: the failures to
+// authenticate the request and to validate the authenticated principal. Errors that are not caused by the principal,
+// such as Identity being unavailable or the login being throttled, are not counted.
+var lockoutFailureCodes = map[string]bool{
+	LoginErrorSignatureRejected:    true,
+	LoginErrorUnsupportedPrincipal: true,
+	LoginErrorTenancyMismatch:      true,
+}
+
+// lockoutSubjectFailureCodes are the login errors that only count as failed logins of the subject: the principal did
+// authenticate, so its keyId is not locked out, but it keeps trying roles that it is not allowed to take.
+var lockoutSubjectFailureCodes = map[string]bool{
+	LoginErrorBindingFailed: true,
+	LoginErrorNotInGroup:    true,
+	LoginErrorDenied:        true,
+}
+
+// lockoutPolicy locks out a keyId or a subject after threshold failed logins, for the duration.
+// The count of failed logins is reset after counterReset without a failed login.
+type lockoutPolicy struct {
+	threshold    int
+	duration     time.Duration
+	counterReset time.Duration
+}
+
+func (policy lockoutPolicy) enabled() bool {
+	return policy.threshold > 0
+}
+
+// lockoutEntry is the count of the failed logins of a keyId or a subject
+type lockoutEntry struct {
+	failures    int
+	lastFailure time.Time
+	lockedUntil time.Time
+}
+
+// loginLockouts is a bounded LRU cache of the failed logins of keyIds and subjects
+type loginLockouts struct {
+	lock sync.Mutex
+	lru  *lru.Cache
+}
+
+func newLoginLockouts(size int) *loginLockouts {
+	cache, _ := lru.New(size)
+	return &loginLockouts{
+		lru: cache,
+	}
+}
+
+// lockedUntil returns the end of the lockout of the key, if it is locked out
+func (l *loginLockouts) lockedUntil(key string, now time.Time) (time.Time, bool) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	value, ok := l.lru.Get(key)
+	if !ok {
+		return time.Time{}, false
+	}
+	entry := value.(*lockoutEntry)
+	return entry.lockedUntil, now.Before(entry.lockedUntil)
+}
+
+// recordFailure counts a failed login of the key. It returns true if the key is locked out by this failure.
+func (l *loginLockouts) recordFailure(key string, policy lockoutPolicy, now time.Time) bool {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	var entry *lockoutEntry
+	if value, ok := l.lru.Get(key); ok {
+		entry = value.(*lockoutEntry)
+	} else {
+		entry = &lockoutEntry{}
+		l.lru.Add(key, entry)
+	}
+
+	if now.Before(entry.lockedUntil) {
+		return false
+	}
+	if now.Sub(entry.lastFailure) > policy.counterReset || !entry.lockedUntil.IsZero() {
+		// The counter starts again after a quiet period, or after a lockout has ended
+		entry.failures = 0
+		entry.lockedUntil = time.Time{}
+	}
+	entry.failures++
+	entry.lastFailure = now
+
+	if entry.failures < policy.threshold {
+		return false
+	}
+	entry.lockedUntil = now.Add(policy.duration)
+	return true
+}
+
+// status returns the number of failed logins of the key that are counted, and the end of its lockout if it is locked out
+func (l *loginLockouts) status(key string, policy lockoutPolicy, now time.Time) (int, time.Time) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	value, ok := l.lru.Peek(key)
+	if !ok {
+		return 0, time.Time{}
+	}
+	entry := value.(*lockoutEntry)
+	if now.Before(entry.lockedUntil) {
+		return entry.failures, entry.lockedUntil
+	}
+	if !entry.lockedUntil.IsZero() || now.Sub(entry.lastFailure) > policy.counterReset {
+		return 0, time.Time{}
+	}
+	return entry.failures, time.Time{}
+}
+
+// reset forgets the failed logins of the key, after a successful login or an unlock
+func (l *loginLockouts) reset(key string) bool {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	return l.lru.Remove(key)
+}
+
+// lockoutKeyIdKey returns the lockout key of a keyId. The keyIds of instance principals carry their security
+// token, so they are hashed rather than kept in memory as is.
+func lockoutKeyIdKey(keyId string) string {
+	hash := sha256.Sum256([]byte(keyId))
+	return lockoutKindKey + "/" + hex.EncodeToString(hash[:])
+}
+
+// lockoutSubjectKey returns the lockout key of a subject
+func lockoutSubjectKey(subjectId string) string {
+	return lockoutKindSubject + "/" + subjectId
+}
+
+// principalSubjectId returns the subject of the principal, or an empty string if it has none
+func principalSubjectId(principal *Principal) string {
+	if principal == nil || principal.SubjectId == nil {
+		return ""
+	}
+	return *principal.SubjectId
+}
+
+// loggedKeyId returns the keyId as it can be logged: the keyId of an API key, or the hash of a security token
+func loggedKeyId(keyId string) string {
+	if strings.HasPrefix(keyId, securityTokenKeyIdPrefix) {
+		return lockoutKeyIdKey(keyId)
+	}
+	return keyId
+}
+
+// loginKeyId returns the keyId of the signature of the login request, or an empty string if it has none
+func loginKeyId(requestHeaders http.Header) string {
+	params, err := parseSignatureParams(requestHeaders)
+	if err != nil {
+		return ""
+	}
+	return params["keyId"]
+}
+
+// checkLockout returns a locked_out LoginError if the keyId or the subject is locked out
+func (b *backend) checkLockout(configEntry *OCIConfigEntry, kind, id string) error {
+	if id == "" || !configEntry.lockoutPolicy().enabled() {
+		return nil
+	}
+
+	key := lockoutSubjectKey(id)
+	if kind == lockoutKindKey {
+		key = lockoutKeyIdKey(id)
+	}
+
+	now := time.Now()
+	lockedUntil, locked := b.loginLockouts.lockedUntil(key, now)
+	if !locked {
+		return nil
+	}
+
+	loginErr := newLoginError(LoginErrorLockedOut, fmt.Errorf("Too many failed logins, locked out until %s", lockedUntil.UTC().Format(time.RFC3339)))
+	loginErr.RetryAfter = lockedUntil.Sub(now).Truncate(time.Second) + time.Second
+	return loginErr
+}
+
+// recordLoginOutcome counts a failed login against its keyId and its subject, locking them out once the threshold of
+// the config is reached. A successful login resets the count of both. The subject is only known once the request is
+// authenticated, so the failures of a keyId whose signature is rejected never lock out the subject that it names.
+// The failures of a principal that is not allowed to take the role are only counted against its subject.
+func (b *backend) recordLoginOutcome(ctx context.Context, req *logical.Request, attempt *loginAttempt, loginErr error) {
+	subjectId := principalSubjectId(attempt.principal)
+
+	if loginErr == nil {
+		if attempt.keyId != "" {
+			b.loginLockouts.reset(lockoutKeyIdKey(attempt.keyId))
+		}
+		if subjectId != "" {
+			b.loginLockouts.reset(lockoutSubjectKey(subjectId))
+		}
+		return
+	}
+
+	code := asLoginError(loginErr).Code
+	keyFailure := lockoutFailureCodes[code]
+	if !keyFailure && !lockoutSubjectFailureCodes[code] {
+		return
+	}
+	configEntry, err := b.getOCIConfig(ctx, req.Storage)
+	if err != nil {
+		return
+	}
+	policy := configEntry.lockoutPolicy()
+	if !policy.enabled() {
+		return
+	}
+
+	now := time.Now()
+	if keyFailure && attempt.keyId != "" && b.loginLockouts.recordFailure(lockoutKeyIdKey(attempt.keyId), policy, now) {
+		emitLockoutMetrics(lockoutKindKey)
+		b.Logger().Warn("Locked out a keyId after too many failed logins", "id", req.ID, "key_id", loggedKeyId(attempt.keyId), "until", now.Add(policy.duration))
+	}
+	if subjectId != "" && b.loginLockouts.recordFailure(lockoutSubjectKey(subjectId), policy, now) {
+		emitLockoutMetrics(lockoutKindSubject)
+		b.Logger().Warn("Locked out a principal after too many failed logins", "id", req.ID, "subject", subjectId, "until", now.Add(policy.duration))
+		b.recordPrincipalLockout(ctx, req, subjectId, now)
+	}
+}
+
+// recordPrincipalLockout counts the lockout in the ledger entry of the subject. As for logins, the ledger is best effort.
+func (b *backend) recordPrincipalLockout(ctx context.Context, req *logical.Request, subjectId string, now time.Time) {
+	if !b.ledgerWritable() {
+		return
+	}
+	err := b.updatePrincipal(ctx, req.Storage, subjectId, func(entry *principalEntry) {
+		entry.LockoutCount++
+		entry.LastLockout = now.UTC()
+	})
+	if err != nil {
+		b.Logger().Warn("Unable to record the lockout in the ledger", "id", req.ID, "subject", subjectId, "err", err)
+	}
+}
@@ -0,0 +1,190 @@
+// Copyright © 2019, Oracle and/or its affiliates.
+package ociauth
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/oracle/oci-go-sdk/v65/common"
+)
+
+func TestLoginLockouts(t *testing.T) {
+	lockouts := newLoginLockouts(10)
+	policy := lockoutPolicy{threshold: 3, duration: time.Hour, counterReset: time.Minute}
+	now := time.Now()
+
+	// The count is reset after counterReset without a failure
+	lockouts.recordFailure("key", policy, now)
+	lockouts.recordFailure("key", policy, now)
+	if failures, _ := lockouts.status("key", policy, now.Add(2*time.Minute)); failures != 0 {
+		t.Fatalf("expected the count to be reset: %d", failures)
+	}
+	now = now.Add(2 * time.Minute)
+
+	for i := 0; i < 2; i++ {
+		if lockouts.recordFailure("key", policy, now) {
+			t.Fatalf("unexpected lockout after %d failures", i+1)
+		}
+	}
+	if !lockouts.recordFailure("key", policy, now) {
+		t.Fatal("expected a lockout at the threshold")
+	}
+	if failures, lockedUntil := lockouts.status("key", policy, now); failures != 3 || !lockedUntil.Equal(now.Add(time.Hour)) {
+		t.Fatalf("unexpected status: %d %s", failures, lockedUntil)
+	}
+	if _, locked := lockouts.lockedUntil("key", now.Add(time.Minute)); !locked {
+		t.Fatal("expected the key to be locked out")
+	}
+	if _, locked := lockouts.lockedUntil("other", now); locked {
+		t.Fatal("expected another key not to be locked out")
+	}
+
+	// The count starts again once the lockout has ended
+	later := now.Add(time.Hour)
+	if _, locked := lockouts.lockedUntil("key", later); locked {
+		t.Fatal("expected the lockout to end")
+	}
+	if lockouts.recordFailure("key", policy, later) {
+		t.Fatal("unexpected lockout after the lockout ended")
+	}
+	if failures, _ := lockouts.status("key", policy, later); failures != 1 {
+		t.Fatalf("unexpected count: %d", failures)
+	}
+
+	if !lockouts.reset("key") || lockouts.reset("key") {
+		t.Fatal("expected the key to be reset once")
+	}
+}
+
+func TestBackend_LoginLockout(t *testing.T) {
+	sink := newTestMetricsSink(t)
+
+	roleGroup := "ocid1.group.oc1..rolegroup"
+	fake := newFakeIdentity(t)
+	b, config := initOfflineTest(t, fake, roleGroup)
+
+	request := func(operation logical.Operation, path string, data map[string]interface{}) *logical.Response {
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{
+			Operation: operation,
+			Path:      path,
+			Storage:   config.StorageView,
+			Data:      data,
+		})
+		if err != nil || (resp != nil && resp.IsError()) {
+			t.Fatalf("%s %s failed. resp:%#v\n err:%v", operation, path, resp, err)
+		}
+		return resp
+	}
+	request(logical.UpdateOperation, "config", map[string]interface{}{
+		LockoutThresholdConfigName: 2,
+		LockoutDurationConfigName:  "1h",
+	})
+
+	headers, keyId := signedAPIKeyHeaders(t, PathVersionBase+fmt.Sprintf(PathBaseFormat, "oci", DevRole))
+	login := func() *logical.Response {
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      "login/" + DevRole,
+			Storage:   config.StorageView,
+			Data: map[string]interface{}{
+				"request_headers": headers,
+			},
+		})
+		if err != nil {
+			t.Fatalf("login failed: %v", err)
+		}
+		return resp
+	}
+	expectLogin := func(expectedStatusCode int, expectedCode string) *logical.Response {
+		t.Helper()
+		resp := login()
+		if statusCode, code := loginErrorCode(t, resp); statusCode != expectedStatusCode || code != expectedCode {
+			t.Fatalf("expected %d %s, got %d %s", expectedStatusCode, expectedCode, statusCode, code)
+		}
+		return resp
+	}
+
+	// Rejected signatures lock out the keyId, and further logins do not call Identity
+	expectLogin(http.StatusUnauthorized, LoginErrorSignatureRejected)
+	expectLogin(http.StatusUnauthorized, LoginErrorSignatureRejected)
+	resp := expectLogin(http.StatusForbidden, LoginErrorLockedOut)
+	if retryAfter := resp.Headers["Retry-After"]; len(retryAfter) != 1 || retryAfter[0] != "3600" {
+		t.Fatalf("unexpected retry after: %#v", resp.Headers)
+	}
+	if requests := fake.requestCount(fakeIdentityAuthenticateClient); requests != 2 {
+		t.Fatalf("unexpected requests: %d", requests)
+	}
+
+	// An unlock resets the count
+	request(logical.UpdateOperation, "principals/unlock", map[string]interface{}{"key_id": keyId})
+	expectLogin(http.StatusUnauthorized, LoginErrorSignatureRejected)
+	request(logical.UpdateOperation, "principals/unlock", map[string]interface{}{"key_id": keyId})
+
+	// The rejected signatures of the keyId did not count against the subject
+	subjectId := "ocid1.user.oc1..testsubject"
+	statusPolicy := lockoutPolicy{threshold: 2, duration: time.Hour, counterReset: time.Hour}
+	if failures, _ := b.loginLockouts.status(lockoutSubjectKey(subjectId), statusPolicy, time.Now()); failures != 0 {
+		t.Fatalf("unexpected failures of the subject: %d", failures)
+	}
+
+	// A principal that is authenticated but not allowed to take the role is locked out, but its keyId is not
+	fake.addPrincipal(keyId, newFakePrincipal(subjectId, PrincipalTypeUser, nil))
+	expectLogin(http.StatusForbidden, LoginErrorNotInGroup)
+	expectLogin(http.StatusForbidden, LoginErrorNotInGroup)
+	expectLogin(http.StatusForbidden, LoginErrorLockedOut)
+	if failures, _ := b.loginLockouts.status(lockoutKeyIdKey(keyId), statusPolicy, time.Now()); failures != 0 {
+		t.Fatalf("unexpected failures of the keyId: %d", failures)
+	}
+	request(logical.UpdateOperation, "principals/unlock", map[string]interface{}{"subject_id": subjectId})
+
+	// A principal of another tenancy is locked out, and so is its keyId
+	otherTenancyPrincipal := newFakePrincipal(subjectId, PrincipalTypeUser, nil)
+	otherTenancyPrincipal.TenantId = common.String("ocid1.tenancy.oc1..othertenancy")
+	fake.addPrincipal(keyId, otherTenancyPrincipal)
+	expectLogin(http.StatusForbidden, LoginErrorTenancyMismatch)
+	expectLogin(http.StatusForbidden, LoginErrorTenancyMismatch)
+	fake.addPrincipal(keyId, newFakePrincipal(subjectId, PrincipalTypeUser, nil))
+	fake.setMembership(subjectId, roleGroup)
+	expectLogin(http.StatusForbidden, LoginErrorLockedOut)
+
+	resp = request(logical.ReadOperation, "principals/"+subjectId, nil)
+	if resp.Data["locked_out"] != true || resp.Data["locked_out_until"] == "" || resp.Data["failed_login_count"] != 2 || resp.Data["lockout_count"] != int64(2) {
+		t.Fatalf("unexpected principal: %#v", resp.Data)
+	}
+
+	// The keyId is still locked out after the principal is unlocked
+	request(logical.UpdateOperation, "principals/unlock", map[string]interface{}{"subject_id": subjectId})
+	expectLogin(http.StatusForbidden, LoginErrorLockedOut)
+	request(logical.UpdateOperation, "principals/unlock", map[string]interface{}{"key_id": keyId})
+	if resp := login(); resp == nil || resp.Auth == nil {
+		t.Fatalf("expected login to succeed: %#v", resp)
+	}
+
+	resp = request(logical.ReadOperation, "principals/"+subjectId, nil)
+	if resp.Data["locked_out"] != false || resp.Data["failed_login_count"] != 0 || resp.Data["login_count"] != int64(1) {
+		t.Fatalf("unexpected principal: %#v", resp.Data)
+	}
+
+	if _, err := b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "principals/unlock",
+		Storage:   config.StorageView,
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	counters := testMetricsCounters(sink)
+	expected := map[string]int{
+		"oci.login.lockout;kind=key":     2,
+		"oci.login.lockout;kind=subject": 2,
+	}
+	for key, count := range expected {
+		if counters[key] != count {
+			t.Fatalf("unexpected count of %s: %d", key, counters[key])
+		}
+	}
+}
@@ -0,0 +1,243 @@
+// Copyright © 2019, Oracle and/or its affiliates.
+package ociauth
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	metrics "github.com/hashicorp/go-metrics/compat"
+	lru "github.com/hashicorp/golang-lru"
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+	"golang.org/x/time/rate"
+)
+
+// These constants are the kinds of login rate limits, used in the keys of the buckets and in the metrics.
+// The keyId and the source address are limited before the principal is authenticated, the subject and the role after.
+const (
+	loginRateLimitKey     = "key"
+	loginRateLimitSource  = "source"
+	loginRateLimitSubject = "subject"
+	loginRateLimitRole    = "role"
+)
+
+// These constants store the defaults of the login rate limits
+const (
+	// loginLimiterCacheSize is the number of buckets kept for each kind of limit. The bucket of a key that was evicted
+	// starts full again.
+	loginLimiterCacheSize = 10000
+
+	// throttleRecordInterval is how often the throttles of a subject are recorded in the login ledger, so that
+	// a principal retrying in a tight loop does not write to the storage on every attempt
+	throttleRecordInterval = time.Minute
+)
+
+// loginRateLimit is a token bucket that holds up to Burst logins and is refilled at Rate logins per second.
+// The limit is disabled if Rate is zero.
+type loginRateLimit struct {
+	Rate  float64 `json:"rate"`
+	Burst int     `json:"burst"`
+}
+
+func (limit loginRateLimit) enabled() bool {
+	return limit.Rate > 0
+}
+
+// valid returns false if the rate or the burst of the limit is negative
+func (limit loginRateLimit) valid() bool {
+	return limit.Rate >= 0 && limit.Burst >= 0
+}
+
+// burst returns the size of the bucket, which holds at least one login
+func (limit loginRateLimit) burst() int {
+	if limit.Burst < 1 {
+		return 1
+	}
+	return limit.Burst
+}
+
+// loginLimiter is the bucket of a subject or a role
+type loginLimiter struct {
+	limiter *rate.Limiter
+
+	// throttled is the number of throttles that were not recorded in the ledger yet
+	throttled int64
+
+	// recordedAt is when the throttles were last recorded in the ledger
+	recordedAt time.Time
+}
+
+// loginLimiters is a bounded LRU cache of the login buckets
+type loginLimiters struct {
+	lock sync.Mutex
+	lru  *lru.Cache
+}
+
+func newLoginLimiters(size int) *loginLimiters {
+	cache, _ := lru.New(size)
+	return &loginLimiters{
+		lru: cache,
+	}
+}
+
+// newLoginLimitersByKind returns a separate cache of buckets for each kind of limit, so that the buckets of the keyIds
+// and of the source addresses, which any caller can create, do not evict those of the subjects and of the roles
+func newLoginLimitersByKind(size int) map[string]*loginLimiters {
+	return map[string]*loginLimiters{
+		loginRateLimitKey:     newLoginLimiters(size),
+		loginRateLimitSource:  newLoginLimiters(size),
+		loginRateLimitSubject: newLoginLimiters(size),
+		loginRateLimitRole:    newLoginLimiters(size),
+	}
+}
+
+// take takes a login from the bucket of the key. If the bucket is empty, it returns how long to wait for the
+// next login, and the number of throttles that are due to be recorded in the ledger.
+func (l *loginLimiters) take(key string, limit loginRateLimit, now time.Time) (time.Duration, int64) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	var entry *loginLimiter
+	if value, ok := l.lru.Get(key); ok {
+		entry = value.(*loginLimiter)
+		// The limit may have been changed since the bucket was created
+		entry.limiter.SetLimitAt(now, rate.Limit(limit.Rate))
+		entry.limiter.SetBurstAt(now, limit.burst())
+	} else {
+		entry = &loginLimiter{
+			limiter: rate.NewLimiter(rate.Limit(limit.Rate), limit.burst()),
+		}
+		l.lru.Add(key, entry)
+	}
+
+	reservation := entry.limiter.ReserveN(now, 1)
+	delay := reservation.DelayFrom(now)
+	if delay == 0 {
+		return 0, 0
+	}
+	// A throttled login does not take a login from the bucket
+	reservation.CancelAt(now)
+
+	entry.throttled++
+	if now.Sub(entry.recordedAt) < throttleRecordInterval {
+		return delay, 0
+	}
+	throttled := entry.throttled
+	entry.throttled = 0
+	entry.recordedAt = now
+	return delay, throttled
+}
+
+// checkLoginRateLimit takes a login from the bucket of the given kind, and returns a throttled LoginError with the time
+// to wait before retrying if the bucket is empty. The id is the keyId, the source address or the subject of the
+// bucket, and is not used by the role limit. The throttles of subjects are recorded in the ledger.
+func (b *backend) checkLoginRateLimit(ctx context.Context, req *logical.Request, kind, roleName, id string, limit loginRateLimit) error {
+	if !limit.enabled() {
+		return nil
+	}
+
+	key := roleName
+	if kind != loginRateLimitRole {
+		if id == "" {
+			return nil
+		}
+		key += "/" + id
+	}
+
+	now := time.Now()
+	delay, throttled := b.loginLimiters[kind].take(key, limit, now)
+	if delay == 0 {
+		return nil
+	}
+
+	metricRole := roleName
+	if metricRole == "" {
+		metricRole = metricUnknown
+	}
+	metrics.IncrCounterWithLabels([]string{metricsPrefix, "login", "throttled"}, 1, []metrics.Label{
+		{Name: metricLabelRole, Value: metricRole},
+		{Name: metricLabelLimit, Value: kind},
+	})
+	b.Logger().Debug("Login throttled", "id", req.ID, "limit", kind, "role", roleName, kind, id, "retry_after", delay)
+
+	if kind == loginRateLimitSubject && throttled > 0 {
+		b.recordPrincipalThrottles(ctx, req, id, throttled, now)
+	}
+
+	loginErr := newLoginError(LoginErrorThrottled, fmt.Errorf("Too many logins by the %s, retry later", kind))
+	// Retry-After is a number of seconds, rounded up so that the retry is not throttled again
+	loginErr.RetryAfter = time.Duration(math.Ceil(delay.Seconds())) * time.Second
+	return loginErr
+}
+
+// checkUnauthenticatedLoginRateLimits limits the logins before OCI Identity is called, while the principal is not
+// authenticated: the logins of the keyId are limited as those of a subject, and the logins from the source address
+// as those to the role, so that a single caller can not take every login of the role. The buckets of subjects are
+// only charged for authenticated principals, so that anonymous callers can not exhaust them.
+func (b *backend) checkUnauthenticatedLoginRateLimits(ctx context.Context, req *logical.Request, roleName, keyId string, subjectLimit, roleLimit loginRateLimit) error {
+	if keyId != "" {
+		if err := b.checkLoginRateLimit(ctx, req, loginRateLimitKey, roleName, loggedKeyId(keyId), subjectLimit); err != nil {
+			return err
+		}
+	}
+	return b.checkLoginRateLimit(ctx, req, loginRateLimitSource, roleName, loginSourceAddress(req), roleLimit)
+}
+
+// loginSourceAddress returns the address that the login request comes from, or an empty string if it is not known
+func loginSourceAddress(req *logical.Request) string {
+	if req.Connection == nil {
+		return ""
+	}
+	return req.Connection.RemoteAddr
+}
+
+// subjectLoginRateLimit returns the limit of the logins of each subject to the role
+func (roleEntry *OCIRoleEntry) subjectLoginRateLimit(configEntry *OCIConfigEntry) loginRateLimit {
+	if roleEntry != nil && roleEntry.SubjectLoginRateLimit != nil {
+		return *roleEntry.SubjectLoginRateLimit
+	}
+	return configEntry.subjectLoginRateLimit()
+}
+
+// roleLoginRateLimit returns the limit of all the logins to the role
+func (roleEntry *OCIRoleEntry) roleLoginRateLimit(configEntry *OCIConfigEntry) loginRateLimit {
+	if roleEntry != nil && roleEntry.RoleLoginRateLimit != nil {
+		return *roleEntry.RoleLoginRateLimit
+	}
+	return configEntry.roleLoginRateLimit()
+}
+
+// parseRoleLoginRateLimit returns the override of a login rate limit set by a role write. A rate of -1 removes
+// the override, so that the limit of the config applies.
+func parseRoleLoginRateLimit(data *framework.FieldData, rateField, burstField string, current *loginRateLimit) (*loginRateLimit, error) {
+	limitRate, rateOk := data.GetOk(rateField)
+	limitBurst, burstOk := data.GetOk(burstField)
+	if !rateOk && !burstOk {
+		return current, nil
+	}
+	if rateOk && limitRate.(float64) == -1 {
+		return nil, nil
+	}
+
+	if current == nil && !rateOk {
+		return nil, fmt.Errorf("%s must be set to override the limit of the config", rateField)
+	}
+
+	limit := loginRateLimit{}
+	if current != nil {
+		limit = *current
+	}
+	if rateOk {
+		limit.Rate = limitRate.(float64)
+	}
+	if burstOk {
+		limit.Burst = limitBurst.(int)
+	}
+	if !limit.valid() {
+		return nil, fmt.Errorf("Invalid %s or %s", rateField, burstField)
+	}
+	return &limit, nil
+}
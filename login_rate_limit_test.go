@@ -0,0 +1,240 @@
+// Copyright © 2019, Oracle and/or its affiliates.
+package ociauth
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+func TestLoginLimiters_Take(t *testing.T) {
+	limiters := newLoginLimiters(10)
+	limit := loginRateLimit{Rate: 1, Burst: 2}
+	now := time.Now()
+
+	for i := 0; i < 2; i++ {
+		if delay, _ := limiters.take("key", limit, now); delay != 0 {
+			t.Fatalf("expected login %d of the burst to be allowed: %s", i, delay)
+		}
+	}
+
+	// The first throttle is recorded, the next ones are recorded after throttleRecordInterval
+	delay, throttled := limiters.take("key", limit, now)
+	if delay <= 0 || delay > time.Second || throttled != 1 {
+		t.Fatalf("unexpected throttle: %s %d", delay, throttled)
+	}
+	if delay, throttled := limiters.take("key", limit, now); delay <= 0 || throttled != 0 {
+		t.Fatalf("unexpected throttle: %s %d", delay, throttled)
+	}
+
+	// Other keys have their own bucket
+	if delay, _ := limiters.take("other", limit, now); delay != 0 {
+		t.Fatalf("expected another key to be allowed: %s", delay)
+	}
+
+	// Throttled logins do not take from the bucket, so it is refilled at the rate
+	if delay, _ := limiters.take("key", limit, now.Add(time.Second)); delay != 0 {
+		t.Fatalf("expected a login after the refill to be allowed: %s", delay)
+	}
+
+	later := now.Add(time.Second + throttleRecordInterval)
+	limiters.take("key", limit, later)
+	limiters.take("key", limit, later)
+	if _, throttled := limiters.take("key", loginRateLimit{Rate: 0.001}, later); throttled != 2 {
+		t.Fatalf("expected the pending throttles to be recorded: %d", throttled)
+	}
+}
+
+func TestBackend_LoginRateLimit(t *testing.T) {
+	sink := newTestMetricsSink(t)
+
+	b, config, login := newTestLocalLoginBackend(t, map[string]string{
+		"boundrole":   testCompartmentId,
+		"limitedrole": testCompartmentId,
+		"freerole":    testCompartmentId,
+	})
+
+	request := func(operation logical.Operation, path string, data map[string]interface{}) *logical.Response {
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{
+			Operation: operation,
+			Path:      path,
+			Storage:   config.StorageView,
+			Data:      data,
+		})
+		if err != nil || (resp != nil && resp.IsError()) {
+			t.Fatalf("%s %s failed. resp:%#v\n err:%v", operation, path, resp, err)
+		}
+		return resp
+	}
+
+	request(logical.UpdateOperation, "config", map[string]interface{}{
+		SubjectLoginRateConfigName:  0.001,
+		SubjectLoginBurstConfigName: 2,
+	})
+	resp := request(logical.ReadOperation, "config", nil)
+	if resp.Data[SubjectLoginRateConfigName] != 0.001 || resp.Data[SubjectLoginBurstConfigName] != 2 || resp.Data[RoleLoginRateConfigName] != float64(0) {
+		t.Fatalf("unexpected config: %#v", resp.Data)
+	}
+
+	request(logical.UpdateOperation, "role/freerole", map[string]interface{}{"subject_login_rate": 0})
+	request(logical.UpdateOperation, "role/limitedrole", map[string]interface{}{"role_login_rate": 0.001})
+	resp = request(logical.ReadOperation, "role/limitedrole", nil)
+	if resp.Data["role_login_rate"] != 0.001 || resp.Data["role_login_burst"] != 1 {
+		t.Fatalf("unexpected role: %#v", resp.Data)
+	}
+	if _, ok := resp.Data["subject_login_rate"]; ok {
+		t.Fatalf("unexpected override: %#v", resp.Data)
+	}
+
+	// The principal can log in twice to the role, then it is throttled before it is authenticated, on its keyId
+	for i := 0; i < 2; i++ {
+		if resp := login("boundrole"); resp == nil || resp.Auth == nil {
+			t.Fatalf("expected login %d to succeed: %#v", i, resp)
+		}
+	}
+	resp = login("boundrole")
+	if statusCode, code := loginErrorCode(t, resp); statusCode != http.StatusTooManyRequests || code != LoginErrorThrottled {
+		t.Fatalf("expected the login to be throttled: %d %s", statusCode, code)
+	}
+	var body struct {
+		Data map[string]interface{} `json:"data"`
+	}
+	if err := json.Unmarshal([]byte(resp.Data[logical.HTTPRawBody].(string)), &body); err != nil {
+		t.Fatal(err)
+	}
+	retryAfter, ok := body.Data["retry_after"].(float64)
+	if !ok || retryAfter < 900 || retryAfter > 1000 || len(resp.Headers["Retry-After"]) != 1 {
+		t.Fatalf("unexpected retry after: %#v %#v", body, resp.Headers)
+	}
+
+	// The role without a principal limit is not throttled
+	for i := 0; i < 3; i++ {
+		if resp := login("freerole"); resp == nil || resp.Auth == nil {
+			t.Fatalf("expected login %d to succeed: %#v", i, resp)
+		}
+	}
+
+	// The role limit applies to every login to the role
+	if resp := login("limitedrole"); resp == nil || resp.Auth == nil {
+		t.Fatalf("expected login to succeed: %#v", resp)
+	}
+	if statusCode, code := loginErrorCode(t, login("limitedrole")); statusCode != http.StatusTooManyRequests || code != LoginErrorThrottled {
+		t.Fatalf("expected the login to be throttled: %d %s", statusCode, code)
+	}
+
+	// The override is removed with -1
+	request(logical.UpdateOperation, "role/limitedrole", map[string]interface{}{"role_login_rate": -1})
+	if resp := request(logical.ReadOperation, "role/limitedrole", nil); resp.Data["role_login_rate"] != nil {
+		t.Fatalf("unexpected role: %#v", resp.Data)
+	}
+	if _, err := b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "role/limitedrole",
+		Storage:   config.StorageView,
+		Data:      map[string]interface{}{"role_login_burst": 5},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if resp := request(logical.ReadOperation, "role/limitedrole", nil); resp.Data["role_login_burst"] != nil {
+		t.Fatalf("expected a burst without a rate to be refused: %#v", resp.Data)
+	}
+
+	// The bucket of the subject was charged by the logins too, and its throttles are recorded in the ledger
+	loginErr := b.checkLoginRateLimit(context.Background(), &logical.Request{Storage: config.StorageView}, loginRateLimitSubject, "boundrole", testInstanceId, loginRateLimit{Rate: 0.001, Burst: 2})
+	if code := asLoginError(loginErr).Code; code != LoginErrorThrottled {
+		t.Fatalf("expected the subject to be throttled: %s", code)
+	}
+
+	counters := testMetricsCounters(sink)
+	expected := map[string]int{
+		"oci.login.throttled;role=boundrole;limit=key":     1,
+		"oci.login.throttled;role=boundrole;limit=subject": 1,
+		"oci.login.throttled;role=limitedrole;limit=role":  1,
+	}
+	for key, count := range expected {
+		if counters[key] != count {
+			t.Fatalf("unexpected count of %s: %d", key, counters[key])
+		}
+	}
+
+	resp = request(logical.ReadOperation, "principals/"+testInstanceId, nil)
+	if resp.Data["throttled_count"] != int64(1) || resp.Data["last_throttled"] == "" {
+		t.Fatalf("unexpected principal: %#v", resp.Data)
+	}
+
+	// Throttled logins are not counted as logins
+	if !reflect.DeepEqual(resp.Data["login_count"], int64(6)) {
+		t.Fatalf("unexpected login count: %#v", resp.Data["login_count"])
+	}
+}
+
+func TestBackend_UnauthenticatedLoginRateLimit(t *testing.T) {
+	roleGroup := "ocid1.group.oc1..rolegroup"
+	fake := newFakeIdentity(t)
+	b, config := initOfflineTest(t, fake, roleGroup)
+	if _, err := b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "config",
+		Storage:   config.StorageView,
+		Data: map[string]interface{}{
+			RoleLoginRateConfigName:  0.001,
+			RoleLoginBurstConfigName: 1,
+		},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	login := func(remoteAddr string, known bool) *logical.Response {
+		headers, keyId := signedAPIKeyHeaders(t, PathVersionBase+fmt.Sprintf(PathBaseFormat, "oci", DevRole))
+		if known {
+			fake.addPrincipal(keyId, newFakePrincipal("ocid1.user.oc1..alice", PrincipalTypeUser, nil), roleGroup)
+		}
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{
+			Operation:  logical.UpdateOperation,
+			Path:       "login/" + DevRole,
+			Storage:    config.StorageView,
+			Connection: &logical.Connection{RemoteAddr: remoteAddr},
+			Data:       map[string]interface{}{"request_headers": headers},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp
+	}
+
+	// Every login takes from the bucket of its source, then from the bucket of the role before OCI Identity is called
+	if resp := login("192.0.2.1", true); resp == nil || resp.Auth == nil {
+		t.Fatalf("expected login to succeed: %#v", resp)
+	}
+	if statusCode, code := loginErrorCode(t, login("192.0.2.2", false)); statusCode != http.StatusTooManyRequests || code != LoginErrorThrottled {
+		t.Fatalf("expected the role to be throttled: %d %s", statusCode, code)
+	}
+	if requests := fake.requestCount(fakeIdentityAuthenticateClient); requests != 1 {
+		t.Fatalf("expected the throttled login not to call Identity: %d", requests)
+	}
+	if statusCode, code := loginErrorCode(t, login("192.0.2.1", true)); statusCode != http.StatusTooManyRequests || code != LoginErrorThrottled {
+		t.Fatalf("expected the source to be throttled: %d %s", statusCode, code)
+	}
+
+	// The buckets of the keyIds and of the source addresses do not evict the bucket of the role
+	b.loginLimiters = newLoginLimitersByKind(1)
+	limit := loginRateLimit{Rate: 0.001, Burst: 1}
+	req := &logical.Request{Storage: config.StorageView}
+	if err := b.checkLoginRateLimit(context.Background(), req, loginRateLimitRole, DevRole, "", limit); err != nil {
+		t.Fatal(err)
+	}
+	for _, source := range []string{"192.0.2.1", "192.0.2.2"} {
+		if err := b.checkLoginRateLimit(context.Background(), req, loginRateLimitSource, DevRole, source, limit); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if loginErr := b.checkLoginRateLimit(context.Background(), req, loginRateLimitRole, DevRole, "", limit); loginErr == nil || asLoginError(loginErr).Code != LoginErrorThrottled {
+		t.Fatalf("expected the role to stay throttled: %v", loginErr)
+	}
+}
@@ -0,0 +1,126 @@
+// Copyright © 2019, Oracle and/or its affiliates.
+package ociauth
+
+import (
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// redactedValue replaces secrets in the decision trace
+const redactedValue = "<redacted>"
+
+// redactedClaims are the claims whose values are not returned in the decision trace
+var redactedClaims = []string{claimJWK}
+
+// loginTrace records the decisions made while verifying a login request.
+// A nil loginTrace records nothing, so logins pass nil and only login verification pays for the trace.
+type loginTrace struct {
+	data      map[string]interface{}
+	bindings  []map[string]interface{}
+	subjectId string
+}
+
+func newLoginTrace() *loginTrace {
+	return &loginTrace{
+		data: make(map[string]interface{}),
+	}
+}
+
+// set records a decision of the login
+func (t *loginTrace) set(key string, value interface{}) {
+	if t == nil {
+		return
+	}
+	t.data[key] = value
+}
+
+// setRequest records the signed headers of the request, without the signature and the security token
+func (t *loginTrace) setRequest(headers http.Header) {
+	if t == nil {
+		return
+	}
+
+	signedHeaders := make([]string, 0, len(headers))
+	for name := range headers {
+		signedHeaders = append(signedHeaders, strings.ToLower(name))
+	}
+	sort.Strings(signedHeaders)
+	t.data["request_headers"] = signedHeaders
+
+	if target := headers[HdrRequestTarget]; len(target) > 0 {
+		t.data["request_target"] = target[0]
+	}
+
+	if params, err := parseSignatureParams(headers); err == nil {
+		keyId := params["keyId"]
+		if _, ok := securityTokenFromKeyId(keyId); ok {
+			keyId = securityTokenKeyIdPrefix + redactedValue
+		}
+		t.data["key_id"] = keyId
+		t.data["signature_algorithm"] = params["algorithm"]
+	}
+}
+
+// setPrincipal records the authenticated principal and its claims
+func (t *loginTrace) setPrincipal(principal *Principal, claims InternalClaims) {
+	if t == nil {
+		return
+	}
+
+	traceClaims := make(map[string]interface{}, len(claims))
+	for key, values := range claims {
+		if len(values) == 0 {
+			continue
+		}
+		if containsAny([]string{key}, redactedClaims) {
+			traceClaims[key] = redactedValue
+			continue
+		}
+		claimValues := make([]string, 0, len(values))
+		for _, value := range values {
+			claimValues = append(claimValues, value.Value)
+		}
+		if len(claimValues) == 1 {
+			traceClaims[key] = claimValues[0]
+		} else {
+			traceClaims[key] = claimValues
+		}
+	}
+
+	tracePrincipal := map[string]interface{}{
+		"claims": traceClaims,
+	}
+	if principal.TenantId != nil {
+		tracePrincipal["tenant_id"] = *principal.TenantId
+	}
+	if principal.SubjectId != nil {
+		t.subjectId = *principal.SubjectId
+		tracePrincipal["subject_id"] = t.subjectId
+	}
+	t.data["principal"] = tracePrincipal
+}
+
+// addBinding records the result of checking a binding of the role
+func (t *loginTrace) addBinding(binding roleBinding, value string, err error) {
+	if t == nil {
+		return
+	}
+
+	traceBinding := map[string]interface{}{
+		"binding": binding.field,
+		"allowed": append([]string{}, binding.values...),
+		"value":   value,
+		"passed":  err == nil,
+	}
+	t.bindings = append(t.bindings, traceBinding)
+	t.data["bindings"] = t.bindings
+}
+
+// deny records the error that denied the login and its code
+func (t *loginTrace) deny(err error) {
+	loginErr := asLoginError(err)
+	t.set("allowed", false)
+	t.set("error", loginErr.Message)
+	t.set("error_code", loginErr.Code)
+}
@@ -0,0 +1,225 @@
+// Copyright © 2019, Oracle and/or its affiliates.
+package ociauth
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// These constants are the comparisons of a matching rule condition
+const (
+	matchingRuleEqual    = "="
+	matchingRuleNotEqual = "!="
+)
+
+// matchingRuleAttributes maps the attributes of matching rules, as written in the dynamic group rules of OCI IAM,
+// to the claims of the principal
+var matchingRuleAttributes = map[string]string{
+	"instance.id":             ClaimInstance,
+	"instance.compartment.id": ClaimCompartment,
+}
+
+// matchingRuleTagAttribute is the attribute of the value of a defined tag, tag.<namespace>.<key>.value, which
+// matches the tags looked up with the instance_lookup of the config
+const matchingRuleTagAttribute = "tag.<namespace>.<key>.value"
+
+// matchingRuleClaim returns the claim of an attribute of matching rules, and false if the attribute is not supported
+func matchingRuleClaim(attribute string) (string, bool) {
+	if claim, ok := matchingRuleAttributes[attribute]; ok {
+		return claim, true
+	}
+	tag := strings.TrimSuffix(strings.TrimPrefix(attribute, definedTagClaimPrefix), ".value")
+	if len(tag) == len(attribute)-len(definedTagClaimPrefix)-len(".value") {
+		if namespace, key, found := strings.Cut(tag, "."); found && namespace != "" && key != "" && !strings.Contains(key, ".") {
+			return definedTagClaim(tag), true
+		}
+	}
+	return "", false
+}
+
+// matchingRule is a parsed matching rule in the syntax of the dynamic group rules of OCI IAM, such as
+// ANY {instance.compartment.id = 'ocid1.compartment...', instance.id = 'ocid1.instance...'}.
+// A rule is either a set of nested rules combined with ANY or ALL, or a condition on an attribute.
+type matchingRule struct {
+	operator string
+	rules    []*matchingRule
+
+	attribute  string
+	comparison string
+	value      string
+}
+
+// parseMatchingRule parses a matching rule, returning an error that gives the position of the problem
+func parseMatchingRule(input string) (*matchingRule, error) {
+	p := &matchingRuleParser{input: input}
+	rule, err := p.parseRule(1)
+	if err != nil {
+		return nil, err
+	}
+	p.skipSpaces()
+	if p.pos < len(p.input) {
+		return nil, p.errorf("unexpected %q after the end of the rule", p.input[p.pos:])
+	}
+	return rule, nil
+}
+
+// matches evaluates the rule against the claims of the principal. A condition on an attribute that the principal
+// does not have, such as instance.id for a user, never matches.
+func (rule *matchingRule) matches(claims InternalClaims) bool {
+	if rule.operator == "" {
+		claim, _ := matchingRuleClaim(rule.attribute)
+		value := claims.GetString(claim)
+		if value == "" {
+			return false
+		}
+		return (value == rule.value) == (rule.comparison == matchingRuleEqual)
+	}
+
+	all := rule.operator == groupMatchAll
+	for _, nested := range rule.rules {
+		if nested.matches(claims) != all {
+			return !all
+		}
+	}
+	return all
+}
+
+// matchingRuleParser is a recursive descent parser of matching rules
+type matchingRuleParser struct {
+	input string
+	pos   int
+}
+
+func (p *matchingRuleParser) errorf(format string, args ...interface{}) error {
+	return fmt.Errorf("invalid matching_rule at position %d: %s", p.pos+1, fmt.Sprintf(format, args...))
+}
+
+func (p *matchingRuleParser) skipSpaces() {
+	for p.pos < len(p.input) && unicode.IsSpace(rune(p.input[p.pos])) {
+		p.pos++
+	}
+}
+
+// next returns the next character without consuming it, or 0 at the end of the input
+func (p *matchingRuleParser) next() byte {
+	p.skipSpaces()
+	if p.pos >= len(p.input) {
+		return 0
+	}
+	return p.input[p.pos]
+}
+
+// word consumes an operator keyword or an attribute
+func (p *matchingRuleParser) word() string {
+	p.skipSpaces()
+	start := p.pos
+	for p.pos < len(p.input) {
+		c := p.input[p.pos]
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '.' || c == '_' || c == '-') {
+			break
+		}
+		p.pos++
+	}
+	return p.input[start:p.pos]
+}
+
+// parseRule parses ANY {<rule>, ...}, ALL {<rule>, ...} or <attribute> <comparison> '<value>'
+func (p *matchingRuleParser) parseRule(depth int) (*matchingRule, error) {
+	if depth > maxGroupRuleDepth {
+		return nil, p.errorf("the rule is nested more than %d levels", maxGroupRuleDepth)
+	}
+
+	start := p.pos
+	word := p.word()
+	if word == "" {
+		if p.next() == 0 {
+			return nil, p.errorf("expected ANY, ALL or a condition, found the end of the rule")
+		}
+		return nil, p.errorf("expected ANY, ALL or a condition, found %q", p.next())
+	}
+
+	if operator := strings.ToLower(word); operator == groupMatchAny || operator == groupMatchAll {
+		return p.parseSet(operator, depth)
+	}
+
+	attribute := strings.ToLower(word)
+	if _, ok := matchingRuleClaim(attribute); !ok {
+		p.pos = start
+		p.skipSpaces()
+		return nil, p.errorf("unsupported attribute %q, expected one of %s", word, strings.Join(matchingRuleAttributeNames(), ", "))
+	}
+	return p.parseCondition(attribute)
+}
+
+// parseSet parses the braces of ANY or ALL and the rules between them
+func (p *matchingRuleParser) parseSet(operator string, depth int) (*matchingRule, error) {
+	if p.next() != '{' {
+		return nil, p.errorf("expected '{' after %s", strings.ToUpper(operator))
+	}
+	p.pos++
+
+	rule := &matchingRule{operator: operator}
+	for {
+		nested, err := p.parseRule(depth + 1)
+		if err != nil {
+			return nil, err
+		}
+		rule.rules = append(rule.rules, nested)
+
+		switch p.next() {
+		case ',':
+			p.pos++
+		case '}':
+			p.pos++
+			return rule, nil
+		case 0:
+			return nil, p.errorf("expected ',' or '}', found the end of the rule")
+		default:
+			return nil, p.errorf("expected ',' or '}', found %q", p.next())
+		}
+	}
+}
+
+// parseCondition parses the comparison and the quoted value of a condition
+func (p *matchingRuleParser) parseCondition(attribute string) (*matchingRule, error) {
+	rule := &matchingRule{attribute: attribute}
+
+	p.skipSpaces()
+	switch {
+	case strings.HasPrefix(p.input[p.pos:], matchingRuleNotEqual):
+		rule.comparison = matchingRuleNotEqual
+	case p.next() == '=':
+		rule.comparison = matchingRuleEqual
+	default:
+		return nil, p.errorf("expected '=' or '!=' after %s", attribute)
+	}
+	p.pos += len(rule.comparison)
+
+	if p.next() != '\'' {
+		return nil, p.errorf("expected a value in single quotes after %s %s", attribute, rule.comparison)
+	}
+	p.pos++
+	end := strings.IndexByte(p.input[p.pos:], '\'')
+	if end < 0 {
+		return nil, p.errorf("the value is not terminated by a single quote")
+	}
+	rule.value = p.input[p.pos : p.pos+end]
+	if rule.value == "" {
+		return nil, p.errorf("the value of %s is empty", attribute)
+	}
+	p.pos += end + 1
+
+	return rule, nil
+}
+
+// matchingRuleAttributeNames returns the sorted names of the supported attributes
+func matchingRuleAttributeNames() []string {
+	names := make([]string, 0, len(matchingRuleAttributes))
+	for name := range matchingRuleAttributes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return append(names, matchingRuleTagAttribute)
+}
@@ -0,0 +1,124 @@
+// Copyright © 2019, Oracle and/or its affiliates.
+package ociauth
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+func TestMatchingRule(t *testing.T) {
+	claims := InternalClaims{
+		ClaimCompartment: {{Key: ClaimCompartment, Value: "ocid1.compartment.oc1..prod"}},
+		ClaimInstance:    {{Key: ClaimInstance, Value: "ocid1.instance.oc1.phx.host1"}},
+		"tag.ops.team":   {{Key: "tag.ops.team", Value: "payments"}},
+	}
+	userClaims := InternalClaims{
+		ClaimPrincipalType: {{Key: ClaimPrincipalType, Value: PrincipalTypeUser}},
+	}
+
+	testCases := []struct {
+		rule     string
+		expected bool
+		user     bool
+	}{
+		{"instance.compartment.id = 'ocid1.compartment.oc1..prod'", true, false},
+		{"instance.compartment.id='ocid1.compartment.oc1..dev'", false, false},
+		{"instance.compartment.id != 'ocid1.compartment.oc1..dev'", true, false},
+		{"ANY {instance.compartment.id = 'ocid1.compartment.oc1..dev', instance.id = 'ocid1.instance.oc1.phx.host1'}", true, false},
+		{"ALL {instance.compartment.id = 'ocid1.compartment.oc1..dev', instance.id = 'ocid1.instance.oc1.phx.host1'}", false, false},
+		{"all { Instance.Compartment.Id = 'ocid1.compartment.oc1..prod', any {instance.id = 'other', instance.id = 'ocid1.instance.oc1.phx.host1'} }", true, false},
+		{"tag.Ops.Team.value = 'payments'", true, false},
+		{"ALL {tag.ops.team.value = 'payments', tag.ops.env.value != 'prod'}", false, false},
+		// A user has no instance attributes, so neither comparison matches
+		{"instance.id = 'ocid1.instance.oc1.phx.host1'", false, true},
+		{"instance.id != 'ocid1.instance.oc1.phx.host1'", false, true},
+	}
+	for _, tc := range testCases {
+		rule, err := parseMatchingRule(tc.rule)
+		if err != nil {
+			t.Fatalf("unable to parse %q: %v", tc.rule, err)
+		}
+		principalClaims := claims
+		if tc.user {
+			principalClaims = userClaims
+		}
+		if matches := rule.matches(principalClaims); matches != tc.expected {
+			t.Fatalf("expected %q to match %v, got %v", tc.rule, tc.expected, matches)
+		}
+	}
+
+	invalidRules := []struct {
+		rule     string
+		expected string
+	}{
+		{"", "position 1: expected ANY, ALL or a condition, found the end of the rule"},
+		{"ANY instance.id = 'x'", "position 5: expected '{' after ANY"},
+		{"ANY {instance.id = 'x' instance.id = 'y'}", "position 24: expected ',' or '}'"},
+		{"ANY {instance.id = 'x',", "expected ANY, ALL or a condition, found the end of the rule"},
+		{"ANY {instance.id = 'x'", "expected ',' or '}', found the end of the rule"},
+		{"tag.ns.value = 'x'", `position 1: unsupported attribute "tag.ns.value"`},
+		{"instance.id 'x'", "position 13: expected '=' or '!=' after instance.id"},
+		{"instance.id = x", "position 15: expected a value in single quotes"},
+		{"instance.id = 'x", "not terminated by a single quote"},
+		{"instance.id = ''", "the value of instance.id is empty"},
+		{"instance.id = 'x' }", `position 19: unexpected "}" after the end of the rule`},
+		{"ANY {ANY {ANY {ANY {ANY {instance.id = 'x'}}}}}", "nested more than"},
+	}
+	for _, tc := range invalidRules {
+		_, err := parseMatchingRule(tc.rule)
+		if err == nil || !strings.Contains(err.Error(), tc.expected) {
+			t.Fatalf("expected %q to be rejected with %q, got %v", tc.rule, tc.expected, err)
+		}
+	}
+}
+
+func TestBackend_MatchingRule(t *testing.T) {
+	b, config, login := newTestLocalLoginBackend(t, nil)
+
+	writeRole := func(roleName, matchingRule string) *logical.Response {
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.CreateOperation,
+			Path:      "role/" + roleName,
+			Storage:   config.StorageView,
+			Data: map[string]interface{}{
+				"matching_rule":  matchingRule,
+				"token_policies": "policy1",
+			},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp
+	}
+
+	if resp := writeRole("hostrole", "ANY {instance.id = '"+testInstanceId+"', instance.compartment.id = 'ocid1.compartment.oc1..other'}"); resp != nil {
+		t.Fatalf("unexpected response: %#v", resp)
+	}
+	if resp := writeRole("otherrole", "ALL {instance.id = '"+testInstanceId+"', instance.compartment.id = 'ocid1.compartment.oc1..other'}"); resp != nil {
+		t.Fatalf("unexpected response: %#v", resp)
+	}
+	if resp := writeRole("badrole", "ANY {instance.id = '"+testInstanceId+"'"); resp == nil || !resp.IsError() || !strings.Contains(resp.Error().Error(), "position") {
+		t.Fatalf("expected an invalid rule to be rejected: %#v", resp)
+	}
+
+	// A role with only a matching rule is taken without a group membership check
+	if resp := login("hostrole"); resp == nil || resp.Auth == nil {
+		t.Fatalf("expected login to succeed: %#v", resp)
+	}
+	if statusCode, code := loginErrorCode(t, login("otherrole")); statusCode != http.StatusForbidden || code != LoginErrorBindingFailed {
+		t.Fatalf("expected login to fail: %d %s", statusCode, code)
+	}
+
+	resp, err := b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      "role/hostrole",
+		Storage:   config.StorageView,
+	})
+	if err != nil || resp == nil || !strings.HasPrefix(resp.Data["matching_rule"].(string), "ANY {instance.id") {
+		t.Fatalf("unexpected role. resp:%#v\n err:%v", resp, err)
+	}
+}
@@ -0,0 +1,146 @@
+// Copyright © 2019, Oracle and/or its affiliates.
+package ociauth
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	lru "github.com/hashicorp/golang-lru"
+)
+
+// These constants store the defaults of the group membership cache
+const (
+	defaultMembershipCacheSize = 1024
+)
+
+// membershipCache is a bounded LRU cache of group membership decisions, keyed on the subject and the
+// OCIDs that were checked. Entries expire after the TTL that was configured when they were added.
+type membershipCache struct {
+	lock sync.Mutex
+	size int
+	lru  *lru.Cache
+}
+
+// membershipCacheEntry is a cached group membership decision
+type membershipCacheEntry struct {
+	groupIds []string
+
+	// complete is false if the decision was made by stopping on the first match,
+	// in which case groupIds may not contain every group the subject is a member of
+	complete bool
+
+	expiry time.Time
+}
+
+func newMembershipCache(size int) *membershipCache {
+	cache, _ := lru.New(size)
+	return &membershipCache{
+		size: size,
+		lru:  cache,
+	}
+}
+
+// resize changes the number of decisions kept in the cache
+func (c *membershipCache) resize(size int) {
+	if size <= 0 {
+		size = defaultMembershipCacheSize
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.size != size {
+		c.lru.Resize(size)
+		c.size = size
+	}
+}
+
+// get returns the cached decision for the key, if it has not expired
+func (c *membershipCache) get(key string, now time.Time) (*membershipCacheEntry, bool) {
+	value, ok := c.lru.Get(key)
+	if !ok {
+		return nil, false
+	}
+	entry := value.(*membershipCacheEntry)
+	// An expired entry is overwritten by the next decision, as removing it could remove a decision added meanwhile
+	if now.After(entry.expiry) {
+		return nil, false
+	}
+	return entry, true
+}
+
+func (c *membershipCache) add(key string, entry *membershipCacheEntry) {
+	c.lru.Add(key, entry)
+}
+
+// purge removes every decision from the cache
+func (c *membershipCache) purge() {
+	c.lru.Purge()
+}
+
+// removeSubject removes the cached decisions of the subject
+func (c *membershipCache) removeSubject(subjectId string) {
+	prefix := subjectId + "/"
+	for _, key := range c.lru.Keys() {
+		if strings.HasPrefix(key.(string), prefix) {
+			c.lru.Remove(key)
+		}
+	}
+}
+
+// len returns the number of decisions in the cache
+func (c *membershipCache) len() int {
+	return c.lru.Len()
+}
+
+// membershipCacheKey returns the cache key of a decision for the subject and a set of OCIDs
+func membershipCacheKey(subjectId string, ocids []string) string {
+	sorted := append([]string{}, ocids...)
+	sort.Strings(sorted)
+	hash := sha256.Sum256([]byte(strings.Join(sorted, "\n")))
+	return subjectId + "/" + hex.EncodeToString(hash[:])
+}
+
+// cachedFilterGroupMembership returns the subset of the given OCIDs that the principal is a member of,
+// using the membership cache when it is enabled in the config.
+func (b *backend) cachedFilterGroupMembership(ctx context.Context, configEntry *OCIConfigEntry, requestId string, principal Principal, ocids []string, stopOnMatch bool) ([]string, error) {
+	if configEntry == nil || configEntry.MembershipCacheTTL <= 0 || principal.SubjectId == nil {
+		return b.filterGroupMembership(ctx, requestId, principal, ocids, stopOnMatch)
+	}
+
+	b.membershipCache.resize(configEntry.MembershipCacheSize)
+
+	key := membershipCacheKey(*principal.SubjectId, ocids)
+	entry, ok := b.membershipCache.get(key, time.Now())
+	hit := ok && (entry.complete || stopOnMatch)
+	emitCacheMetrics(metricCacheMembership, hit)
+	if hit {
+		b.Logger().Trace(requestId, "group membership cache hit", *principal.SubjectId)
+		return entry.groupIds, nil
+	}
+
+	groupIds, err := b.filterGroupMembership(ctx, requestId, principal, ocids, stopOnMatch)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(groupIds) > 0 {
+		b.membershipCache.add(key, &membershipCacheEntry{
+			groupIds: groupIds,
+			complete: !stopOnMatch || len(ocids) <= filterGroupMembershipChunkSize,
+			expiry:   time.Now().Add(configEntry.MembershipCacheTTL),
+		})
+	} else if configEntry.MembershipCacheNegativeTTL > 0 {
+		b.membershipCache.add(key, &membershipCacheEntry{
+			groupIds: groupIds,
+			complete: true,
+			expiry:   time.Now().Add(configEntry.MembershipCacheNegativeTTL),
+		})
+	}
+
+	return groupIds, nil
+}
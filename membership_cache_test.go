@@ -0,0 +1,133 @@
+// Copyright © 2019, Oracle and/or its affiliates.
+package ociauth
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/oracle/oci-go-sdk/v65/common"
+)
+
+func TestMembershipCache_Expiry(t *testing.T) {
+	cache := newMembershipCache(2)
+	now := time.Now()
+
+	cache.add("a", &membershipCacheEntry{groupIds: []string{"group1"}, complete: true, expiry: now.Add(time.Minute)})
+	if _, ok := cache.get("a", now); !ok {
+		t.Fatal("expected a cache hit")
+	}
+	if _, ok := cache.get("a", now.Add(2*time.Minute)); ok {
+		t.Fatal("expected the entry to be expired")
+	}
+
+	cache.add("b", &membershipCacheEntry{expiry: now.Add(time.Minute)})
+	cache.add("c", &membershipCacheEntry{expiry: now.Add(time.Minute)})
+	cache.add("d", &membershipCacheEntry{expiry: now.Add(time.Minute)})
+	if cache.len() != 2 {
+		t.Fatalf("expected the cache to be bounded, got %d entries", cache.len())
+	}
+
+	if membershipCacheKey("subject", []string{"group1", "group2"}) != membershipCacheKey("subject", []string{"group2", "group1"}) {
+		t.Fatal("expected the cache key to be independent of the OCID order")
+	}
+}
+
+func TestBackend_MembershipCache(t *testing.T) {
+	var requests int32
+	member := true
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+
+		var details FilterGroupMembershipDetails
+		if err := json.NewDecoder(r.Body).Decode(&details); err != nil {
+			t.Errorf("bad request body: %v", err)
+		}
+		result := FilterGroupMembershipResult{Principal: details.Principal, GroupIds: []string{}}
+		if member {
+			result.GroupIds = details.GroupIds
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(result)
+	}))
+	defer server.Close()
+
+	config := logical.TestBackendConfig()
+	config.StorageView = &logical.InmemStorage{}
+	b, err := Backend()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Setup(context.Background(), config); err != nil {
+		t.Fatal(err)
+	}
+	b.authenticationClient = newTestAuthenticationClient(t, server.URL)
+
+	configEntry := &OCIConfigEntry{
+		HomeTenancyId:              testTenancyId,
+		MembershipCacheTTL:         time.Minute,
+		MembershipCacheNegativeTTL: time.Minute,
+		MembershipCacheSize:        10,
+	}
+	principal := Principal{TenantId: common.String(testTenancyId), SubjectId: common.String(testInstanceId), Claims: []Claim{}}
+	ocids := []string{"ocid1.dynamicgroup.oc1..group1"}
+
+	for i := 0; i < 3; i++ {
+		groupIds, err := b.cachedFilterGroupMembership(context.Background(), configEntry, "requestid", principal, ocids, true)
+		if err != nil || len(groupIds) != 1 {
+			t.Fatalf("unexpected result: groupIds:%v err:%v", groupIds, err)
+		}
+	}
+	if requests != 1 {
+		t.Fatalf("expected a single Identity request, got %d", requests)
+	}
+
+	// Purging the cache makes the next login call Identity again
+	resp, err := b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "cache/purge",
+		Storage:   config.StorageView,
+	})
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("cache purge failed. resp:%#v\n err:%v", resp, err)
+	}
+	if resp.Data["membership_entries_purged"] != 1 {
+		t.Fatalf("unexpected purge response: %#v", resp.Data)
+	}
+
+	// Negative decisions are cached too
+	member = false
+	for i := 0; i < 3; i++ {
+		groupIds, err := b.cachedFilterGroupMembership(context.Background(), configEntry, "requestid", principal, ocids, true)
+		if err != nil || len(groupIds) != 0 {
+			t.Fatalf("unexpected result: groupIds:%v err:%v", groupIds, err)
+		}
+	}
+	if requests != 2 {
+		t.Fatalf("expected two Identity requests, got %d", requests)
+	}
+
+	// Writing a role flushes the cache
+	if err := createRole(map[string]interface{}{"ocid_list": ocids[0]}, "devrole", b, config); err != nil {
+		t.Fatal(err)
+	}
+	if b.membershipCache.len() != 0 {
+		t.Fatal("expected the cache to be flushed by the role write")
+	}
+
+	// Nothing is cached when the cache is disabled
+	configEntry.MembershipCacheTTL = 0
+	for i := 0; i < 2; i++ {
+		if _, err := b.cachedFilterGroupMembership(context.Background(), configEntry, "requestid", principal, ocids, true); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if requests != 4 {
+		t.Fatalf("expected four Identity requests, got %d", requests)
+	}
+}
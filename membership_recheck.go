@@ -0,0 +1,204 @@
+// Copyright © 2019, Oracle and/or its affiliates.
+package ociauth
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"time"
+
+	metrics "github.com/hashicorp/go-metrics/compat"
+	uuid "github.com/hashicorp/go-uuid"
+	"github.com/hashicorp/vault/sdk/logical"
+	"golang.org/x/time/rate"
+)
+
+// defaultMembershipRecheckRate is the default number of principals rechecked per second
+const defaultMembershipRecheckRate = 5
+
+// periodicFunc is invoked by Vault about once a minute on the active node. It writes the pending logins to the
+// ledger, tidies the ledger and rechecks the group membership of the principals.
+func (b *backend) periodicFunc(ctx context.Context, req *logical.Request) error {
+	now := time.Now()
+	recordErr := b.recordPendingLogins(ctx, req.Storage)
+	tidyErr := b.tidyPrincipalsIfDue(ctx, req.Storage, now)
+	return errors.Join(recordErr, tidyErr, b.recheckMembershipIfDue(ctx, req.Storage, now))
+}
+
+// recheckMembershipIfDue rechecks the group membership of the principals with outstanding tokens,
+// if the configured interval has elapsed since the last complete recheck
+func (b *backend) recheckMembershipIfDue(ctx context.Context, s logical.Storage, now time.Time) error {
+	configEntry, err := b.getOCIConfig(ctx, s)
+	if err != nil {
+		return err
+	}
+	interval := configEntry.membershipRecheckInterval()
+	if interval <= 0 {
+		return nil
+	}
+
+	// A recheck that is still running is not started again
+	if !b.recheckMutex.TryLock() {
+		return nil
+	}
+	defer b.recheckMutex.Unlock()
+
+	if now.Sub(b.lastRecheck) < interval {
+		return nil
+	}
+
+	if _, err := b.getAuthClient(); err != nil {
+		return err
+	}
+
+	if err := b.recheckMembership(ctx, s, configEntry, now); err != nil {
+		return err
+	}
+	b.lastRecheck = now
+
+	return nil
+}
+
+// recheckMembership checks that each principal with an outstanding token is still a member of the OCIDs of every role
+// it logged in to, and is not denied by them. Principals that are no longer allowed to take one of their roles are
+// flagged in the ledger, and their cached group membership decisions are removed. The requests to OCI Identity are
+// rate limited.
+func (b *backend) recheckMembership(ctx context.Context, s logical.Storage, configEntry *OCIConfigEntry, now time.Time) error {
+	subjectIds, err := s.List(ctx, principalStoragePrefix)
+	if err != nil {
+		return err
+	}
+
+	limiter := rate.NewLimiter(rate.Limit(configEntry.membershipRecheckRate()), 1)
+	checked, lost := 0, 0
+	for _, subjectId := range subjectIds {
+		entry, err := getPrincipal(ctx, s, subjectId)
+		if err != nil {
+			return err
+		}
+		if entry == nil || len(entry.Claims) == 0 {
+			continue
+		}
+
+		roleLogins := entry.roleLogins()
+		roleNames := make([]string, 0, len(roleLogins))
+		for roleName := range roleLogins {
+			// The roles that were already lost are not checked again until the next login to them
+			if _, lost := entry.LostRoles[roleName]; !lost {
+				roleNames = append(roleNames, roleName)
+			}
+		}
+		sort.Strings(roleNames)
+
+		principalChecked := false
+		var lostRoles []string
+		for _, roleName := range roleNames {
+			isMember, roleChecked, err := b.recheckRole(ctx, s, limiter, entry, roleName, roleLogins[roleName], now)
+			if err != nil {
+				return err
+			}
+			principalChecked = principalChecked || roleChecked
+			if !isMember {
+				lostRoles = append(lostRoles, roleName)
+			}
+		}
+		if !principalChecked {
+			continue
+		}
+		checked++
+
+		err = b.updatePrincipal(ctx, s, subjectId, func(current *principalEntry) {
+			current.MembershipCheckedAt = now
+			for _, roleName := range lostRoles {
+				// A login to the role since the entry was read has checked the membership again
+				if !current.roleLogins()[roleName].Equal(roleLogins[roleName]) {
+					continue
+				}
+				if current.LostRoles == nil {
+					current.LostRoles = make(map[string]time.Time)
+				}
+				current.LostRoles[roleName] = now
+			}
+		})
+		if err != nil {
+			return err
+		}
+
+		if len(lostRoles) > 0 {
+			lost++
+			b.membershipCache.removeSubject(subjectId)
+			// The next login to a lost role removes it, so it is not deferred
+			b.principalLogins.forget(subjectId)
+		}
+		for _, roleName := range lostRoles {
+			metrics.IncrCounterWithLabels([]string{metricsPrefix, "recheck", "membership_lost"}, 1, []metrics.Label{
+				{Name: metricLabelRole, Value: roleName},
+			})
+			b.Logger().Warn("Principal is no longer allowed to take the role, the renewal of its tokens is refused",
+				"subject", subjectId, "role", roleName, "last_login", roleLogins[roleName])
+		}
+	}
+
+	b.Logger().Debug("rechecked the group membership of the principals", "checked", checked, "lost", lost)
+
+	return nil
+}
+
+// recheckRole checks that the principal of the ledger entry is still allowed to take the role, if its token of the
+// last login to the role may be outstanding. It returns false if the principal is no longer allowed to, and whether
+// the role was checked. A role that can not be rechecked because Identity did not answer is assumed to be allowed.
+func (b *backend) recheckRole(ctx context.Context, s logical.Storage, limiter *rate.Limiter, entry *principalEntry, roleName string, lastLogin time.Time, now time.Time) (bool, bool, error) {
+	roleEntry, err := b.getOCIRole(ctx, s, roleName)
+	if err != nil {
+		return false, false, err
+	}
+	if roleEntry == nil || !b.tokenOutstanding(lastLogin, roleEntry, now) {
+		return true, false, nil
+	}
+
+	// The principal must still be a member of the role OCIDs, unless it is a bound subject, and of no denied group.
+	// Roles bound only by claims, and bound subjects, have no membership to recheck without denied groups.
+	var roleOcids []string
+	requiresGroups := roleEntry.requiresGroups() && !roleEntry.bindsSubject(entry.SubjectId)
+	if requiresGroups {
+		roleOcids = roleEntry.grantingOcids()
+	}
+	if roleEntry.deniesSubject(entry.SubjectId) {
+		return false, true, nil
+	}
+	if !requiresGroups && len(roleEntry.DeniedGroupIds) == 0 {
+		return true, false, nil
+	}
+
+	if err := limiter.Wait(ctx); err != nil {
+		return false, false, err
+	}
+
+	requestId, _ := uuid.GenerateUUID()
+	ocids := appendMissingOcids(roleOcids, roleEntry.DeniedGroupIds)
+	stopOnMatch := roleEntry.matchesAnyGroup() && len(roleEntry.DeniedGroupIds) == 0
+	groupIds, err := b.filterGroupMembership(ctx, requestId, entry.principal(), ocids, stopOnMatch)
+	if err != nil {
+		// A principal is only flagged when Identity has answered
+		b.Logger().Warn("Unable to recheck the group membership", "subject", entry.SubjectId, "role", roleName, "err", err)
+		return true, false, nil
+	}
+	return len(roleEntry.deniedGroups(groupIds)) == 0 && (!requiresGroups || roleEntry.matchesGroups(groupIds)), true, nil
+}
+
+// tokenOutstanding returns true if the token of the last login of the principal to the role may not have expired yet.
+// The tokens are renewable while the membership is rechecked, so they may be renewed up to the max TTL of the role or
+// of the system, and periodic tokens without an explicit max TTL may be renewed forever.
+func (b *backend) tokenOutstanding(lastLogin time.Time, roleEntry *OCIRoleEntry, now time.Time) bool {
+	if roleEntry.TokenPeriod > 0 && roleEntry.TokenExplicitMaxTTL == 0 {
+		return true
+	}
+	ttl := roleEntry.TokenMaxTTL
+	if ttl == 0 {
+		ttl = b.System().MaxLeaseTTL()
+	}
+	if roleEntry.TokenExplicitMaxTTL > 0 && (roleEntry.TokenPeriod > 0 || ttl > roleEntry.TokenExplicitMaxTTL) {
+		ttl = roleEntry.TokenExplicitMaxTTL
+	}
+	return lastLogin.Add(ttl).After(now)
+}
@@ -0,0 +1,275 @@
+// Copyright © 2019, Oracle and/or its affiliates.
+package ociauth
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+func TestBackend_MembershipRecheck(t *testing.T) {
+	group := "ocid1.dynamicgroup.oc1..hosts"
+
+	var (
+		lock     sync.Mutex
+		isMember = true
+		requests int32
+	)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+
+		var details FilterGroupMembershipDetails
+		if err := json.NewDecoder(r.Body).Decode(&details); err != nil {
+			t.Errorf("bad request body: %v", err)
+		}
+		if details.Principal.SubjectId == nil || *details.Principal.SubjectId != testInstanceId || len(details.Principal.Claims) == 0 {
+			t.Errorf("unexpected principal: %s", details.Principal)
+		}
+		result := FilterGroupMembershipResult{Principal: details.Principal, GroupIds: []string{}}
+		lock.Lock()
+		if isMember {
+			result.GroupIds = details.GroupIds
+		}
+		lock.Unlock()
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(result)
+	}))
+	defer server.Close()
+
+	b, config, login := newTestLocalLoginBackend(t, nil)
+	b.authenticationClient = newTestAuthenticationClient(t, server.URL)
+
+	if err := createRole(map[string]interface{}{"ocid_list": group, "token_ttl": "1h", "token_max_ttl": "1h"}, "grouprole", b, config); err != nil {
+		t.Fatal(err)
+	}
+	resp, err := b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "config",
+		Storage:   config.StorageView,
+		Data: map[string]interface{}{
+			MembershipCacheTTLConfigName:        "1h",
+			MembershipRecheckIntervalConfigName: "10m",
+			MembershipRecheckRateConfigName:     100,
+		},
+	})
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("Config update failed. resp:%#v\n err:%v", resp, err)
+	}
+
+	// The tokens are renewable while the membership is rechecked
+	if resp := login("grouprole"); resp == nil || resp.Auth == nil || !resp.Auth.Renewable {
+		t.Fatalf("expected login to succeed with a renewable token: %#v", resp)
+	}
+	if b.membershipCache.len() != 1 {
+		t.Fatalf("expected the decision to be cached")
+	}
+
+	readPrincipal := func() *principalEntry {
+		entry, err := getPrincipal(context.Background(), config.StorageView, testInstanceId)
+		if err != nil || entry == nil {
+			t.Fatalf("unable to read the principal: %#v err:%v", entry, err)
+		}
+		return entry
+	}
+
+	// A member is not flagged
+	now := time.Now()
+	if err := b.recheckMembershipIfDue(context.Background(), config.StorageView, now); err != nil {
+		t.Fatal(err)
+	}
+	if entry := readPrincipal(); len(entry.LostRoles) != 0 || entry.MembershipCheckedAt.IsZero() {
+		t.Fatalf("unexpected principal: %#v", entry)
+	}
+
+	// The recheck only runs once per interval
+	lock.Lock()
+	isMember = false
+	lock.Unlock()
+	atomic.StoreInt32(&requests, 0)
+	if err := b.recheckMembershipIfDue(context.Background(), config.StorageView, now.Add(time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	if atomic.LoadInt32(&requests) != 0 {
+		t.Fatal("expected the recheck to wait for the interval")
+	}
+
+	// A principal that left the group is flagged, and its cached decisions are removed
+	if err := b.recheckMembershipIfDue(context.Background(), config.StorageView, now.Add(15*time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	if entry := readPrincipal(); entry.LostRoles["grouprole"].IsZero() {
+		t.Fatalf("expected the principal to be flagged: %#v", entry)
+	}
+	if b.membershipCache.len() != 0 {
+		t.Fatal("expected the cached decision to be removed")
+	}
+
+	resp, err = b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      "principals/" + testInstanceId,
+		Storage:   config.StorageView,
+	})
+	if err != nil || resp == nil || resp.Data["membership_lost"] != true {
+		t.Fatalf("unexpected principal. resp:%#v\n err:%v", resp, err)
+	}
+
+	// The principal can not log in again, and tokens that expired are not rechecked
+	if statusCode, code := loginErrorCode(t, login("grouprole")); statusCode != http.StatusForbidden || code != LoginErrorNotInGroup {
+		t.Fatalf("expected login to fail: %d %s", statusCode, code)
+	}
+	atomic.StoreInt32(&requests, 0)
+	err = b.updatePrincipal(context.Background(), config.StorageView, testInstanceId, func(entry *principalEntry) {
+		entry.LostRoles = nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := b.recheckMembershipIfDue(context.Background(), config.StorageView, now.Add(2*time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	if atomic.LoadInt32(&requests) != 0 {
+		t.Fatal("expected principals without outstanding tokens not to be rechecked")
+	}
+
+	// A principal that is denied by the role is flagged without a request to Identity
+	lock.Lock()
+	isMember = true
+	lock.Unlock()
+	if resp := login("grouprole"); resp == nil || resp.Auth == nil {
+		t.Fatalf("expected login to succeed: %#v", resp)
+	}
+	if err := createRole(map[string]interface{}{"ocid_list": group, "token_ttl": "1h", "token_max_ttl": "1h", "denied_subject_ids": testInstanceId}, "grouprole", b, config); err != nil {
+		t.Fatal(err)
+	}
+	configEntry, err := b.getOCIConfig(context.Background(), config.StorageView)
+	if err != nil {
+		t.Fatal(err)
+	}
+	atomic.StoreInt32(&requests, 0)
+	if err := b.recheckMembership(context.Background(), config.StorageView, configEntry, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	if entry := readPrincipal(); entry.LostRoles["grouprole"].IsZero() || atomic.LoadInt32(&requests) != 0 {
+		t.Fatalf("expected the denied principal to be flagged: %#v", entry)
+	}
+}
+
+func TestBackend_MembershipRecheckRoles(t *testing.T) {
+	hostsGroup := "ocid1.dynamicgroup.oc1..hosts"
+	adminsGroup := "ocid1.dynamicgroup.oc1..admins"
+
+	var (
+		lock    sync.Mutex
+		members = map[string]bool{hostsGroup: true, adminsGroup: true}
+	)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var details FilterGroupMembershipDetails
+		if err := json.NewDecoder(r.Body).Decode(&details); err != nil {
+			t.Errorf("bad request body: %v", err)
+		}
+		result := FilterGroupMembershipResult{Principal: details.Principal, GroupIds: []string{}}
+		lock.Lock()
+		for _, groupId := range details.GroupIds {
+			if members[groupId] {
+				result.GroupIds = append(result.GroupIds, groupId)
+			}
+		}
+		lock.Unlock()
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(result)
+	}))
+	defer server.Close()
+
+	b, config, login := newTestLocalLoginBackend(t, nil)
+	b.authenticationClient = newTestAuthenticationClient(t, server.URL)
+	for roleName, group := range map[string]string{"adminrole": adminsGroup, "hostrole": hostsGroup} {
+		if err := createRole(map[string]interface{}{"ocid_list": group, "token_ttl": "1h"}, roleName, b, config); err != nil {
+			t.Fatal(err)
+		}
+	}
+	resp, err := b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "config",
+		Storage:   config.StorageView,
+		Data:      map[string]interface{}{MembershipRecheckIntervalConfigName: "10m"},
+	})
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("Config update failed. resp:%#v\n err:%v", resp, err)
+	}
+	configEntry, err := b.getOCIConfig(context.Background(), config.StorageView)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The principal logs in to both roles, and last to the role it stays a member of
+	adminResp := login("adminrole")
+	if adminResp == nil || adminResp.Auth == nil || !adminResp.Auth.Renewable {
+		t.Fatalf("expected login to succeed with a renewable token: %#v", adminResp)
+	}
+	hostResp := login("hostrole")
+	if hostResp == nil || hostResp.Auth == nil {
+		t.Fatalf("expected login to succeed: %#v", hostResp)
+	}
+
+	renew := func(auth *logical.Auth) *logical.Response {
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.RenewOperation,
+			Path:      "login",
+			Storage:   config.StorageView,
+			Auth:      auth,
+		})
+		if err != nil && err != logical.ErrInvalidRequest {
+			t.Fatal(err)
+		}
+		return resp
+	}
+	if resp := renew(adminResp.Auth); resp == nil || resp.IsError() || resp.Auth == nil {
+		t.Fatalf("expected renewal to succeed: %#v", resp)
+	}
+
+	// The token of the earlier role is rechecked too, and only the role that was lost is flagged
+	lock.Lock()
+	members[adminsGroup] = false
+	lock.Unlock()
+	if err := b.recheckMembership(context.Background(), config.StorageView, configEntry, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	entry, err := getPrincipal(context.Background(), config.StorageView, testInstanceId)
+	if err != nil || entry == nil || len(entry.LostRoles) != 1 || entry.LostRoles["adminrole"].IsZero() || entry.LastRole != "hostrole" {
+		t.Fatalf("expected the principal to be flagged: %#v %v", entry, err)
+	}
+
+	// Only the tokens of the lost role can not be renewed
+	if resp := renew(adminResp.Auth); resp == nil || !resp.IsError() {
+		t.Fatalf("expected renewal to be refused: %#v", resp)
+	}
+	if resp := renew(hostResp.Auth); resp == nil || resp.IsError() {
+		t.Fatalf("expected renewal to succeed: %#v", resp)
+	}
+
+	// A login to another role does not lift the flag
+	if resp := login("hostrole"); resp == nil || resp.Auth == nil {
+		t.Fatalf("expected login to succeed: %#v", resp)
+	}
+	if resp := renew(adminResp.Auth); resp == nil || !resp.IsError() {
+		t.Fatalf("expected renewal to be refused: %#v", resp)
+	}
+
+	// A login to the lost role, once the principal is allowed to take it again, lifts the flag
+	lock.Lock()
+	members[adminsGroup] = true
+	lock.Unlock()
+	if resp := login("adminrole"); resp == nil || resp.Auth == nil {
+		t.Fatalf("expected login to succeed: %#v", resp)
+	}
+	if resp := renew(adminResp.Auth); resp == nil || resp.IsError() {
+		t.Fatalf("expected renewal to succeed: %#v", resp)
+	}
+}
@@ -0,0 +1,102 @@
+// Copyright © 2019, Oracle and/or its affiliates.
+package ociauth
+
+import (
+	"time"
+
+	metrics "github.com/hashicorp/go-metrics/compat"
+)
+
+// metricsPrefix is the prefix of the names of the metrics emitted by the plugin
+const metricsPrefix = "oci"
+
+// These constants are the labels of the metrics
+const (
+	metricLabelRole          = "role"
+	metricLabelPrincipalType = "principal_type"
+	metricLabelOutcome       = "outcome"
+	metricLabelReason        = "reason"
+	metricLabelOperation     = "operation"
+	metricLabelCache         = "cache"
+	metricLabelLimit         = "limit"
+	metricLabelKind          = "kind"
+)
+
+// These constants are the values of the labels of the metrics
+const (
+	metricOutcomeSuccess = "success"
+	metricOutcomeFailure = "failure"
+	metricUnknown        = "unknown"
+
+	metricAuthenticateClient    = "authenticate_client"
+	metricFilterGroupMembership = "filter_group_membership"
+
+	metricCacheMembership  = "membership"
+	metricCacheConfig      = "config"
+	metricCacheRole        = "role"
+	metricCacheGroupName   = "group_name"
+	metricCacheCompartment = "compartment"
+	metricCacheInstance    = "instance"
+	metricCacheUser        = "user"
+)
+
+// emitLoginMetrics counts the login by role, principal type and outcome, and the failure by its reason.
+// The duration of the login is measured as well.
+func emitLoginMetrics(attempt *loginAttempt, err error) {
+	roleName := attempt.labelRoleName()
+	principalType := attempt.principalType
+	if principalType == "" {
+		principalType = metricUnknown
+	}
+	outcome := metricOutcomeSuccess
+	if err != nil {
+		outcome = metricOutcomeFailure
+	}
+
+	labels := []metrics.Label{
+		{Name: metricLabelRole, Value: roleName},
+		{Name: metricLabelPrincipalType, Value: principalType},
+		{Name: metricLabelOutcome, Value: outcome},
+	}
+	metrics.IncrCounterWithLabels([]string{metricsPrefix, "login"}, 1, labels)
+	metrics.MeasureSinceWithLabels([]string{metricsPrefix, "login", "duration"}, attempt.start, labels)
+
+	if err != nil {
+		metrics.IncrCounterWithLabels([]string{metricsPrefix, "login", "failure"}, 1, []metrics.Label{
+			{Name: metricLabelRole, Value: roleName},
+			{Name: metricLabelReason, Value: asLoginError(err).Code},
+		})
+	}
+}
+
+// emitIdentityMetrics measures the latency of a call to OCI Identity, by operation and outcome
+func emitIdentityMetrics(operation string, start time.Time, err error) {
+	outcome := metricOutcomeSuccess
+	if err != nil {
+		outcome = metricOutcomeFailure
+	}
+
+	metrics.MeasureSinceWithLabels([]string{metricsPrefix, "identity", "request"}, start, []metrics.Label{
+		{Name: metricLabelOperation, Value: operation},
+		{Name: metricLabelOutcome, Value: outcome},
+	})
+}
+
+// emitCacheMetrics counts a lookup in one of the caches, so that the hit ratio of each cache can be derived
+func emitCacheMetrics(cache string, hit bool) {
+	outcome := "miss"
+	if hit {
+		outcome = "hit"
+	}
+
+	metrics.IncrCounterWithLabels([]string{metricsPrefix, "cache", outcome}, 1, []metrics.Label{
+		{Name: metricLabelCache, Value: cache},
+	})
+}
+
+// emitLockoutMetrics counts the lockouts of keyIds and subjects
+func emitLockoutMetrics(kind string) {
+	metrics.IncrCounterWithLabels([]string{metricsPrefix, "login", "lockout"}, 1, []metrics.Label{
+		{Name: metricLabelKind, Value: kind},
+	})
+}
@@ -0,0 +1,73 @@
+// Copyright © 2019, Oracle and/or its affiliates.
+package ociauth
+
+import (
+	"testing"
+	"time"
+
+	metrics "github.com/hashicorp/go-metrics/compat"
+)
+
+// newTestMetricsSink replaces the global metrics sink with an in-memory sink for the duration of the test
+func newTestMetricsSink(t *testing.T) *metrics.InmemSink {
+	t.Helper()
+
+	newGlobal := func(sink metrics.MetricSink) {
+		metricsConfig := metrics.DefaultConfig("")
+		metricsConfig.EnableHostname = false
+		metricsConfig.EnableRuntimeMetrics = false
+		if _, err := metrics.NewGlobal(metricsConfig, sink); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	sink := metrics.NewInmemSink(time.Hour, time.Hour)
+	newGlobal(sink)
+	t.Cleanup(func() {
+		newGlobal(&metrics.BlackholeSink{})
+	})
+	return sink
+}
+
+// testMetricsCounters returns the value of each counter of the sink, keyed on its name and labels
+func testMetricsCounters(sink *metrics.InmemSink) map[string]int {
+	counters := make(map[string]int)
+	for _, interval := range sink.Data() {
+		interval.RLock()
+		for key, counter := range interval.Counters {
+			counters[key] += counter.Count
+		}
+		interval.RUnlock()
+	}
+	return counters
+}
+
+func TestBackend_LoginMetrics(t *testing.T) {
+	sink := newTestMetricsSink(t)
+
+	_, _, login := newTestLocalLoginBackend(t, map[string]string{
+		"boundrole": testCompartmentId,
+		"otherrole": "ocid1.compartment.oc1..othercompartment",
+	})
+	for _, roleName := range []string{"boundrole", "boundrole", "otherrole", "missingrole"} {
+		login(roleName)
+	}
+
+	counters := testMetricsCounters(sink)
+	expected := map[string]int{
+		"oci.login;role=boundrole;principal_type=instance;outcome=success": 2,
+		"oci.login;role=otherrole;principal_type=instance;outcome=failure": 1,
+		"oci.login;role=unknown;principal_type=unknown;outcome=failure":    1,
+		"oci.login.failure;role=otherrole;reason=binding_failed":           1,
+		"oci.login.failure;role=unknown;reason=invalid_role":               1,
+		"oci.cache.miss;cache=role":                                        3,
+	}
+	for key, count := range expected {
+		if counters[key] != count {
+			t.Fatalf("expected %s to be %d, got %d: %#v", key, count, counters[key], counters)
+		}
+	}
+	if counters["oci.cache.hit;cache=role"] == 0 || counters["oci.cache.hit;cache=config"] == 0 {
+		t.Fatalf("expected entry cache hits: %#v", counters)
+	}
+}
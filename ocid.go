@@ -0,0 +1,87 @@
+// Copyright © 2019, Oracle and/or its affiliates.
+package ociauth
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/hashicorp/go-secure-stdlib/strutil"
+)
+
+// These constants are the OCID resource types that roles refer to
+const (
+	ocidTypeGroup        = "group"
+	ocidTypeDynamicGroup = "dynamicgroup"
+	ocidTypeCompartment  = "compartment"
+	ocidTypeTenancy      = "tenancy"
+	ocidTypeInstance     = "instance"
+	ocidTypeUser         = "user"
+)
+
+const ocidVersion = "ocid1"
+
+var (
+	ocidNamePattern   = regexp.MustCompile(`^[a-z0-9]+$`)
+	ocidRegionPattern = regexp.MustCompile(`^[a-z0-9-]*$`)
+)
+
+// ocid is an Oracle Cloud ID of the form ocid1.<resource type>.<realm>.[region][.future use].<unique ID>
+type ocid struct {
+	resourceType string
+	realm        string
+}
+
+// parseOCID parses the resource type and realm of an OCID, and checks its format
+func parseOCID(value string) (*ocid, error) {
+	parts := strings.Split(value, ".")
+	if len(parts) < 5 || len(parts) > 6 || parts[0] != ocidVersion {
+		return nil, fmt.Errorf("%q is not an OCID", value)
+	}
+
+	resourceType, realm, uniqueId := parts[1], parts[2], parts[len(parts)-1]
+	if !ocidNamePattern.MatchString(resourceType) || !ocidNamePattern.MatchString(realm) || !ocidNamePattern.MatchString(uniqueId) {
+		return nil, fmt.Errorf("%q is not an OCID", value)
+	}
+	for _, part := range parts[3 : len(parts)-1] {
+		if !ocidRegionPattern.MatchString(part) {
+			return nil, fmt.Errorf("%q is not an OCID", value)
+		}
+	}
+
+	return &ocid{
+		resourceType: resourceType,
+		realm:        realm,
+	}, nil
+}
+
+// validateOCIDs checks that every value of the field is an OCID of one of the given resource types, in the given realm.
+// The realm is not checked if it is empty. It returns warnings for values that are valid but suspicious.
+func validateOCIDs(field string, values []string, realm string, resourceTypes ...string) ([]string, error) {
+	var warnings []string
+	for i, value := range values {
+		parsed, err := parseOCID(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s: %w", field, err)
+		}
+		if !strutil.StrListContains(resourceTypes, parsed.resourceType) {
+			return nil, fmt.Errorf("invalid %s: %q is a %s OCID, expected a %s OCID", field, value, parsed.resourceType, strings.Join(resourceTypes, " or "))
+		}
+		if realm != "" && parsed.realm != realm {
+			return nil, fmt.Errorf("invalid %s: %q is in the realm %q, but the home tenancy is in the realm %q", field, value, parsed.realm, realm)
+		}
+		if strutil.StrListContains(values[:i], value) {
+			warnings = append(warnings, fmt.Sprintf("%s contains %q more than once", field, value))
+		}
+	}
+	return warnings, nil
+}
+
+// homeTenancyRealm returns the realm of the home tenancy, or an empty string if it is not known
+func homeTenancyRealm(configEntry *OCIConfigEntry) string {
+	parsed, err := parseOCID(configEntry.homeTenancyId())
+	if err != nil {
+		return ""
+	}
+	return parsed.realm
+}
@@ -0,0 +1,61 @@
+// Copyright © 2019, Oracle and/or its affiliates.
+package ociauth
+
+import (
+	"testing"
+)
+
+func TestParseOCID(t *testing.T) {
+	testCases := []struct {
+		value        string
+		resourceType string
+		realm        string
+		expectErr    bool
+	}{
+		{"ocid1.group.oc1..aaaaaaaa", "group", "oc1", false},
+		{"ocid1.dynamicgroup.oc2..aaaaaaaa", "dynamicgroup", "oc2", false},
+		{"ocid1.instance.oc1.phx.aaaaaaaa", "instance", "oc1", false},
+		{"ocid1.instance.oc1.us-phoenix-1.future.aaaaaaaa", "instance", "oc1", false},
+		{"ocid1", "", "", true},
+		{"ocid1.group.oc1.aaaaaaaa", "", "", true},
+		{"ocid2.group.oc1..aaaaaaaa", "", "", true},
+		{"ocid1.group.oc1..", "", "", true},
+		{"ocid1..oc1..aaaaaaaa", "", "", true},
+		{"ocid1.Group.oc1..aaaaaaaa", "", "", true},
+		{" ocid1.group.oc1..aaaaaaaa", "", "", true},
+	}
+
+	for _, tc := range testCases {
+		parsed, err := parseOCID(tc.value)
+		if (err != nil) != tc.expectErr {
+			t.Fatalf("unexpected result for %q: %v", tc.value, err)
+		}
+		if err == nil && (parsed.resourceType != tc.resourceType || parsed.realm != tc.realm) {
+			t.Fatalf("unexpected OCID for %q: %#v", tc.value, parsed)
+		}
+	}
+}
+
+func TestValidateOCIDs(t *testing.T) {
+	groups := []string{"ocid1.group.oc1..group1", "ocid1.dynamicgroup.oc1..group2"}
+	if warnings, err := validateOCIDs("ocid_list", groups, "oc1", ocidTypeGroup, ocidTypeDynamicGroup); err != nil || len(warnings) != 0 {
+		t.Fatalf("unexpected result: warnings:%v err:%v", warnings, err)
+	}
+
+	// The realm is only checked when it is known
+	if _, err := validateOCIDs("ocid_list", groups, "oc2", ocidTypeGroup, ocidTypeDynamicGroup); err == nil {
+		t.Fatal("expected OCIDs of another realm to be rejected")
+	}
+	if _, err := validateOCIDs("ocid_list", groups, "", ocidTypeGroup, ocidTypeDynamicGroup); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := validateOCIDs("ocid_list", []string{"ocid1.user.oc1..user1"}, "oc1", ocidTypeGroup, ocidTypeDynamicGroup); err == nil {
+		t.Fatal("expected a user OCID to be rejected")
+	}
+
+	warnings, err := validateOCIDs("ocid_list", append(groups, groups[0]), "oc1", ocidTypeGroup, ocidTypeDynamicGroup)
+	if err != nil || len(warnings) != 1 {
+		t.Fatalf("expected a warning for the duplicate OCID: warnings:%v err:%v", warnings, err)
+	}
+}
@@ -0,0 +1,66 @@
+// Copyright © 2019, Oracle and/or its affiliates.
+package ociauth
+
+import (
+	"context"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+func pathCachePurge(b *backend) *framework.Path {
+	return &framework.Path{
+		Pattern: "cache/purge",
+
+		DisplayAttrs: &framework.DisplayAttributes{
+			OperationPrefix: operationPrefixOCI,
+			OperationVerb:   "purge",
+			OperationSuffix: "cache",
+		},
+
+		Operations: map[logical.Operation]framework.OperationHandler{
+			logical.UpdateOperation: &framework.PathOperation{
+				Callback: b.pathCachePurgeUpdate,
+			},
+		},
+
+		HelpSynopsis:    pathCachePurgeSyn,
+		HelpDescription: pathCachePurgeDesc,
+	}
+}
+
+// Purge the caches
+func (b *backend) pathCachePurgeUpdate(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	purged := b.membershipCache.len()
+	b.membershipCache.purge()
+	instancesPurged := b.instanceCache.Len()
+	b.instanceCache.Purge()
+	usersPurged := b.userCache.Len()
+	b.userCache.Purge()
+
+	b.Logger().Debug("purged the group membership cache", "entries", purged)
+	b.Logger().Debug("purged the instance cache", "entries", instancesPurged)
+	b.Logger().Debug("purged the user cache", "entries", usersPurged)
+
+	return &logical.Response{
+		Data: map[string]interface{}{
+			"membership_entries_purged": purged,
+			"instance_entries_purged":   instancesPurged,
+			"user_entries_purged":       usersPurged,
+		},
+	}, nil
+}
+
+const pathCachePurgeSyn = `
+Purges the cached group membership decisions, instance tags and user attributes.
+`
+
+const pathCachePurgeDesc = `
+Removes every cached group membership decision, so that the next login of each principal
+checks its group membership with OCI Identity again. The cached tags of instances and attributes of users are removed as
+well, so that they are looked up again.
+
+Example:
+
+vault write -f /auth/oci/cache/purge
+`
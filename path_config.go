@@ -4,14 +4,53 @@ package ociauth
 import (
 	"context"
 	"fmt"
+	"github.com/hashicorp/go-secure-stdlib/strutil"
 	"github.com/hashicorp/vault/sdk/framework"
 	"github.com/hashicorp/vault/sdk/logical"
+	"net/url"
 	"strings"
+	"time"
 )
 
 // These constants store the configuration keys
 const (
-	HomeTenancyIdConfigName = "home_tenancy_id"
+	HomeTenancyIdConfigName                = "home_tenancy_id"
+	InstancePrincipalCABundleConfigName    = "instance_principal_ca_bundle"
+	InstancePrincipalTokenSignerConfigName = "instance_principal_token_signer"
+	InstancePrincipalHostsConfigName       = "instance_principal_hosts"
+	MembershipCacheTTLConfigName           = "membership_cache_ttl"
+	MembershipCacheNegativeTTLConfigName   = "membership_cache_negative_ttl"
+	MembershipCacheSizeConfigName          = "membership_cache_size"
+	RolelessLoginConfigName                = "roleless_login"
+	GroupAliasesConfigName                 = "group_aliases"
+	OnlineOCIDValidationConfigName         = "online_ocid_validation"
+	PrincipalRetentionConfigName           = "principal_retention"
+	MembershipRecheckIntervalConfigName    = "membership_recheck_interval"
+	MembershipRecheckRateConfigName        = "membership_recheck_rate"
+	SubjectLoginRateConfigName             = "subject_login_rate"
+	SubjectLoginBurstConfigName            = "subject_login_burst"
+	RoleLoginRateConfigName                = "role_login_rate"
+	RoleLoginBurstConfigName               = "role_login_burst"
+	LockoutThresholdConfigName             = "lockout_threshold"
+	LockoutDurationConfigName              = "lockout_duration"
+	LockoutCounterResetConfigName          = "lockout_counter_reset"
+	InstanceLookupConfigName               = "instance_lookup"
+	ComputeEndpointConfigName              = "compute_endpoint"
+	UserLookupConfigName                   = "user_lookup"
+)
+
+// These constants define the modes of role-less login
+const (
+	RolelessLoginDisabled = "disabled"
+	RolelessLoginPriority = "priority"
+	RolelessLoginUnion    = "union"
+)
+
+// These constants define how the group aliases of a login are named
+const (
+	GroupAliasesDisabled = "disabled"
+	GroupAliasesOCID     = "ocid"
+	GroupAliasesName     = "name"
 )
 
 func pathConfig(b *backend) *framework.Path {
@@ -27,6 +66,100 @@ func pathConfig(b *backend) *framework.Path {
 				Type:        framework.TypeString,
 				Description: "The tenancy id of the account.",
 			},
+			InstancePrincipalCABundleConfigName: {
+				Type:        framework.TypeString,
+				Description: "PEM encoded CA bundle used to verify instance principal requests locally, without calling OCI Identity. Requires instance_principal_token_signer and instance_principal_hosts.",
+			},
+			InstancePrincipalTokenSignerConfigName: {
+				Type:        framework.TypeString,
+				Description: "Common name of the certificate that signs instance principal security tokens. Only tokens signed by this certificate are verified locally; certificates of instances and CAs are never trusted as signers.",
+			},
+			InstancePrincipalHostsConfigName: {
+				Type:        framework.TypeCommaStringSlice,
+				Description: "Hosts, with their port if clients send it, that locally verified instance principal requests must be signed for, such as the address of Vault that clients log in to. The signed host header of the request must be one of them.",
+			},
+			MembershipCacheTTLConfigName: {
+				Type:        framework.TypeDurationSecond,
+				Description: "Duration for which group membership decisions are cached. Caching is disabled if not set.",
+			},
+			MembershipCacheNegativeTTLConfigName: {
+				Type:        framework.TypeDurationSecond,
+				Description: "Duration for which decisions that found no group membership are cached. Negative decisions are not cached if not set.",
+			},
+			MembershipCacheSizeConfigName: {
+				Type:        framework.TypeInt,
+				Description: "Maximum number of group membership decisions that are cached.",
+				Default:     defaultMembershipCacheSize,
+			},
+			RolelessLoginConfigName: {
+				Type:          framework.TypeString,
+				Description:   `How a login without a role chooses the role. "priority" uses the matched role with the highest priority, "union" also grants the policies of every other matched role. Role-less login is "disabled" by default.`,
+				Default:       RolelessLoginDisabled,
+				AllowedValues: []interface{}{RolelessLoginDisabled, RolelessLoginPriority, RolelessLoginUnion},
+			},
+			GroupAliasesConfigName: {
+				Type:          framework.TypeString,
+				Description:   `How the group aliases of the matched Groups and Dynamic Groups are named. "ocid" uses the OCID of the group, "name" uses its name resolved through OCI Identity. Group aliases are not set if "disabled", which is the default.`,
+				Default:       GroupAliasesDisabled,
+				AllowedValues: []interface{}{GroupAliasesDisabled, GroupAliasesOCID, GroupAliasesName},
+			},
+			OnlineOCIDValidationConfigName: {
+				Type:        framework.TypeBool,
+				Description: "If set, role writes check with OCI Identity that each Group or Dynamic Group OCID exists in the home tenancy.",
+			},
+			PrincipalRetentionConfigName: {
+				Type:        framework.TypeDurationSecond,
+				Description: "Duration for which a principal is kept in the login ledger after its last login. Defaults to 30 days.",
+			},
+			MembershipRecheckIntervalConfigName: {
+				Type:        framework.TypeDurationSecond,
+				Description: "Interval at which the group membership of the principals with outstanding tokens is checked again. The tokens are renewable while it is set, and their renewal is refused once a recheck found the principal is no longer allowed to take the role. The recheck is disabled if not set.",
+			},
+			MembershipRecheckRateConfigName: {
+				Type:        framework.TypeInt,
+				Description: "Maximum number of principals whose group membership is rechecked per second.",
+				Default:     defaultMembershipRecheckRate,
+			},
+			SubjectLoginRateConfigName: {
+				Type:        framework.TypeFloat,
+				Description: "Number of logins per second that each principal can make to a role. The same limit applies to each keyId before the principal is authenticated. Logins are not limited per principal if not set.",
+			},
+			SubjectLoginBurstConfigName: {
+				Type:        framework.TypeInt,
+				Description: "Number of logins that each principal can make to a role in a burst. Defaults to 1.",
+			},
+			RoleLoginRateConfigName: {
+				Type:        framework.TypeFloat,
+				Description: "Number of logins per second that authenticated principals can make to each role. The same limit applies to each source address before the principal is authenticated. Logins are not limited per role if not set.",
+			},
+			RoleLoginBurstConfigName: {
+				Type:        framework.TypeInt,
+				Description: "Number of logins that can be made to each role in a burst. Defaults to 1.",
+			},
+			LockoutThresholdConfigName: {
+				Type:        framework.TypeInt,
+				Description: "Number of failed logins after which a keyId or a principal is locked out. Logins that are authenticated but not allowed to take the role only count against the principal. The counts and lockouts are kept in memory by each node: they are lost on restart, and are not shared with the other nodes of a cluster or with performance standbys. The lockout is disabled if not set.",
+			},
+			LockoutDurationConfigName: {
+				Type:        framework.TypeDurationSecond,
+				Description: "Duration for which a keyId or a principal is locked out by the node that counted its failed logins. Defaults to 15 minutes.",
+			},
+			LockoutCounterResetConfigName: {
+				Type:        framework.TypeDurationSecond,
+				Description: "Duration without a failed login after which the count of failed logins is reset. The count is kept in memory by each node. Defaults to 15 minutes.",
+			},
+			InstanceLookupConfigName: {
+				Type:        framework.TypeBool,
+				Description: "If set, the instance of an instance principal login is looked up with the Compute API, so that roles can be bound to its tags.",
+			},
+			ComputeEndpointConfigName: {
+				Type:        framework.TypeString,
+				Description: "The URL of the Compute API used to look up instances. Defaults to the endpoint of the region of the instance.",
+			},
+			UserLookupConfigName: {
+				Type:        framework.TypeBool,
+				Description: "If set, the user of a user principal login is looked up with OCI Identity, to name its token after the user and to bind roles to its email address. The entity alias of a user that is looked up is its OCID instead of the role name, so enabling it moves the logins of users to new entities.",
+			},
 		},
 
 		ExistenceCheck: b.pathConfigExistenceCheck,
@@ -88,14 +221,35 @@ func (b *backend) setOCIConfig(ctx context.Context, s logical.Storage, configEnt
 		return err
 	}
 
+	b.invalidateOCIConfig()
+
 	return nil
 }
 
-// getOCIConfig returns the properties set on the given config.
+// getOCIConfig returns the properties set on the given config, from the cache if possible.
+// The returned entry is shared and must not be modified; use readOCIConfig to get an entry to update.
+func (b *backend) getOCIConfig(ctx context.Context, s logical.Storage) (*OCIConfigEntry, error) {
+	configEntry, generation := b.cachedOCIConfig()
+	emitCacheMetrics(metricCacheConfig, configEntry != nil)
+	if configEntry != nil {
+		return configEntry, nil
+	}
+
+	configEntry, err := b.readOCIConfig(ctx, s)
+	if err != nil || configEntry == nil {
+		return configEntry, err
+	}
+
+	b.cacheOCIConfig(configEntry, generation)
+
+	return configEntry, nil
+}
+
+// readOCIConfig reads the properties set on the given config from the storage.
 // This method also does NOT check to see if a config upgrade is required. It is
 // the responsibility of the caller to check if a config upgrade is required and,
 // if so, to upgrade the config
-func (b *backend) getOCIConfig(ctx context.Context, s logical.Storage) (*OCIConfigEntry, error) {
+func (b *backend) readOCIConfig(ctx context.Context, s logical.Storage) (*OCIConfigEntry, error) {
 	entry, err := s.Get(ctx, "config")
 	if err != nil {
 		return nil, err
@@ -122,7 +276,29 @@ func (b *backend) pathConfigRead(ctx context.Context, req *logical.Request, data
 	}
 
 	responseData := map[string]interface{}{
-		HomeTenancyIdConfigName: configEntry.HomeTenancyId,
+		HomeTenancyIdConfigName:                configEntry.HomeTenancyId,
+		InstancePrincipalCABundleConfigName:    configEntry.InstancePrincipalCABundle,
+		InstancePrincipalTokenSignerConfigName: configEntry.InstancePrincipalTokenSigner,
+		InstancePrincipalHostsConfigName:       configEntry.InstancePrincipalHosts,
+		MembershipCacheTTLConfigName:           int64(configEntry.MembershipCacheTTL.Seconds()),
+		MembershipCacheNegativeTTLConfigName:   int64(configEntry.MembershipCacheNegativeTTL.Seconds()),
+		MembershipCacheSizeConfigName:          configEntry.MembershipCacheSize,
+		RolelessLoginConfigName:                configEntry.rolelessLogin(),
+		GroupAliasesConfigName:                 configEntry.groupAliases(),
+		OnlineOCIDValidationConfigName:         configEntry.OnlineOCIDValidation,
+		PrincipalRetentionConfigName:           int64(configEntry.principalRetention().Seconds()),
+		MembershipRecheckIntervalConfigName:    int64(configEntry.MembershipRecheckInterval.Seconds()),
+		MembershipRecheckRateConfigName:        configEntry.membershipRecheckRate(),
+		SubjectLoginRateConfigName:             configEntry.SubjectLoginRateLimit.Rate,
+		SubjectLoginBurstConfigName:            configEntry.SubjectLoginRateLimit.burst(),
+		RoleLoginRateConfigName:                configEntry.RoleLoginRateLimit.Rate,
+		RoleLoginBurstConfigName:               configEntry.RoleLoginRateLimit.burst(),
+		LockoutThresholdConfigName:             configEntry.LockoutThreshold,
+		LockoutDurationConfigName:              int64(configEntry.lockoutPolicy().duration.Seconds()),
+		LockoutCounterResetConfigName:          int64(configEntry.lockoutPolicy().counterReset.Seconds()),
+		InstanceLookupConfigName:               configEntry.InstanceLookup,
+		ComputeEndpointConfigName:              configEntry.ComputeEndpoint,
+		UserLookupConfigName:                   configEntry.UserLookup,
 	}
 
 	return &logical.Response{
@@ -133,12 +309,7 @@ func (b *backend) pathConfigRead(ctx context.Context, req *logical.Request, data
 // Create a Config
 func (b *backend) pathConfigCreateUpdate(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
 
-	homeTenancyId := data.Get(HomeTenancyIdConfigName).(string)
-	if strings.TrimSpace(homeTenancyId) == "" {
-		return logical.ErrorResponse("Missing homeTenancyId"), nil
-	}
-
-	configEntry, err := b.getOCIConfig(ctx, req.Storage)
+	configEntry, err := b.readOCIConfig(ctx, req.Storage)
 	if err != nil {
 		return nil, err
 	}
@@ -147,8 +318,138 @@ func (b *backend) pathConfigCreateUpdate(ctx context.Context, req *logical.Reque
 		return logical.ErrorResponse("The specified config does not exist"), nil
 	}
 
-	configEntry = &OCIConfigEntry{
-		HomeTenancyId: homeTenancyId,
+	if configEntry == nil {
+		configEntry = &OCIConfigEntry{}
+	}
+
+	if homeTenancyId, ok := data.GetOk(HomeTenancyIdConfigName); ok {
+		configEntry.HomeTenancyId = homeTenancyId.(string)
+	}
+	if strings.TrimSpace(configEntry.HomeTenancyId) == "" {
+		return logical.ErrorResponse("Missing homeTenancyId"), nil
+	}
+
+	if caBundle, ok := data.GetOk(InstancePrincipalCABundleConfigName); ok {
+		configEntry.InstancePrincipalCABundle = caBundle.(string)
+		if configEntry.InstancePrincipalCABundle != "" {
+			if _, err := parseCABundle(configEntry.InstancePrincipalCABundle); err != nil {
+				return logical.ErrorResponse(fmt.Sprintf("Invalid %s: %s", InstancePrincipalCABundleConfigName, err)), nil
+			}
+		}
+	}
+	if tokenSigner, ok := data.GetOk(InstancePrincipalTokenSignerConfigName); ok {
+		configEntry.InstancePrincipalTokenSigner = strings.TrimSpace(tokenSigner.(string))
+	}
+	if configEntry.InstancePrincipalCABundle != "" && configEntry.InstancePrincipalTokenSigner == "" {
+		return logical.ErrorResponse(fmt.Sprintf("%s is required with %s", InstancePrincipalTokenSignerConfigName, InstancePrincipalCABundleConfigName)), nil
+	}
+	if hosts, ok := data.GetOk(InstancePrincipalHostsConfigName); ok {
+		configEntry.InstancePrincipalHosts = strutil.RemoveDuplicates(hosts.([]string), true)
+	}
+	if configEntry.InstancePrincipalCABundle != "" && len(configEntry.InstancePrincipalHosts) == 0 {
+		return logical.ErrorResponse(fmt.Sprintf("%s is required with %s", InstancePrincipalHostsConfigName, InstancePrincipalCABundleConfigName)), nil
+	}
+
+	if ttl, ok := data.GetOk(MembershipCacheTTLConfigName); ok {
+		configEntry.MembershipCacheTTL = time.Duration(ttl.(int)) * time.Second
+	}
+
+	if negativeTTL, ok := data.GetOk(MembershipCacheNegativeTTLConfigName); ok {
+		configEntry.MembershipCacheNegativeTTL = time.Duration(negativeTTL.(int)) * time.Second
+	}
+
+	if size, ok := data.GetOk(MembershipCacheSizeConfigName); ok {
+		configEntry.MembershipCacheSize = size.(int)
+	} else if configEntry.MembershipCacheSize == 0 {
+		configEntry.MembershipCacheSize = defaultMembershipCacheSize
+	}
+	if configEntry.MembershipCacheTTL < 0 || configEntry.MembershipCacheNegativeTTL < 0 || configEntry.MembershipCacheSize <= 0 {
+		return logical.ErrorResponse("Invalid membership cache configuration"), nil
+	}
+
+	if rolelessLogin, ok := data.GetOk(RolelessLoginConfigName); ok {
+		configEntry.RolelessLogin = rolelessLogin.(string)
+		switch configEntry.RolelessLogin {
+		case RolelessLoginDisabled, RolelessLoginPriority, RolelessLoginUnion:
+		default:
+			return logical.ErrorResponse(fmt.Sprintf("Invalid %s %q", RolelessLoginConfigName, configEntry.RolelessLogin)), nil
+		}
+	}
+
+	if groupAliases, ok := data.GetOk(GroupAliasesConfigName); ok {
+		configEntry.GroupAliases = groupAliases.(string)
+		switch configEntry.GroupAliases {
+		case GroupAliasesDisabled, GroupAliasesOCID, GroupAliasesName:
+		default:
+			return logical.ErrorResponse(fmt.Sprintf("Invalid %s %q", GroupAliasesConfigName, configEntry.GroupAliases)), nil
+		}
+	}
+
+	if onlineOCIDValidation, ok := data.GetOk(OnlineOCIDValidationConfigName); ok {
+		configEntry.OnlineOCIDValidation = onlineOCIDValidation.(bool)
+	}
+
+	if retention, ok := data.GetOk(PrincipalRetentionConfigName); ok {
+		configEntry.PrincipalRetention = time.Duration(retention.(int)) * time.Second
+		if configEntry.PrincipalRetention < 0 {
+			return logical.ErrorResponse(fmt.Sprintf("Invalid %s", PrincipalRetentionConfigName)), nil
+		}
+	}
+
+	if interval, ok := data.GetOk(MembershipRecheckIntervalConfigName); ok {
+		configEntry.MembershipRecheckInterval = time.Duration(interval.(int)) * time.Second
+	}
+	if recheckRate, ok := data.GetOk(MembershipRecheckRateConfigName); ok {
+		configEntry.MembershipRecheckRate = recheckRate.(int)
+	}
+	if configEntry.MembershipRecheckInterval < 0 || configEntry.MembershipRecheckRate < 0 {
+		return logical.ErrorResponse("Invalid membership recheck configuration"), nil
+	}
+
+	if subjectRate, ok := data.GetOk(SubjectLoginRateConfigName); ok {
+		configEntry.SubjectLoginRateLimit.Rate = subjectRate.(float64)
+	}
+	if subjectBurst, ok := data.GetOk(SubjectLoginBurstConfigName); ok {
+		configEntry.SubjectLoginRateLimit.Burst = subjectBurst.(int)
+	}
+	if roleRate, ok := data.GetOk(RoleLoginRateConfigName); ok {
+		configEntry.RoleLoginRateLimit.Rate = roleRate.(float64)
+	}
+	if roleBurst, ok := data.GetOk(RoleLoginBurstConfigName); ok {
+		configEntry.RoleLoginRateLimit.Burst = roleBurst.(int)
+	}
+	if !configEntry.SubjectLoginRateLimit.valid() || !configEntry.RoleLoginRateLimit.valid() {
+		return logical.ErrorResponse("Invalid login rate limit"), nil
+	}
+
+	if threshold, ok := data.GetOk(LockoutThresholdConfigName); ok {
+		configEntry.LockoutThreshold = threshold.(int)
+	}
+	if duration, ok := data.GetOk(LockoutDurationConfigName); ok {
+		configEntry.LockoutDuration = time.Duration(duration.(int)) * time.Second
+	}
+	if counterReset, ok := data.GetOk(LockoutCounterResetConfigName); ok {
+		configEntry.LockoutCounterReset = time.Duration(counterReset.(int)) * time.Second
+	}
+	if configEntry.LockoutThreshold < 0 || configEntry.LockoutDuration < 0 || configEntry.LockoutCounterReset < 0 {
+		return logical.ErrorResponse("Invalid lockout configuration"), nil
+	}
+
+	if instanceLookup, ok := data.GetOk(InstanceLookupConfigName); ok {
+		configEntry.InstanceLookup = instanceLookup.(bool)
+	}
+	if userLookup, ok := data.GetOk(UserLookupConfigName); ok {
+		configEntry.UserLookup = userLookup.(bool)
+	}
+
+	if computeEndpoint, ok := data.GetOk(ComputeEndpointConfigName); ok {
+		configEntry.ComputeEndpoint = strings.TrimSuffix(strings.TrimSpace(computeEndpoint.(string)), "/")
+		if configEntry.ComputeEndpoint != "" {
+			endpointURL, err := url.Parse(configEntry.ComputeEndpoint)
+			if err != nil || (endpointURL.Scheme != "https" && endpointURL.Scheme != "http") || endpointURL.Host == "" {
+				return logical.ErrorResponse(fmt.Sprintf("Invalid %s %q", ComputeEndpointConfigName, configEntry.ComputeEndpoint)), nil
+			}
+		}
 	}
 
 	if err := b.setOCIConfig(ctx, req.Storage, configEntry); err != nil {
@@ -162,12 +463,137 @@ func (b *backend) pathConfigCreateUpdate(ctx context.Context, req *logical.Reque
 
 // Delete a Config
 func (b *backend) pathConfigDelete(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
-	return nil, req.Storage.Delete(ctx, "config")
+	if err := req.Storage.Delete(ctx, "config"); err != nil {
+		return nil, err
+	}
+
+	b.invalidateOCIConfig()
+
+	return nil, nil
 }
 
 // Struct to hold the information associated with an OCI config
 type OCIConfigEntry struct {
-	HomeTenancyId string `json:"home_tenancy_id" `
+	HomeTenancyId                string         `json:"home_tenancy_id" `
+	InstancePrincipalCABundle    string         `json:"instance_principal_ca_bundle"`
+	InstancePrincipalTokenSigner string         `json:"instance_principal_token_signer"`
+	InstancePrincipalHosts       []string       `json:"instance_principal_hosts"`
+	MembershipCacheTTL           time.Duration  `json:"membership_cache_ttl"`
+	MembershipCacheNegativeTTL   time.Duration  `json:"membership_cache_negative_ttl"`
+	MembershipCacheSize          int            `json:"membership_cache_size"`
+	RolelessLogin                string         `json:"roleless_login"`
+	GroupAliases                 string         `json:"group_aliases"`
+	OnlineOCIDValidation         bool           `json:"online_ocid_validation"`
+	PrincipalRetention           time.Duration  `json:"principal_retention"`
+	MembershipRecheckInterval    time.Duration  `json:"membership_recheck_interval"`
+	MembershipRecheckRate        int            `json:"membership_recheck_rate"`
+	SubjectLoginRateLimit        loginRateLimit `json:"subject_login_rate_limit"`
+	RoleLoginRateLimit           loginRateLimit `json:"role_login_rate_limit"`
+	LockoutThreshold             int            `json:"lockout_threshold"`
+	LockoutDuration              time.Duration  `json:"lockout_duration"`
+	LockoutCounterReset          time.Duration  `json:"lockout_counter_reset"`
+	InstanceLookup               bool           `json:"instance_lookup"`
+	ComputeEndpoint              string         `json:"compute_endpoint"`
+	UserLookup                   bool           `json:"user_lookup"`
+}
+
+// rolelessLogin returns the mode of role-less login
+func (configEntry *OCIConfigEntry) rolelessLogin() string {
+	if configEntry == nil || configEntry.RolelessLogin == "" {
+		return RolelessLoginDisabled
+	}
+	return configEntry.RolelessLogin
+}
+
+// instanceLookup returns true if the instances of instance principal logins are looked up with the Compute API
+func (configEntry *OCIConfigEntry) instanceLookup() bool {
+	return configEntry != nil && configEntry.InstanceLookup
+}
+
+// userLookup returns true if the users of user principal logins are looked up with OCI Identity
+func (configEntry *OCIConfigEntry) userLookup() bool {
+	return configEntry != nil && configEntry.UserLookup
+}
+
+// homeTenancyId returns the home tenancy, or an empty string if the config is not set
+func (configEntry *OCIConfigEntry) homeTenancyId() string {
+	if configEntry == nil {
+		return ""
+	}
+	return configEntry.HomeTenancyId
+}
+
+// groupAliases returns how the group aliases of a login are named
+func (configEntry *OCIConfigEntry) groupAliases() string {
+	if configEntry == nil || configEntry.GroupAliases == "" {
+		return GroupAliasesDisabled
+	}
+	return configEntry.GroupAliases
+}
+
+// principalRetention returns how long a principal is kept in the login ledger after its last login
+func (configEntry *OCIConfigEntry) principalRetention() time.Duration {
+	if configEntry == nil || configEntry.PrincipalRetention == 0 {
+		return defaultPrincipalRetention
+	}
+	return configEntry.PrincipalRetention
+}
+
+// membershipRecheckInterval returns the interval of the membership recheck, which is disabled if zero
+func (configEntry *OCIConfigEntry) membershipRecheckInterval() time.Duration {
+	if configEntry == nil {
+		return 0
+	}
+	return configEntry.MembershipRecheckInterval
+}
+
+// renewableTokens returns true if the tokens are renewable. They are only renewable while the membership is
+// rechecked, so that a renewal can be refused once a recheck found the principal is no longer allowed to take the role.
+func (configEntry *OCIConfigEntry) renewableTokens() bool {
+	return configEntry.membershipRecheckInterval() > 0
+}
+
+// membershipRecheckRate returns the number of principals rechecked per second
+func (configEntry *OCIConfigEntry) membershipRecheckRate() int {
+	if configEntry == nil || configEntry.MembershipRecheckRate == 0 {
+		return defaultMembershipRecheckRate
+	}
+	return configEntry.MembershipRecheckRate
+}
+
+// subjectLoginRateLimit returns the limit of the logins of each principal to a role
+func (configEntry *OCIConfigEntry) subjectLoginRateLimit() loginRateLimit {
+	if configEntry == nil {
+		return loginRateLimit{}
+	}
+	return configEntry.SubjectLoginRateLimit
+}
+
+// roleLoginRateLimit returns the limit of all the logins to a role
+func (configEntry *OCIConfigEntry) roleLoginRateLimit() loginRateLimit {
+	if configEntry == nil {
+		return loginRateLimit{}
+	}
+	return configEntry.RoleLoginRateLimit
+}
+
+// lockoutPolicy returns the lockout policy of failed logins, which is disabled if its threshold is zero
+func (configEntry *OCIConfigEntry) lockoutPolicy() lockoutPolicy {
+	policy := lockoutPolicy{
+		duration:     defaultLockoutDuration,
+		counterReset: defaultLockoutCounterReset,
+	}
+	if configEntry == nil {
+		return policy
+	}
+	policy.threshold = configEntry.LockoutThreshold
+	if configEntry.LockoutDuration > 0 {
+		policy.duration = configEntry.LockoutDuration
+	}
+	if configEntry.LockoutCounterReset > 0 {
+		policy.counterReset = configEntry.LockoutCounterReset
+	}
+	return policy
 }
 
 const pathConfigSyn = `
@@ -177,6 +603,77 @@ Manages the configuration for the Vault Auth Plugin.
 const pathConfigDesc = `
 The home_tenancy_id configuration is the Tenant OCID of your OCI Account. Only login requests from entities present in this tenant are accepted.
 
+The instance_principal_ca_bundle configuration is an optional PEM encoded bundle of the certificates that sign
+instance principal security tokens. When it is set, instance principal login requests are verified locally and
+OCI Identity is only called to check group membership. The requests must be signed for one of the
+instance_principal_hosts, so that a request signed for another service can not be replayed to log in.
+
+The membership_cache_ttl configuration enables caching of group membership decisions per principal and role OCIDs,
+so repeated logins of the same principal do not call OCI Identity. Decisions that found no membership are only cached
+if membership_cache_negative_ttl is set. The cache is flushed whenever the config or a role is written, and can be
+purged with the cache/purge endpoint.
+
+The roleless_login configuration enables login requests that do not specify a role. The principal is matched against
+every role, and the matched role with the highest priority is used. In "union" mode the token also gets the policies
+of every other matched role. The group membership of the principal is checked for the OCIDs of the roles in one batch
+of at most 2000 OCIDs; the roles that would take the batch past that are not matched, and are logged.
+
+The group_aliases configuration sets a group alias on the token for each matched Group or Dynamic Group, so that
+Vault external groups can be mapped to them. The aliases are named after the group OCIDs by default, or after the
+group names resolved through OCI Identity if set to "name". Finding every matched group may need more group
+membership requests for roles with many OCIDs; setting it to "disabled" turns group aliases off.
+
+The OCIDs of a role are checked when the role is written: they must be well formed, of the expected resource type,
+and in the realm of the home tenancy. If online_ocid_validation is set, the Groups and Dynamic Groups of the role
+must also exist in the home tenancy, which is checked with OCI Identity.
+
+Each principal that logs in is recorded in the login ledger, which can be read with the principals endpoints.
+The principal_retention configuration sets how long a principal is kept after its last login, 30 days by default;
+older principals are removed by the principals/tidy endpoint.
+
+The membership_recheck_interval configuration enables a background recheck of the principals whose last token may
+not have expired yet. Each is checked against the OCIDs of every role it logged in to, at most
+membership_recheck_rate principals per second. The tokens are renewable while the recheck is enabled. A role that
+the principal is no longer allowed to take is listed in the lost_roles of its principals entry, a warning is logged,
+its cached group membership decisions are removed, and the renewal of its tokens of that role is refused. Vault does
+not let the auth method revoke tokens, so the flagged tokens should be revoked by an operator if they must not last
+until they expire; the principal can not log in to the role again unless it is allowed to again.
+
+The subject_login_rate and role_login_rate configurations limit how often a principal can log in to a role, and how
+often any principal can log in to a role, in logins per second. The subject_login_burst and role_login_burst
+configurations set how many logins can be made at once before the rate applies. Both limits can be overridden per
+role. The role limit is checked before OCI Identity is called, and the principal limit as soon as the principal is
+authenticated, before its group membership is checked. A throttled login fails with a 429 status code and a
+Retry-After header; the mount must list Retry-After in its allowed_response_headers for Vault to return it.
+Throttles are counted in the oci.login.throttled metric and in the principals entry of the principal.
+
+The lockout_threshold configuration enables the lockout of failed logins. After lockout_threshold failed logins
+with the same keyId, or by the same authenticated principal, further logins with that keyId or by that principal
+are rejected with the locked_out error code for lockout_duration. The count of failed logins is reset after
+lockout_counter_reset without a failed login, and after a successful login. Only failures caused by the
+principal are counted: rejected signatures, unsupported principals and tenancy mismatches count against the keyId
+and the principal, while failed bindings, missing group membership and denials only count against the principal,
+which did authenticate. As the keyIds of API keys are not secret, a keyId can be locked out by anyone; the
+principals/unlock endpoint lifts a lockout. The failed logins are counted in the memory of
+each Vault node, and the lockouts of principals are recorded in their principals entry.
+
+The instance_lookup configuration enables the lookup of the instance of each instance principal login with the
+Compute API, using its opc-instance claim, so that roles can be bound to its tags with bound_defined_tags and
+bound_freeform_tags. The tags are set in the token metadata as tag.<namespace>.<key> and freeform_tag.<key>, and
+are cached for 5 minutes. Vault must be allowed to read the instances, for example with a policy such as
+"allow dynamic-group vault to read instances in tenancy". The compute_endpoint configuration overrides the endpoint
+of the Compute API, which is by default the endpoint of the region of the instance. If the lookup fails, the login
+fails when the role is bound to tags.
+
+The user_lookup configuration enables the lookup of the user of each user principal login with OCI Identity. The
+display name of the token is then the name of the user instead of the name of the role, so that audit logs show who
+logged in, and the entity alias is the OCID of the user, so that each user gets its own Vault entity. The name, the verified email address and,
+for federated users, the identity provider and external identifier of the user are set in the token metadata as
+user_name, user_email, user_identity_provider_id and user_external_identifier. The users are cached for 5 minutes,
+and roles can be bound to their email addresses with bound_user_emails. Vault must be allowed to read the users,
+for example with a policy such as "allow dynamic-group vault to inspect users in tenancy". If the lookup fails,
+the login fails when the role is bound to email addresses, and is otherwise named after the role.
+
 Example:
 
 vault write /auth/oci/config home_tenancy_id=myocid
@@ -6,25 +6,31 @@ import (
 	"fmt"
 	"net/http"
 	"strings"
+	"time"
 	"unicode"
 
-	log "github.com/hashicorp/go-hclog"
+	"github.com/hashicorp/go-secure-stdlib/strutil"
 	"github.com/hashicorp/vault/sdk/framework"
 	"github.com/hashicorp/vault/sdk/logical"
 	"github.com/oracle/oci-go-sdk/v65/common"
 	"github.com/pkg/errors"
+	"go.opentelemetry.io/otel/attribute"
 )
 
 // These constants store the required http path & method information for validating the signed request
 const (
 	PathVersionBase    = "/v1"
 	PathBaseFormat     = "/auth/%s/login/%s"
+	PathRolelessFormat = "/auth/%s/login"
 	PathLoginMethod    = "get"
 	PathSegmentAuth    = "auth"
 	PathSegmentLogin   = "login"
 	PathSegmentVersion = "v1"
 )
 
+// errAuthClientUnavailable is returned when the client used to call OCI Identity can not be created
+var errAuthClientUnavailable = newLoginError(LoginErrorIdentityUnavailable, errors.New("unable to create the OCI Identity client"))
+
 // Signing Header constants
 const (
 	// HdrRequestTarget represents the special header name used to refer to the HTTP verb and URI in the signature.
@@ -74,6 +80,14 @@ func pathLogin(b *backend) *framework.Path {
 			},
 		},
 		Operations: map[logical.Operation]framework.OperationHandler{
+			logical.UpdateOperation: &framework.PathOperation{
+				Callback: b.pathLoginUpdate,
+				DisplayAttrs: &framework.DisplayAttributes{
+					OperationPrefix: operationPrefixOCI,
+					OperationVerb:   "login",
+					OperationSuffix: "without-role",
+				},
+			},
 			logical.ResolveRoleOperation: &framework.PathOperation{
 				Callback: b.pathResolveRole,
 			},
@@ -103,123 +117,386 @@ func (b *backend) pathResolveRole(ctx context.Context, req *logical.Request, dat
 	return logical.ResolveRoleResponse(roleName)
 }
 
+// loginAttempt is what is known about a login as it progresses, for the metrics of its outcome
+type loginAttempt struct {
+	start time.Time
+
+	// roleName is only set once the role is found, since the name in the request path is not trusted until then
+	roleName      string
+	principal     *Principal
+	principalType string
+
+	// keyId is the keyId of the signature of the login request
+	keyId string
+
+	// roleNames are the roles whose policies are granted by a role-less login, if there are more than one
+	roleNames []string
+}
+
+// labelRoleName returns the role of the login for the metrics and the traces, which is unknown until the role is found
+func (attempt *loginAttempt) labelRoleName() string {
+	if attempt.roleName == "" {
+		return metricUnknown
+	}
+	return attempt.roleName
+}
+
+// grantedRoles returns the roles whose policies are granted by the login
+func (attempt *loginAttempt) grantedRoles() []string {
+	if len(attempt.roleNames) > 0 {
+		return attempt.roleNames
+	}
+	return []string{attempt.roleName}
+}
+
 func (b *backend) pathLoginUpdate(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	attempt := &loginAttempt{
+		start: time.Now(),
+	}
+	ctx, span := startSpan(ctx, req.ID, spanLogin)
 
-	// Validate the role
-	role, ok := data.GetOk("role")
-	if !ok {
-		return logical.ErrorResponse("Role is not specified"), nil
+	var auth *logical.Auth
+	var err error
+	if role, ok := data.GetOk("role"); ok {
+		auth, err = b.loginRole(ctx, req, data, role.(string), attempt)
+	} else {
+		auth, err = b.loginRoleless(ctx, req, data, attempt)
 	}
-	roleName := role.(string)
+	emitLoginMetrics(attempt, err)
+	b.recordLoginOutcome(ctx, req, attempt, err)
 
-	b.Logger().Trace(req.ID, "pathLoginUpdate roleName", roleName)
+	span.SetAttributes(
+		attribute.String(attributeRole, attempt.labelRoleName()),
+		attribute.String(attributePrincipalType, attempt.principalType),
+	)
+	if err != nil {
+		span.SetAttributes(attribute.String(attributeErrorCode, asLoginError(err).Code))
+	}
+	endSpan(span, err)
 
-	// Validate that the role exists
-	roleEntry, err := b.getOCIRole(ctx, req.Storage, roleName)
 	if err != nil {
-		return badRequestLogicalResponse(req, b.Logger(), err), nil
+		return loginErrorResponse(req, b.Logger(), err)
 	}
 
+	b.recordPrincipalLogin(ctx, req, attempt)
+	auth.InternalData["subject_id"] = principalSubjectId(attempt.principal)
+
+	return &logical.Response{
+		Auth: auth,
+	}, nil
+}
+
+// pathLoginRenew refuses to renew the tokens of a principal that a recheck found is no longer allowed to take one of
+// the roles of the token, or whose role was deleted. The tokens are only renewable while the membership is rechecked.
+func (b *backend) pathLoginRenew(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	roleName, _ := req.Auth.InternalData["role_name"].(string)
+	roleEntry, err := b.getOCIRole(ctx, req.Storage, roleName)
+	if err != nil {
+		return nil, err
+	}
 	if roleEntry == nil {
-		return badRequestLogicalResponse(req, b.Logger(), fmt.Errorf("Role is not found")), nil
+		return logical.ErrorResponse(fmt.Sprintf("Role %q no longer exists", roleName)), nil
 	}
 
-	// Parse the authentication headers
-	requestHeaders := data.Get("request_headers")
-	if !ok {
-		return logical.ErrorResponse("request_headers is not specified"), nil
+	// The tokens of a role-less login in union mode have the policies of every matched role
+	roleNames := []string{roleName}
+	if names, _ := req.Auth.InternalData["role_names"].(string); names != "" {
+		roleNames = strings.Split(names, ",")
+	}
+	if subjectId, _ := req.Auth.InternalData["subject_id"].(string); subjectId != "" {
+		entry, err := getPrincipal(ctx, req.Storage, subjectId)
+		if err != nil {
+			return nil, err
+		}
+		if entry != nil && entry.lostRole(roleNames) {
+			return logical.ErrorResponse("Entity is no longer allowed to take the Role"), nil
+		}
 	}
-	authenticateRequestHeaders := requestHeaders.(http.Header)
 
-	// Find the targetUrl and Method
-	method, targetUrl, err := requestTargetToMethodURL(authenticateRequestHeaders[HdrRequestTarget], roleName)
+	resp := &logical.Response{Auth: req.Auth}
+	resp.Auth.TTL = roleEntry.TokenTTL
+	resp.Auth.MaxTTL = roleEntry.TokenMaxTTL
+	resp.Auth.Period = roleEntry.TokenPeriod
+	return resp, nil
+}
+
+// loginRole logs in a principal to the role of the request
+func (b *backend) loginRole(ctx context.Context, req *logical.Request, data *framework.FieldData, roleName string, attempt *loginAttempt) (*logical.Auth, error) {
+	b.Logger().Trace(req.ID, "pathLoginUpdate roleName", roleName)
+
+	// Validate that the role exists
+	roleCtx, span := startSpan(ctx, req.ID, spanLoginRole)
+	roleEntry, err := b.getOCIRole(roleCtx, req.Storage, roleName)
+	if err == nil && roleEntry == nil {
+		err = newLoginError(LoginErrorInvalidRole, fmt.Errorf("Role is not found"))
+	}
+	endSpan(span, err)
 	if err != nil {
-		return badRequestLogicalResponse(req, b.Logger(), err), nil
+		return nil, err
+	}
+	attempt.roleName = roleName
+
+	// Parse the authentication headers, and find the targetUrl and Method
+	authenticateRequestHeaders, method, targetUrl, err := parseLoginRequest(ctx, req, data, roleName)
+	if err != nil {
+		return nil, err
 	}
 	b.Logger().Trace(req.ID, "Method:", method, "targetUrl:", targetUrl)
 
-	authenticateClientDetails := AuthenticateClientDetails{
-		RequestHeaders: authenticateRequestHeaders,
+	configEntry, err := b.getLoginConfig(ctx, req)
+	if err != nil {
+		return nil, err
 	}
 
-	requestMetadata := common.RequestMetadata{
-		nil,
+	// The logins of the keyId and of the source address are limited before OCI Identity is called
+	attempt.keyId = loginKeyId(authenticateRequestHeaders)
+	err = b.checkUnauthenticatedLoginRateLimits(ctx, req, roleName, attempt.keyId, roleEntry.subjectLoginRateLimit(configEntry), roleEntry.roleLoginRateLimit(configEntry))
+	if err != nil {
+		return nil, err
+	}
+	if err := b.checkLockout(configEntry, lockoutKindKey, attempt.keyId); err != nil {
+		return nil, err
+	}
+	// The role limit bounds the calls to OCI Identity for the role, so it is taken before the request is authenticated
+	err = b.checkLoginRateLimit(ctx, req, loginRateLimitRole, roleName, "", roleEntry.roleLoginRateLimit(configEntry))
+	if err != nil {
+		return nil, err
+	}
+
+	// Authenticate the request and validate the principal
+	principal, internalClaims, err := b.authenticateLoginRequest(ctx, req, configEntry, authenticateRequestHeaders, nil)
+	attempt.principal = principal
+	if err != nil {
+		return nil, err
+	}
+	attempt.principalType = internalClaims.GetString(ClaimPrincipalType)
+
+	if err := b.checkLockout(configEntry, lockoutKindSubject, principalSubjectId(principal)); err != nil {
+		return nil, err
+	}
+	err = b.checkLoginRateLimit(ctx, req, loginRateLimitSubject, roleName, principalSubjectId(principal), roleEntry.subjectLoginRateLimit(configEntry))
+	if err != nil {
+		return nil, err
 	}
 
-	authenticateClientRequest := AuthenticateClientRequest{
-		authenticateClientDetails,
-		nil,
-		&req.ID,
-		requestMetadata,
+	// The tags of the instance are only required by roles that are bound to them
+	if err := b.addInstanceTags(ctx, req.ID, configEntry, internalClaims); err != nil && roleEntry.hasTagBindings() {
+		return nil, identityError(err, LoginErrorIdentityUnavailable)
 	}
 
-	// Authenticate the request with Identity
-	if b.authenticationClient == nil && b.createAuthClient() != nil {
-		return logical.RespondWithStatusCode(nil, req, http.StatusInternalServerError)
+	// The attributes of the user are only required by roles that are bound to its email address
+	if err := b.addUserAttributes(ctx, req.ID, configEntry, principal, internalClaims); err != nil && len(roleEntry.BoundUserEmails) > 0 {
+		return nil, identityError(err, LoginErrorIdentityUnavailable)
+	}
+
+	// Validate that the principal is allowed to take the role
+	matchedGroupIds, err := b.authorizeRole(ctx, req, configEntry, roleEntry, principal, internalClaims, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	b.Logger().Trace("Login ok", "Method:", method, "targetUrl:", targetUrl, "id", req.ID)
+
+	tokenCtx, span := startSpan(ctx, req.ID, spanLoginToken)
+	auth := buildLoginAuth(roleName, roleEntry, matchedGroupIds)
+	auth.Renewable = configEntry.renewableTokens()
+	b.applyClaimMappings(tokenCtx, req, roleEntry, internalClaims, auth)
+	b.setGroupAliases(tokenCtx, req, configEntry, auth, matchedGroupIds)
+	endSpan(span, nil)
+
+	return auth, nil
+}
+
+// parseLoginRequest returns the signed headers of a login request, and the method and URL of its request target
+func parseLoginRequest(ctx context.Context, req *logical.Request, data *framework.FieldData, roleName string) (http.Header, string, string, error) {
+	_, span := startSpan(ctx, req.ID, spanLoginHeaders)
+
+	authenticateRequestHeaders, err := loginRequestHeaders(data)
+	if err != nil {
+		endSpan(span, err)
+		return nil, "", "", err
 	}
-	authenticateClientResponse, err := b.authenticationClient.AuthenticateClient(ctx, authenticateClientRequest)
+
+	method, targetUrl, err := requestTargetToMethodURL(authenticateRequestHeaders[HdrRequestTarget], roleName)
+	endSpan(span, err)
 	if err != nil {
-		return badRequestLogicalResponse(req, b.Logger(), err), nil
+		return nil, "", "", err
 	}
-	if authenticateClientResponse.Principal == nil ||
-		len(authenticateClientResponse.Principal.Claims) == 0 ||
-		*authenticateClientResponse.IsSuccess == false {
-		return badRequestLogicalResponse(req, b.Logger(), fmt.Errorf("OCI authentication failed")), nil
+
+	return authenticateRequestHeaders, method, targetUrl, nil
+}
+
+// getLoginConfig returns the config used by a login
+func (b *backend) getLoginConfig(ctx context.Context, req *logical.Request) (*OCIConfigEntry, error) {
+	ctx, span := startSpan(ctx, req.ID, spanLoginConfig)
+	configEntry, err := b.getOCIConfig(ctx, req.Storage)
+	endSpan(span, err)
+	return configEntry, err
+}
+
+// authenticateLoginRequest authenticates the signed request headers, locally if possible, otherwise with Identity.
+// It returns the Principal once its type and tenancy have been validated. The decisions are recorded in the trace.
+// A principal that is authenticated but not valid is returned along with the error, so that its failure can be counted.
+func (b *backend) authenticateLoginRequest(ctx context.Context, req *logical.Request, configEntry *OCIConfigEntry, authenticateRequestHeaders http.Header, trace *loginTrace) (*Principal, InternalClaims, error) {
+	var principal *Principal
+	var err error
+	verifiedLocally := false
+	if configEntry != nil && configEntry.InstancePrincipalCABundle != "" && configEntry.InstancePrincipalTokenSigner != "" {
+		principal, verifiedLocally, err = authenticateInstancePrincipalLocally(authenticateRequestHeaders, configEntry.InstancePrincipalCABundle, configEntry.InstancePrincipalTokenSigner, configEntry.InstancePrincipalHosts, time.Now())
+		if err != nil {
+			return nil, nil, newLoginError(LoginErrorSignatureRejected, err)
+		}
 	}
-	internalClaims := FromClaims(authenticateClientResponse.Principal.Claims)
+
+	if !verifiedLocally {
+		requestMetadata := common.RequestMetadata{
+			RetryPolicy: nil,
+		}
+
+		authenticateClientDetails := AuthenticateClientDetails{
+			RequestHeaders: authenticateRequestHeaders,
+		}
+
+		authenticateClientRequest := AuthenticateClientRequest{
+			authenticateClientDetails,
+			nil,
+			&req.ID,
+			requestMetadata,
+		}
+
+		// Authenticate the request with Identity
+		authenticationClient, err := b.getAuthClient()
+		if err != nil {
+			return nil, nil, err
+		}
+		spanCtx, span := startSpan(ctx, req.ID, spanAuthenticateClient)
+		start := time.Now()
+		authenticateClientResponse, err := authenticationClient.AuthenticateClient(spanCtx, authenticateClientRequest)
+		emitIdentityMetrics(metricAuthenticateClient, start, err)
+		opcRequestId := opcRequestId(authenticateClientResponse.OpcRequestId, authenticateClientResponse.RawResponse, err)
+		span.SetAttributes(attribute.String(attributeOpcRequestId, opcRequestId))
+		endSpan(span, err)
+		b.Logger().Debug("AuthenticateClient", "id", req.ID, "opc_request_id", opcRequestId, "duration", time.Since(start), "err", err)
+		if err != nil {
+			return nil, nil, identityError(err, LoginErrorSignatureRejected)
+		}
+		if authenticateClientResponse.Principal == nil ||
+			len(authenticateClientResponse.Principal.Claims) == 0 ||
+			*authenticateClientResponse.IsSuccess == false {
+			return nil, nil, newLoginError(LoginErrorSignatureRejected, fmt.Errorf("OCI authentication failed"))
+		}
+		principal = authenticateClientResponse.Principal
+	}
+
+	internalClaims := FromClaims(principal.Claims)
+	removeDerivedClaims(internalClaims)
 	principalType := internalClaims.GetString(ClaimPrincipalType)
+	trace.set("verified_locally", verifiedLocally)
+	trace.setPrincipal(principal, internalClaims)
 
 	// Check the principal type
 	if principalType != PrincipalTypeInstance && principalType != PrincipalTypeUser {
-		return badRequestLogicalResponse(req, b.Logger(), fmt.Errorf("Wrong principal type")), nil
+		return principal, nil, newLoginError(LoginErrorUnsupportedPrincipal, fmt.Errorf("Wrong principal type"))
 	}
 
-	b.Logger().Trace("Authentication ok", "Method:", method, "targetUrl:", targetUrl, "id", req.ID)
+	b.Logger().Trace("Authentication ok", "id", req.ID, "local", verifiedLocally)
 
 	// Validate the home tenancy
-	err = b.validateHomeTenancy(ctx, req, *authenticateClientResponse.Principal.TenantId)
+	err = validateHomeTenancy(configEntry, *principal.TenantId)
+	trace.set("tenancy_check", map[string]interface{}{
+		"home_tenancy_id": configEntry.homeTenancyId(),
+		"tenant_id":       *principal.TenantId,
+		"passed":          err == nil,
+	})
 	if err != nil {
-		return badRequestLogicalResponse(req, b.Logger(), err), nil
+		return principal, nil, err
 	}
 
-	// Find whether the entity corresponding the Principal is a part of any OCIDs allowed to take the role
-	filterGroupMembershipDetails := FilterGroupMembershipDetails{
-		*authenticateClientResponse.Principal,
-		roleEntry.OcidList,
-	}
+	return principal, internalClaims, nil
+}
+
+// derivedClaims are the claims that logins look up with OCI. They are never taken from the authenticated principal,
+// nor are the tags of instances and the attributes of users.
+var derivedClaims = []string{claimCompartmentAncestors}
 
-	filterGroupMembershipRequest := FilterGroupMembershipRequest{
-		filterGroupMembershipDetails,
-		nil,
-		&req.ID,
-		requestMetadata,
+// removeDerivedClaims removes the claims that logins look up with OCI from the claims of the authenticated principal,
+// so that a principal can not bind itself to a role by carrying them
+func removeDerivedClaims(claims InternalClaims) {
+	for key := range claims {
+		if strutil.StrListContains(derivedClaims, key) || isInstanceTagName(key) || strings.HasPrefix(key, userClaimPrefix) {
+			delete(claims, key)
+		}
 	}
+}
+
+// authorizeRole validates that the principal satisfies the bindings of the role and is a part of its OCIDs,
+// or is one of its bound subjects, and that it is not denied by the role.
+// It returns the OCIDs of the role, including those of group_policies, that the principal is a member of.
+// The decisions are recorded in the trace.
+func (b *backend) authorizeRole(ctx context.Context, req *logical.Request, configEntry *OCIConfigEntry, roleEntry *OCIRoleEntry, principal *Principal, internalClaims InternalClaims, trace *loginTrace) ([]string, error) {
 
-	filterGroupMembershipResponse, err := b.authenticationClient.FilterGroupMembership(ctx, filterGroupMembershipRequest)
+	// Validate that the principal satisfies the bindings of the Role
+	roleClaims, err := b.withCompartmentAncestors(ctx, req.ID, roleEntry, internalClaims, &compartmentAncestry{}, trace)
 	if err != nil {
-		return badRequestLogicalResponse(req, b.Logger(), err), nil
+		return nil, identityError(err, LoginErrorIdentityUnavailable)
 	}
-	if filterGroupMembershipResponse.GroupIds == nil {
-		return badRequestLogicalResponse(req, b.Logger(), fmt.Errorf("No membership OCIDs found")), nil
+	if err := validateRoleBindings(roleEntry, roleClaims, trace); err != nil {
+		return nil, err
 	}
 
-	// Validate that the filtered list contains atleast one of the OCIDs of the Role
-	filteredOcidMap := sliceToMap(filterGroupMembershipResponse.GroupIds)
-	found := false
-	for _, item := range roleEntry.OcidList {
-		_, present := filteredOcidMap[item]
-		if present {
-			found = true
-			break
-		}
+	// A denied subject can never take the role, and a bound subject needs no group membership
+	subjectId := principalSubjectId(principal)
+	if roleEntry.deniesSubject(subjectId) {
+		trace.set("subject_denied", true)
+		return nil, newLoginError(LoginErrorDenied, fmt.Errorf("Entity is denied by the Role"))
 	}
-	if found == false {
-		return badRequestLogicalResponse(req, b.Logger(), fmt.Errorf("Entity not a part of any of the Role OCIDs")), nil
+	boundSubject := roleEntry.bindsSubject(subjectId)
+	if boundSubject {
+		trace.set("subject_bound", true)
 	}
 
-	b.Logger().Trace("Login ok", "Method:", method, "targetUrl:", targetUrl, "id", req.ID)
+	requiresGroups := roleEntry.requiresGroups()
+	if !requiresGroups && !roleEntry.hasBindings() && !boundSubject {
+		return nil, newLoginError(LoginErrorNotInGroup, fmt.Errorf("Entity not a part of any of the Role OCIDs"))
+	}
 
-	// Return the response
+	ocids := roleEntry.membershipOcids()
+	if len(ocids) == 0 {
+		return nil, nil
+	}
+
+	if _, err := b.getAuthClient(); err != nil {
+		return nil, err
+	}
+
+	// Find whether the entity corresponding the Principal is a part of any OCIDs allowed to take the role.
+	// Every group of group_policies, denied_group_ids, group aliases and of roles that match more than one group
+	// must be checked, so the check can only stop at the first match without them.
+	stopOnMatch := roleEntry.matchesAnyGroup() && len(roleEntry.GroupPolicies) == 0 && len(roleEntry.DeniedGroupIds) == 0 &&
+		configEntry.groupAliases() == GroupAliasesDisabled
+	trace.set("groups_tested", ocids)
+	filteredOcids, err := b.cachedFilterGroupMembership(ctx, configEntry, req.ID, *principal, ocids, stopOnMatch)
+	if err != nil {
+		return nil, identityError(err, LoginErrorIdentityUnavailable)
+	}
+	trace.set("groups_matched", filteredOcids)
+
+	// A member of a denied group can never take the role, even if it is a bound subject
+	if deniedGroups := roleEntry.deniedGroups(filteredOcids); len(deniedGroups) > 0 {
+		trace.set("groups_denied", deniedGroups)
+		return nil, newLoginError(LoginErrorDenied, fmt.Errorf("Entity is a member of a group denied by the Role"))
+	}
+
+	// Validate that the filtered list matches the OCIDs and the group rule of the Role
+	if !boundSubject && requiresGroups && !roleEntry.matchesGroups(filteredOcids) {
+		return nil, newLoginError(LoginErrorNotInGroup, fmt.Errorf("Entity not a part of any of the Role OCIDs"))
+	}
+
+	return filteredOcids, nil
+}
+
+// buildLoginAuth returns the Auth of a successful login to the role by a member of the given groups
+func buildLoginAuth(roleName string, roleEntry *OCIRoleEntry, matchedGroupIds []string) *logical.Auth {
 	auth := &logical.Auth{
 		Metadata: map[string]string{
 			"role_name": roleName,
@@ -236,41 +513,80 @@ func (b *backend) pathLoginUpdate(ctx context.Context, req *logical.Request, dat
 	roleEntry.PopulateTokenAuth(auth)
 	auth.Renewable = false
 
-	resp := &logical.Response{
-		Auth: auth,
+	if len(matchedGroupIds) > 0 {
+		auth.Metadata["matched_group_ids"] = strings.Join(matchedGroupIds, ",")
 	}
 
-	return resp, nil
+	// The policies of auth are shared with the role entry, so they are copied before being extended
+	if groupPolicies := roleEntry.matchedGroupPolicies(matchedGroupIds); len(groupPolicies) > 0 {
+		policies := append(append([]string{}, auth.Policies...), groupPolicies...)
+		auth.Policies = strutil.RemoveDuplicates(policies, false)
+	}
+
+	return auth
 }
 
-func (b *backend) validateHomeTenancy(ctx context.Context, req *logical.Request, homeTenancyId string) error {
-
-	configEntry, err := b.getOCIConfig(ctx, req.Storage)
-	if err != nil {
-		return err
-	}
+func validateHomeTenancy(configEntry *OCIConfigEntry, homeTenancyId string) error {
 
 	if configEntry == nil || configEntry.HomeTenancyId == "" {
-		return fmt.Errorf("Home Tenancy is invalid")
+		return newLoginError(LoginErrorNotConfigured, fmt.Errorf("Home Tenancy is invalid"))
 	}
 
 	if homeTenancyId != configEntry.HomeTenancyId {
-		return fmt.Errorf("Invalid Tenancy")
+		return newLoginError(LoginErrorTenancyMismatch, fmt.Errorf("Invalid Tenancy"))
+	}
+
+	return nil
+}
+
+// validateRoleBindings checks the claims of the principal against the bindings and the matching rule of the role.
+// The result of each binding is recorded in the trace.
+func validateRoleBindings(roleEntry *OCIRoleEntry, claims InternalClaims, trace *loginTrace) error {
+	for _, binding := range roleEntry.bindings() {
+		value := claims.GetString(binding.claim)
+		err := binding.check(value)
+		for _, ancestor := range claims[binding.ancestorsClaim] {
+			if err != nil && binding.check(ancestor.Value) == nil {
+				err = nil
+			}
+		}
+		trace.addBinding(binding, value, err)
+		if err != nil {
+			return newLoginError(LoginErrorBindingFailed, err)
+		}
+	}
+
+	if roleEntry.MatchingRule != "" {
+		rule, err := parseMatchingRule(roleEntry.MatchingRule)
+		matched := err == nil && rule.matches(claims)
+		trace.set("matching_rule", map[string]interface{}{
+			"rule":   roleEntry.MatchingRule,
+			"passed": matched,
+		})
+		if !matched {
+			return newLoginError(LoginErrorBindingFailed, fmt.Errorf("Entity does not match the Role matching_rule"))
+		}
 	}
 
 	return nil
 }
 
-func badRequestLogicalResponse(req *logical.Request, logger log.Logger, err error) *logical.Response {
-	logger.Trace(req.ID, ": Failed with error:", err)
-	return logical.ErrorResponse(err.Error())
+// loginRequestHeaders returns the signed headers of a login request
+func loginRequestHeaders(data *framework.FieldData) (http.Header, error) {
+	requestHeaders, ok := data.GetOk("request_headers")
+	if !ok {
+		return nil, newLoginError(LoginErrorBadHeaders, fmt.Errorf("request_headers is not specified"))
+	}
+	return requestHeaders.(http.Header), nil
 }
 
+// requestTargetToMethodURL validates the (request-target) header of a login request to the role.
+// If the role name is empty, the request target must be a role-less login request.
 func requestTargetToMethodURL(requestTarget []string, roleName string) (method string, url string, err error) {
 	if len(requestTarget) == 0 {
-		return "", "", errors.New("no (request-target) specified in header")
+		return "", "", newLoginError(LoginErrorBadHeaders, errors.New("no (request-target) specified in header"))
 	}
-	errHeader := errors.New("incorrect (request-target) specified in header")
+	errHeader := newLoginError(LoginErrorBadHeaders, errors.New("incorrect (request-target) specified in header"))
 
 	// Ensure both the request method and URL path are present in the (request-target) header
 	parts := strings.FieldsFunc(requestTarget[0], unicode.IsSpace)
@@ -286,7 +602,12 @@ func requestTargetToMethodURL(requestTarget []string, roleName string) (method s
 	// Validate the URL path by inspecting its segments.
 	// The path mount segment of the URL is not validated.
 	segments := strings.Split(strings.TrimPrefix(parts[1], "/"), "/")
-	if len(segments) < 5 || segments[0] != PathSegmentVersion || segments[1] != PathSegmentAuth ||
+	if roleName == "" {
+		if len(segments) < 4 || segments[0] != PathSegmentVersion || segments[1] != PathSegmentAuth ||
+			segments[len(segments)-1] != PathSegmentLogin {
+			return "", "", errHeader
+		}
+	} else if len(segments) < 5 || segments[0] != PathSegmentVersion || segments[1] != PathSegmentAuth ||
 		segments[len(segments)-2] != PathSegmentLogin || segments[len(segments)-1] != roleName {
 		return "", "", errHeader
 	}
@@ -300,12 +621,23 @@ Authenticates to Vault using OCI credentials
 
 const pathLoginRoleDesc = `
 Authenticates to Vault using OCI credentials such as User Api Key, Instance Principal
+
+A failed login returns the status code of the reason it failed, and its error_code in the data of the
+response: bad_headers or
+invalid_role (400), signature_rejected (401), unsupported_principal, tenancy_mismatch, binding_failed,
+not_in_group or denied (403), throttled (429), identity_unavailable (503), not_configured or internal_error (500).
+A login that is throttled by the login rate limits, or rejected with locked_out (403) by the lockout
+policy, also returns the number of seconds to wait in retry_after and in the Retry-After header.
 `
 
 const pathLoginSyn = `
-Determines the role that would be used for login from a valid OCI login request
+Authenticates to Vault using OCI credentials, without specifying a role
 `
 
 const pathLoginDesc = `
-Determines the role that would be used for login from a valid OCI login request
+Authenticates to Vault using OCI credentials, without specifying a role. The principal is matched
+against every role, and the role to use is chosen as set by the roleless_login configuration.
+Role-less login is disabled unless roleless_login is set.
+
+Also determines the role that would be used for login from a valid OCI login request.
 `
@@ -0,0 +1,221 @@
+// Copyright © 2019, Oracle and/or its affiliates.
+package ociauth
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/hashicorp/go-secure-stdlib/strutil"
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+// maxRolelessLoginOCIDs is the maximum number of distinct OCIDs whose group membership is checked
+// for a login without a role. The roles whose OCIDs would go past it are not matched.
+const maxRolelessLoginOCIDs = 2 * MaxOCIDsPerRole
+
+// matchedRole is a role that a principal is allowed to take
+type matchedRole struct {
+	name     string
+	entry    *OCIRoleEntry
+	groupIds []string
+}
+
+// loginRoleless logs in a principal that did not specify a role, using the role(s) that the principal matches
+func (b *backend) loginRoleless(ctx context.Context, req *logical.Request, data *framework.FieldData, attempt *loginAttempt) (*logical.Auth, error) {
+
+	configEntry, err := b.getLoginConfig(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+
+	rolelessLogin := configEntry.rolelessLogin()
+	if rolelessLogin == RolelessLoginDisabled {
+		return nil, newLoginError(LoginErrorInvalidRole, fmt.Errorf("Role is not specified"))
+	}
+
+	// Parse the authentication headers, and find the targetUrl and Method
+	authenticateRequestHeaders, method, targetUrl, err := parseLoginRequest(ctx, req, data, "")
+	if err != nil {
+		return nil, err
+	}
+	b.Logger().Trace(req.ID, "Method:", method, "targetUrl:", targetUrl)
+
+	// The role is not known yet, so the limits of the config apply before OCI Identity is called
+	attempt.keyId = loginKeyId(authenticateRequestHeaders)
+	err = b.checkUnauthenticatedLoginRateLimits(ctx, req, "", attempt.keyId, configEntry.subjectLoginRateLimit(), configEntry.roleLoginRateLimit())
+	if err != nil {
+		return nil, err
+	}
+	if err := b.checkLockout(configEntry, lockoutKindKey, attempt.keyId); err != nil {
+		return nil, err
+	}
+
+	// Authenticate the request and validate the principal
+	principal, internalClaims, err := b.authenticateLoginRequest(ctx, req, configEntry, authenticateRequestHeaders, nil)
+	attempt.principal = principal
+	if err != nil {
+		return nil, err
+	}
+	attempt.principalType = internalClaims.GetString(ClaimPrincipalType)
+
+	if err := b.checkLockout(configEntry, lockoutKindSubject, principalSubjectId(principal)); err != nil {
+		return nil, err
+	}
+
+	// The role is not known yet, so the limit of the config applies to the logins of the principal
+	err = b.checkLoginRateLimit(ctx, req, loginRateLimitSubject, "", principalSubjectId(principal), configEntry.subjectLoginRateLimit())
+	if err != nil {
+		return nil, err
+	}
+
+	// If the tags of the instance or the attributes of the user can not be looked up, the roles that are bound to
+	// them are not matched
+	if err := b.addInstanceTags(ctx, req.ID, configEntry, internalClaims); err != nil {
+		b.Logger().Debug("Roles bound to the tags of the instance are not matched", "id", req.ID, "err", err)
+	}
+	if err := b.addUserAttributes(ctx, req.ID, configEntry, principal, internalClaims); err != nil {
+		b.Logger().Debug("Roles bound to the email address of the user are not matched", "id", req.ID, "err", err)
+	}
+
+	// Find the roles that the principal is allowed to take
+	matchedRoles, err := b.matchRoles(ctx, req, configEntry, principal, internalClaims)
+	if err != nil {
+		return nil, err
+	}
+	if len(matchedRoles) == 0 {
+		return nil, newLoginError(LoginErrorNotInGroup, fmt.Errorf("Entity not a part of any Role"))
+	}
+
+	// The matched role with the highest priority is used for the token
+	sort.SliceStable(matchedRoles, func(i, j int) bool {
+		if matchedRoles[i].entry.Priority != matchedRoles[j].entry.Priority {
+			return matchedRoles[i].entry.Priority > matchedRoles[j].entry.Priority
+		}
+		return matchedRoles[i].name < matchedRoles[j].name
+	})
+
+	attempt.roleName = matchedRoles[0].name
+	err = b.checkLoginRateLimit(ctx, req, loginRateLimitRole, matchedRoles[0].name, "", matchedRoles[0].entry.roleLoginRateLimit(configEntry))
+	if err != nil {
+		return nil, err
+	}
+
+	tokenCtx, span := startSpan(ctx, req.ID, spanLoginToken)
+	auth := buildLoginAuth(matchedRoles[0].name, matchedRoles[0].entry, matchedRoles[0].groupIds)
+	auth.Renewable = configEntry.renewableTokens()
+	b.applyClaimMappings(tokenCtx, req, matchedRoles[0].entry, internalClaims, auth)
+	groupIds := matchedRoles[0].groupIds
+
+	if rolelessLogin == RolelessLoginUnion {
+		policies := append([]string{}, auth.Policies...)
+		roleNames := make([]string, 0, len(matchedRoles))
+		groupIds = nil
+		for _, role := range matchedRoles {
+			policies = append(policies, role.entry.TokenPolicies...)
+			policies = append(policies, role.entry.matchedGroupPolicies(role.groupIds)...)
+			policies = append(policies, b.renderPolicyTemplates(tokenCtx, req, role.entry, internalClaims)...)
+			roleNames = append(roleNames, role.name)
+			groupIds = append(groupIds, role.groupIds...)
+		}
+		groupIds = strutil.RemoveDuplicatesStable(groupIds, false)
+		auth.Policies = strutil.RemoveDuplicates(policies, false)
+		auth.Metadata["role_names"] = strings.Join(roleNames, ",")
+		auth.InternalData["role_names"] = auth.Metadata["role_names"]
+		attempt.roleNames = roleNames
+		if len(groupIds) > 0 {
+			auth.Metadata["matched_group_ids"] = strings.Join(groupIds, ",")
+		}
+	}
+
+	// The group aliases are set for the groups of the roles whose policies are granted
+	b.setGroupAliases(tokenCtx, req, configEntry, auth, groupIds)
+	endSpan(span, nil)
+
+	b.Logger().Trace("Login ok", "Method:", method, "targetUrl:", targetUrl, "id", req.ID, "role", matchedRoles[0].name)
+
+	return auth, nil
+}
+
+// matchRoles returns every role that the principal is allowed to take.
+// The group membership of the principal is checked for all the roles at once.
+func (b *backend) matchRoles(ctx context.Context, req *logical.Request, configEntry *OCIConfigEntry, principal *Principal, internalClaims InternalClaims) ([]matchedRole, error) {
+	roleNames, err := req.Storage.List(ctx, "role/")
+	if err != nil {
+		return nil, err
+	}
+
+	// Find the roles whose bindings the principal satisfies, and that do not deny it
+	subjectId := principalSubjectId(principal)
+	var candidates []matchedRole
+	ocids := make(map[string]string)
+	ancestry := &compartmentAncestry{}
+	for _, roleName := range roleNames {
+		roleEntry, err := b.getOCIRole(ctx, req.Storage, roleName)
+		if err != nil {
+			return nil, err
+		}
+		if roleEntry == nil || roleEntry.deniesSubject(subjectId) {
+			continue
+		}
+		if !roleEntry.requiresGroups() && !roleEntry.hasBindings() && !roleEntry.bindsSubject(subjectId) {
+			continue
+		}
+		// The ancestors of the compartment are looked up once for every role, and are only added to a copy of the
+		// claims for the roles that cover subcompartments. If they can not be looked up, the compartment is only
+		// matched directly.
+		roleClaims, err := b.withCompartmentAncestors(ctx, req.ID, roleEntry, internalClaims, ancestry, nil)
+		if err != nil {
+			b.Logger().Debug("Role is only matched on the compartment of the principal", "id", req.ID, "role", roleName, "err", err)
+			roleClaims = internalClaims
+		}
+		if err := validateRoleBindings(roleEntry, roleClaims, nil); err != nil {
+			continue
+		}
+
+		// The OCIDs of every candidate are checked in one batch, so a role that would take the batch past the
+		// limit is skipped rather than letting the number of Identity requests grow with the number of roles
+		if added := countMissing(roleEntry.membershipOcids(), ocids); len(ocids)+added > maxRolelessLoginOCIDs {
+			b.Logger().Warn("Role is not matched, the roles have too many OCIDs for a login without a role", "id", req.ID, "role", roleName, "limit", maxRolelessLoginOCIDs)
+			continue
+		}
+		candidates = append(candidates, matchedRole{name: roleName, entry: roleEntry})
+		addSliceToMap(roleEntry.membershipOcids(), ocids)
+	}
+
+	// Check the group membership for the OCIDs of every candidate role in one batch
+	var filteredOcids []string
+	if len(ocids) > 0 {
+		if _, err := b.getAuthClient(); err != nil {
+			return nil, err
+		}
+
+		ocidList := mapToSlice(ocids)
+		sort.Strings(ocidList)
+		filteredOcids, err = b.cachedFilterGroupMembership(ctx, configEntry, req.ID, *principal, ocidList, false)
+		if err != nil {
+			return nil, identityError(err, LoginErrorIdentityUnavailable)
+		}
+	}
+	filteredOcidMap := sliceToMap(filteredOcids)
+
+	var matchedRoles []matchedRole
+	for _, candidate := range candidates {
+		// A member of a denied group can never take the role
+		if len(candidate.entry.deniedGroups(filteredOcids)) > 0 {
+			continue
+		}
+		for _, ocid := range candidate.entry.groupOcids() {
+			if _, present := filteredOcidMap[ocid]; present {
+				candidate.groupIds = append(candidate.groupIds, ocid)
+			}
+		}
+		if !candidate.entry.requiresGroups() || candidate.entry.bindsSubject(subjectId) || candidate.entry.matchesGroups(candidate.groupIds) {
+			matchedRoles = append(matchedRoles, candidate)
+		}
+	}
+
+	return matchedRoles, nil
+}
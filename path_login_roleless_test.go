@@ -0,0 +1,211 @@
+// Copyright © 2019, Oracle and/or its affiliates.
+package ociauth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+func TestRequestTargetToMethodURL(t *testing.T) {
+	testCases := []struct {
+		requestTarget string
+		roleName      string
+		expectErr     bool
+	}{
+		{"get /v1/auth/oci/login/devrole", "devrole", false},
+		{"get /v1/auth/my/oci/login/devrole", "devrole", false},
+		{"get /v1/auth/oci/login/devrole", "opsrole", true},
+		{"post /v1/auth/oci/login/devrole", "devrole", true},
+		{"get /v1/auth/oci/login", "devrole", true},
+		{"get /v1/auth/oci/login", "", false},
+		{"get /v1/auth/my/oci/login", "", false},
+		{"get /v1/auth/oci/login/devrole", "", true},
+		{"get /v1/auth/login", "", true},
+		{"get", "", true},
+	}
+
+	for _, tc := range testCases {
+		_, _, err := requestTargetToMethodURL([]string{tc.requestTarget}, tc.roleName)
+		if (err != nil) != tc.expectErr {
+			t.Fatalf("unexpected result for %q and role %q: %v", tc.requestTarget, tc.roleName, err)
+		}
+	}
+}
+
+func TestBackend_RolelessLogin(t *testing.T) {
+	memberGroup := "ocid1.dynamicgroup.oc1..member"
+	var requests int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+
+		var details FilterGroupMembershipDetails
+		if err := json.NewDecoder(r.Body).Decode(&details); err != nil {
+			t.Errorf("bad request body: %v", err)
+		}
+		result := FilterGroupMembershipResult{Principal: details.Principal, GroupIds: []string{}}
+		for _, ocid := range details.GroupIds {
+			if ocid == memberGroup {
+				result.GroupIds = append(result.GroupIds, ocid)
+			}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(result)
+	}))
+	defer server.Close()
+
+	config := logical.TestBackendConfig()
+	config.StorageView = &logical.InmemStorage{}
+	b, err := Backend()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Setup(context.Background(), config); err != nil {
+		t.Fatal(err)
+	}
+	b.authenticationClient = newTestAuthenticationClient(t, server.URL)
+
+	ca := newTestCA(t)
+	writeConfig := func(rolelessLogin string) {
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.CreateOperation,
+			Path:      "config",
+			Storage:   config.StorageView,
+			Data: map[string]interface{}{
+				HomeTenancyIdConfigName:                testTenancyId,
+				InstancePrincipalCABundleConfigName:    ca.bundle(),
+				InstancePrincipalTokenSignerConfigName: testTokenSigner,
+				InstancePrincipalHostsConfigName:       testHost,
+				RolelessLoginConfigName:                rolelessLogin,
+			},
+		})
+		if err != nil || (resp != nil && resp.IsError()) {
+			t.Fatalf("Config write failed. resp:%#v\n err:%v", resp, err)
+		}
+	}
+
+	roles := map[string]map[string]interface{}{
+		"low": {
+			"bound_compartment_ids": testCompartmentId,
+			"priority":              1,
+			"token_policies":        "policy1",
+		},
+		"high": {
+			"bound_compartment_ids": testCompartmentId,
+			"priority":              10,
+			"token_policies":        "policy2",
+		},
+		"groups": {
+			"ocid_list":      memberGroup + ",ocid1.dynamicgroup.oc1..other",
+			"priority":       5,
+			"token_policies": "policy3",
+		},
+		"othergroups": {
+			"ocid_list":      "ocid1.dynamicgroup.oc1..other",
+			"priority":       100,
+			"token_policies": "policy4",
+		},
+		"othercompartment": {
+			"bound_compartment_ids": "ocid1.compartment.oc1..othercompartment",
+			"priority":              100,
+			"token_policies":        "policy5",
+		},
+	}
+	for roleName, roleData := range roles {
+		if err := createRole(roleData, roleName, b, config); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	sessionKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	token := ca.securityToken(t, sessionKey, nil)
+
+	login := func() *logical.Response {
+		headers := signedInstanceHeaders(t, token, sessionKey, "get "+PathVersionBase+fmt.Sprintf(PathRolelessFormat, "oci"))
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      "login",
+			Storage:   config.StorageView,
+			Data: map[string]interface{}{
+				"request_headers": map[string][]string(headers),
+			},
+		})
+		if err != nil {
+			t.Fatalf("login failed: %v", err)
+		}
+		return resp
+	}
+
+	// Role-less login is disabled by default
+	writeConfig(RolelessLoginDisabled)
+	if statusCode, code := loginErrorCode(t, login()); statusCode != http.StatusBadRequest || code != LoginErrorInvalidRole {
+		t.Fatalf("expected role-less login to be disabled: %d %s", statusCode, code)
+	}
+
+	writeConfig(RolelessLoginPriority)
+	resp := login()
+	if resp == nil || resp.IsError() || resp.Auth == nil {
+		t.Fatalf("expected login to succeed: %#v", resp)
+	}
+	if resp.Auth.Metadata["role_name"] != "high" || !reflect.DeepEqual(resp.Auth.Policies, []string{"policy2"}) {
+		t.Fatalf("expected the role with the highest priority: %#v", resp.Auth)
+	}
+	if requests != 1 {
+		t.Fatalf("expected a single batched Identity request, got %d", requests)
+	}
+
+	writeConfig(RolelessLoginUnion)
+	resp = login()
+	if resp == nil || resp.IsError() || resp.Auth == nil {
+		t.Fatalf("expected login to succeed: %#v", resp)
+	}
+	policies := append([]string{}, resp.Auth.Policies...)
+	sort.Strings(policies)
+	if !reflect.DeepEqual(policies, []string{"policy1", "policy2", "policy3"}) {
+		t.Fatalf("expected the union of the matched roles' policies: %#v", resp.Auth.Policies)
+	}
+	if resp.Auth.Metadata["role_name"] != "high" || resp.Auth.Metadata["role_names"] != "high,groups,low" {
+		t.Fatalf("unexpected metadata: %#v", resp.Auth.Metadata)
+	}
+
+	// The roles whose OCIDs would take the batch past the limit are not matched
+	bulkOcids := func(prefix string) string {
+		ocids := []string{memberGroup}
+		for i := 1; i < MaxOCIDsPerRole; i++ {
+			ocids = append(ocids, fmt.Sprintf("ocid1.dynamicgroup.oc1..%s%04d", prefix, i))
+		}
+		return strings.Join(ocids, ",")
+	}
+	for roleName, policy := range map[string]string{"bulka": "policy6", "bulkb": "policy6", "bulkc": "policy7"} {
+		roleData := map[string]interface{}{
+			"ocid_list":      bulkOcids(roleName),
+			"token_policies": policy,
+		}
+		if err := createRole(roleData, roleName, b, config); err != nil {
+			t.Fatal(err)
+		}
+	}
+	resp = login()
+	if resp == nil || resp.IsError() || resp.Auth == nil {
+		t.Fatalf("expected login to succeed: %#v", resp)
+	}
+	policies = append([]string{}, resp.Auth.Policies...)
+	sort.Strings(policies)
+	if !reflect.DeepEqual(policies, []string{"policy1", "policy2", "policy3", "policy6"}) {
+		t.Fatalf("expected the role past the limit not to be matched: %#v", resp.Auth.Policies)
+	}
+}
@@ -3,6 +3,14 @@ package ociauth
 
 import (
 	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sort"
 	"strings"
 	"testing"
 
@@ -21,7 +29,7 @@ func TestResolveRole(t *testing.T) {
 
 	roleData := map[string]interface{}{
 		"description":    "My dev role",
-		"ocid_list":      "ocid1,ocid2",
+		"ocid_list":      "ocid1.group.oc1..group1,ocid1.group.oc1..group2",
 		"token_policies": "policy1,policy2",
 		"token_ttl":      1500,
 	}
@@ -98,3 +106,326 @@ func TestResolveRole_RoleDoesNotExist(t *testing.T) {
 		t.Fatalf("Error was not due to invalid role name. Error: %s", errString)
 	}
 }
+
+func TestBackend_GroupPolicies(t *testing.T) {
+	roleGroup := "ocid1.dynamicgroup.oc1..role"
+	adminGroup := "ocid1.dynamicgroup.oc1..admins"
+	readerGroup := "ocid1.dynamicgroup.oc1..readers"
+	members := map[string]bool{roleGroup: true, adminGroup: true}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var details FilterGroupMembershipDetails
+		if err := json.NewDecoder(r.Body).Decode(&details); err != nil {
+			t.Errorf("bad request body: %v", err)
+		}
+		result := FilterGroupMembershipResult{Principal: details.Principal, GroupIds: []string{}}
+		for _, ocid := range details.GroupIds {
+			if members[ocid] {
+				result.GroupIds = append(result.GroupIds, ocid)
+			}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(result)
+	}))
+	defer server.Close()
+
+	config := logical.TestBackendConfig()
+	config.StorageView = &logical.InmemStorage{}
+	b, err := Backend()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Setup(context.Background(), config); err != nil {
+		t.Fatal(err)
+	}
+	b.authenticationClient = newTestAuthenticationClient(t, server.URL)
+
+	ca := newTestCA(t)
+	resp, err := b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.CreateOperation,
+		Path:      "config",
+		Storage:   config.StorageView,
+		Data: map[string]interface{}{
+			HomeTenancyIdConfigName:                testTenancyId,
+			InstancePrincipalCABundleConfigName:    ca.bundle(),
+			InstancePrincipalTokenSignerConfigName: testTokenSigner,
+			InstancePrincipalHostsConfigName:       testHost,
+		},
+	})
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("Config creation failed. resp:%#v\n err:%v", resp, err)
+	}
+
+	groupPolicies := map[string]interface{}{
+		adminGroup:  "admin,reader",
+		readerGroup: []interface{}{"reader"},
+	}
+	roles := map[string]map[string]interface{}{
+		"approle": {
+			"ocid_list":      roleGroup,
+			"group_policies": groupPolicies,
+			"token_policies": "default",
+		},
+		// Membership of a group that is only in group_policies does not grant the role
+		"policyonlyrole": {
+			"ocid_list":      "ocid1.dynamicgroup.oc1..other",
+			"group_policies": groupPolicies,
+			"token_policies": "default",
+		},
+	}
+	for roleName, roleData := range roles {
+		if err := createRole(roleData, roleName, b, config); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	resp, err = b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      "role/approle",
+		Storage:   config.StorageView,
+	})
+	if err != nil || resp == nil || resp.IsError() {
+		t.Fatalf("Role read failed. resp:%#v\n err:%v", resp, err)
+	}
+	expectedGroupPolicies := map[string][]string{adminGroup: {"admin", "reader"}, readerGroup: {"reader"}}
+	if !reflect.DeepEqual(resp.Data["group_policies"], expectedGroupPolicies) {
+		t.Fatalf("unexpected group_policies: %#v", resp.Data["group_policies"])
+	}
+
+	sessionKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	token := ca.securityToken(t, sessionKey, nil)
+
+	login := func(roleName string) *logical.Response {
+		headers := signedInstanceHeaders(t, token, sessionKey, "get "+PathVersionBase+fmt.Sprintf(PathBaseFormat, "oci", roleName))
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      "login/" + roleName,
+			Storage:   config.StorageView,
+			Data: map[string]interface{}{
+				"request_headers": map[string][]string(headers),
+			},
+		})
+		if err != nil {
+			t.Fatalf("login failed: %v", err)
+		}
+		return resp
+	}
+
+	resp = login("approle")
+	if resp == nil || resp.IsError() || resp.Auth == nil {
+		t.Fatalf("expected login to succeed: %#v", resp)
+	}
+	policies := append([]string{}, resp.Auth.Policies...)
+	sort.Strings(policies)
+	if !reflect.DeepEqual(policies, []string{"admin", "default", "reader"}) {
+		t.Fatalf("unexpected policies: %#v", resp.Auth.Policies)
+	}
+	if resp.Auth.Metadata["matched_group_ids"] != roleGroup+","+adminGroup {
+		t.Fatalf("unexpected matched groups: %#v", resp.Auth.Metadata)
+	}
+
+	// The policies of the role entry are not modified by the login
+	roleEntry, err := b.getOCIRole(context.Background(), config.StorageView, "approle")
+	if err != nil || !reflect.DeepEqual(roleEntry.TokenPolicies, []string{"default"}) {
+		t.Fatalf("unexpected role entry: %#v err:%v", roleEntry, err)
+	}
+
+	if statusCode, code := loginErrorCode(t, login("policyonlyrole")); statusCode != http.StatusForbidden || code != LoginErrorNotInGroup {
+		t.Fatalf("expected login without a match in ocid_list to fail: %d %s", statusCode, code)
+	}
+
+	if err := createRole(map[string]interface{}{"group_policies": map[string]interface{}{adminGroup: ""}}, "badrole", b, config); err == nil {
+		t.Fatal("expected group_policies without policies to be rejected")
+	}
+}
+
+func TestBackend_SubjectLists(t *testing.T) {
+	roleGroup := "ocid1.group.oc1..rolegroup"
+	deniedGroup := "ocid1.group.oc1..deniedgroup"
+	fake := newFakeIdentity(t)
+	b, config := initOfflineTest(t, fake, roleGroup)
+
+	updateRole := func(data map[string]interface{}) *logical.Response {
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      "role/" + DevRole,
+			Storage:   config.StorageView,
+			Data:      data,
+		})
+		if err != nil || (resp != nil && resp.IsError()) {
+			t.Fatalf("Role update failed. resp:%#v\n err:%v", resp, err)
+		}
+		return resp
+	}
+
+	headers, keyId := signedAPIKeyHeaders(t, PathVersionBase+fmt.Sprintf(PathBaseFormat, "oci", DevRole))
+	login := func() *logical.Response {
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      "login/" + DevRole,
+			Storage:   config.StorageView,
+			Data: map[string]interface{}{
+				"request_headers": headers,
+			},
+		})
+		if err != nil {
+			t.Fatalf("login failed: %v", err)
+		}
+		return resp
+	}
+	expectLogin := func(expectedCode string) {
+		t.Helper()
+		b.membershipCache.purge()
+		resp := login()
+		if expectedCode == "" {
+			if resp == nil || resp.Auth == nil {
+				t.Fatalf("expected login to succeed: %#v", resp)
+			}
+			return
+		}
+		if statusCode, code := loginErrorCode(t, resp); statusCode != http.StatusForbidden || code != expectedCode {
+			t.Fatalf("expected %s, got %d %s", expectedCode, statusCode, code)
+		}
+	}
+
+	subjectId := "ocid1.user.oc1..testsubject"
+	fake.addPrincipal(keyId, newFakePrincipal(subjectId, PrincipalTypeUser, nil))
+	expectLogin(LoginErrorNotInGroup)
+
+	// A bound subject needs no group membership
+	updateRole(map[string]interface{}{"bound_subject_ids": subjectId})
+	expectLogin("")
+
+	// A denied group overrides the bound subject
+	updateRole(map[string]interface{}{"denied_group_ids": deniedGroup})
+	fake.setMembership(subjectId, deniedGroup)
+	expectLogin(LoginErrorDenied)
+
+	// A denied group overrides the group membership
+	updateRole(map[string]interface{}{"bound_subject_ids": ""})
+	fake.setMembership(subjectId, roleGroup, deniedGroup)
+	expectLogin(LoginErrorDenied)
+	fake.setMembership(subjectId, roleGroup)
+	expectLogin("")
+
+	// A denied subject is refused before its group membership is checked
+	updateRole(map[string]interface{}{"denied_subject_ids": subjectId})
+	requests := fake.requestCount(fakeIdentityFilterGroupMembership)
+	expectLogin(LoginErrorDenied)
+	if fake.requestCount(fakeIdentityFilterGroupMembership) != requests {
+		t.Fatal("expected no group membership check for a denied subject")
+	}
+
+	resp, err := b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      "role/" + DevRole,
+		Storage:   config.StorageView,
+	})
+	if err != nil || resp == nil || resp.IsError() {
+		t.Fatalf("Role read failed. resp:%#v\n err:%v", resp, err)
+	}
+	if !reflect.DeepEqual(resp.Data["denied_subject_ids"], []string{subjectId}) ||
+		!reflect.DeepEqual(resp.Data["denied_group_ids"], []string{deniedGroup}) ||
+		!reflect.DeepEqual(resp.Data["bound_subject_ids"], []string{}) {
+		t.Fatalf("unexpected role: %#v", resp.Data)
+	}
+}
+
+func TestBackend_GroupMatch(t *testing.T) {
+	prodGroup := "ocid1.dynamicgroup.oc1..prodhosts"
+	pciGroup := "ocid1.dynamicgroup.oc1..pcihosts"
+	auditGroup := "ocid1.group.oc1..auditors"
+	fake := newFakeIdentity(t)
+	b, config := initOfflineTest(t, fake, prodGroup+","+pciGroup)
+
+	updateRole := func(data map[string]interface{}) *logical.Response {
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      "role/" + DevRole,
+			Storage:   config.StorageView,
+			Data:      data,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp
+	}
+
+	headers, keyId := signedAPIKeyHeaders(t, PathVersionBase+fmt.Sprintf(PathBaseFormat, "oci", DevRole))
+	subjectId := "ocid1.instance.oc1.phx.testsubject"
+	fake.addPrincipal(keyId, newFakePrincipal(subjectId, PrincipalTypeInstance, nil))
+	expectLogin := func(success bool, groupIds ...string) {
+		t.Helper()
+		b.membershipCache.purge()
+		fake.setMembership(subjectId, groupIds...)
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      "login/" + DevRole,
+			Storage:   config.StorageView,
+			Data: map[string]interface{}{
+				"request_headers": headers,
+			},
+		})
+		if err != nil {
+			t.Fatalf("login failed: %v", err)
+		}
+		if success {
+			if resp == nil || resp.Auth == nil {
+				t.Fatalf("expected login with %v to succeed: %#v", groupIds, resp)
+			}
+			return
+		}
+		if statusCode, code := loginErrorCode(t, resp); statusCode != http.StatusForbidden || code != LoginErrorNotInGroup {
+			t.Fatalf("expected login with %v to fail: %d %s", groupIds, statusCode, code)
+		}
+	}
+
+	expectLogin(true, prodGroup)
+
+	// Every group of the role is required
+	if resp := updateRole(map[string]interface{}{"group_match": "all"}); resp != nil {
+		t.Fatalf("unexpected response: %#v", resp)
+	}
+	expectLogin(false, prodGroup)
+	expectLogin(true, prodGroup, pciGroup)
+
+	if resp := updateRole(map[string]interface{}{"group_match": "some"}); resp == nil || !resp.IsError() {
+		t.Fatalf("expected an invalid group_match to be rejected: %#v", resp)
+	}
+
+	// The group rule replaces the role OCIDs when they are removed
+	rule := map[string]interface{}{
+		"all": []interface{}{prodGroup, map[string]interface{}{"any": []interface{}{pciGroup, auditGroup}}},
+	}
+	if resp := updateRole(map[string]interface{}{"ocid_list": "", "group_rule": rule}); resp != nil {
+		t.Fatalf("unexpected response: %#v", resp)
+	}
+	expectLogin(false, prodGroup)
+	expectLogin(false, pciGroup, auditGroup)
+	expectLogin(true, prodGroup, auditGroup)
+
+	resp, err := b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      "role/" + DevRole,
+		Storage:   config.StorageView,
+	})
+	if err != nil || resp == nil || resp.IsError() {
+		t.Fatalf("Role read failed. resp:%#v\n err:%v", resp, err)
+	}
+	if resp.Data["group_match"] != groupMatchAll || !reflect.DeepEqual(resp.Data["group_rule"], rule) {
+		t.Fatalf("unexpected role: %#v", resp.Data)
+	}
+
+	// The rule OCIDs are validated
+	if resp := updateRole(map[string]interface{}{"group_rule": map[string]interface{}{"any": []interface{}{"ocid1.user.oc1..user1"}}}); resp == nil || !resp.IsError() {
+		t.Fatalf("expected an invalid rule to be rejected: %#v", resp)
+	}
+
+	// A role without its rule can no longer be taken
+	if resp := updateRole(map[string]interface{}{"group_rule": map[string]interface{}{}}); resp == nil || !resp.IsError() {
+		t.Fatalf("expected a role without a rule to be rejected: %#v", resp)
+	}
+}
@@ -0,0 +1,129 @@
+// Copyright © 2019, Oracle and/or its affiliates.
+package ociauth
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+func pathLoginVerify(b *backend) *framework.Path {
+	return &framework.Path{
+		Pattern: "login/" + framework.GenericNameRegex("role") + "/verify",
+
+		DisplayAttrs: &framework.DisplayAttributes{
+			OperationPrefix: operationPrefixOCI,
+			OperationVerb:   "verify",
+			OperationSuffix: "login",
+		},
+
+		Fields: map[string]*framework.FieldSchema{
+			"request_headers": {
+				Type:        framework.TypeHeader,
+				Description: `The signed headers of the client`,
+			},
+			"role": {
+				Type:        framework.TypeLowerCaseString,
+				Description: "Name of the role.",
+			},
+		},
+		Callbacks: map[logical.Operation]framework.OperationFunc{
+			logical.UpdateOperation: b.pathLoginVerifyUpdate,
+		},
+
+		HelpSynopsis:    pathLoginVerifySyn,
+		HelpDescription: pathLoginVerifyDesc,
+	}
+}
+
+// pathLoginVerifyUpdate makes the same decisions as a login to the role, without issuing a token,
+// and returns the trace of the decisions
+func (b *backend) pathLoginVerifyUpdate(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	roleName := data.Get("role").(string)
+
+	roleEntry, err := b.getOCIRole(ctx, req.Storage, roleName)
+	if err != nil {
+		return nil, err
+	}
+	if roleEntry == nil {
+		return logical.ErrorResponse("Role is not found"), nil
+	}
+
+	requestHeaders, ok := data.GetOk("request_headers")
+	if !ok {
+		return logical.ErrorResponse("request_headers is not specified"), nil
+	}
+	authenticateRequestHeaders := requestHeaders.(http.Header)
+
+	trace := newLoginTrace()
+	trace.set("role", roleName)
+	trace.setRequest(authenticateRequestHeaders)
+
+	matchedGroupIds, internalClaims, err := b.verifyLogin(ctx, req, roleName, roleEntry, authenticateRequestHeaders, trace)
+	if err != nil {
+		trace.deny(err)
+	} else {
+		auth := buildLoginAuth(roleName, roleEntry, matchedGroupIds)
+		b.applyClaimMappings(ctx, req, roleEntry, internalClaims, auth)
+		trace.set("allowed", true)
+		trace.set("policies", auth.Policies)
+		trace.set("metadata", auth.Metadata)
+	}
+
+	b.Logger().Info("login verified", "id", req.ID, "role", roleName, "subject", trace.subjectId, "allowed", err == nil)
+
+	return &logical.Response{
+		Data: trace.data,
+	}, nil
+}
+
+// verifyLogin authenticates the login request and authorizes the principal for the role, recording the decisions in the trace.
+// It returns the matched groups and the claims of the principal.
+func (b *backend) verifyLogin(ctx context.Context, req *logical.Request, roleName string, roleEntry *OCIRoleEntry, authenticateRequestHeaders http.Header, trace *loginTrace) ([]string, InternalClaims, error) {
+	if _, _, err := requestTargetToMethodURL(authenticateRequestHeaders[HdrRequestTarget], roleName); err != nil {
+		return nil, nil, err
+	}
+
+	configEntry, err := b.getOCIConfig(ctx, req.Storage)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	principal, internalClaims, err := b.authenticateLoginRequest(ctx, req, configEntry, authenticateRequestHeaders, trace)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if err := b.addInstanceTags(ctx, req.ID, configEntry, internalClaims); err != nil {
+		trace.set("instance_lookup_error", err.Error())
+		if roleEntry.hasTagBindings() {
+			return nil, nil, identityError(err, LoginErrorIdentityUnavailable)
+		}
+	} else if configEntry.instanceLookup() {
+		trace.set("instance_tags", instanceTagMetadata(internalClaims))
+	}
+
+	if err := b.addUserAttributes(ctx, req.ID, configEntry, principal, internalClaims); err != nil {
+		trace.set("user_lookup_error", err.Error())
+		if len(roleEntry.BoundUserEmails) > 0 {
+			return nil, nil, identityError(err, LoginErrorIdentityUnavailable)
+		}
+	}
+
+	matchedGroupIds, err := b.authorizeRole(ctx, req, configEntry, roleEntry, principal, internalClaims, trace)
+	return matchedGroupIds, internalClaims, err
+}
+
+const pathLoginVerifySyn = `
+Verifies a login request to a role without issuing a token.
+`
+
+const pathLoginVerifyDesc = `
+Verifies the signed headers of a login request to the role, as a login would, and returns the trace of the decisions
+instead of a token: the principal and its claims, the tenancy check, the result of each binding of the role, and
+the groups that were tested and matched. The signature and the security token of the request are redacted.
+
+Unlike login, this endpoint requires a Vault token, and is meant for operators diagnosing failed logins.
+`
@@ -0,0 +1,145 @@
+// Copyright © 2019, Oracle and/or its affiliates.
+package ociauth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/go-secure-stdlib/strutil"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+func TestBackend_LoginVerify(t *testing.T) {
+	memberGroup := "ocid1.dynamicgroup.oc1..member"
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var details FilterGroupMembershipDetails
+		if err := json.NewDecoder(r.Body).Decode(&details); err != nil {
+			t.Errorf("bad request body: %v", err)
+		}
+		result := FilterGroupMembershipResult{Principal: details.Principal, GroupIds: []string{}}
+		for _, ocid := range details.GroupIds {
+			if ocid == memberGroup {
+				result.GroupIds = append(result.GroupIds, ocid)
+			}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(result)
+	}))
+	defer server.Close()
+
+	config := logical.TestBackendConfig()
+	config.StorageView = &logical.InmemStorage{}
+	b, err := Backend()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Setup(context.Background(), config); err != nil {
+		t.Fatal(err)
+	}
+	b.authenticationClient = newTestAuthenticationClient(t, server.URL)
+
+	// The verify endpoint requires a Vault token, unlike login
+	unauthenticated := b.SpecialPaths().Unauthenticated
+	if strutil.StrListContains(unauthenticated, "login/*") || !strutil.StrListContains(unauthenticated, "login/+") {
+		t.Fatalf("unexpected unauthenticated paths: %v", unauthenticated)
+	}
+
+	ca := newTestCA(t)
+	resp, err := b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.CreateOperation,
+		Path:      "config",
+		Storage:   config.StorageView,
+		Data: map[string]interface{}{
+			HomeTenancyIdConfigName:                testTenancyId,
+			InstancePrincipalCABundleConfigName:    ca.bundle(),
+			InstancePrincipalTokenSignerConfigName: testTokenSigner,
+			InstancePrincipalHostsConfigName:       testHost,
+		},
+	})
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("Config creation failed. resp:%#v\n err:%v", resp, err)
+	}
+
+	roles := map[string]map[string]interface{}{
+		"grouprole": {
+			"ocid_list":             memberGroup + ",ocid1.dynamicgroup.oc1..other",
+			"bound_compartment_ids": testCompartmentId,
+			"token_policies":        "policy1",
+		},
+		"otherrole": {
+			"bound_compartment_ids": "ocid1.compartment.oc1..othercompartment",
+			"token_policies":        "policy2",
+		},
+	}
+	for roleName, roleData := range roles {
+		if err := createRole(roleData, roleName, b, config); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	sessionKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	token := ca.securityToken(t, sessionKey, nil)
+
+	verify := func(roleName string) map[string]interface{} {
+		headers := signedInstanceHeaders(t, token, sessionKey, "get "+PathVersionBase+fmt.Sprintf(PathBaseFormat, "oci", roleName))
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      "login/" + roleName + "/verify",
+			Storage:   config.StorageView,
+			Data: map[string]interface{}{
+				"request_headers": map[string][]string(headers),
+			},
+		})
+		if err != nil || resp == nil || resp.IsError() {
+			t.Fatalf("verify failed. resp:%#v\n err:%v", resp, err)
+		}
+		if resp.Auth != nil {
+			t.Fatal("expected no token to be issued")
+		}
+
+		// Neither the security token nor the signature are returned
+		encoded, _ := json.Marshal(resp.Data)
+		if strings.Contains(string(encoded), token) || strings.Contains(string(encoded), "signature=") {
+			t.Fatalf("expected the secrets to be redacted: %s", encoded)
+		}
+		return resp.Data
+	}
+
+	trace := verify("grouprole")
+	if trace["allowed"] != true || trace["key_id"] != securityTokenKeyIdPrefix+redactedValue {
+		t.Fatalf("unexpected trace: %#v", trace)
+	}
+	principal := trace["principal"].(map[string]interface{})
+	if principal["subject_id"] != testInstanceId || principal["claims"].(map[string]interface{})[ClaimCompartment] != testCompartmentId {
+		t.Fatalf("unexpected principal: %#v", principal)
+	}
+	if trace["tenancy_check"].(map[string]interface{})["passed"] != true {
+		t.Fatalf("unexpected tenancy check: %#v", trace["tenancy_check"])
+	}
+	if !reflect.DeepEqual(trace["groups_matched"], []string{memberGroup}) || len(trace["groups_tested"].([]string)) != 2 {
+		t.Fatalf("unexpected groups: %#v", trace)
+	}
+	if !reflect.DeepEqual(trace["policies"], []string{"policy1"}) {
+		t.Fatalf("unexpected policies: %#v", trace["policies"])
+	}
+
+	trace = verify("otherrole")
+	if trace["allowed"] != false || trace["error"] != "Entity not in any of the Role compartments" || trace["error_code"] != LoginErrorBindingFailed {
+		t.Fatalf("unexpected trace: %#v", trace)
+	}
+	bindings := trace["bindings"].([]map[string]interface{})
+	if len(bindings) != 1 || bindings[0]["binding"] != "bound_compartment_ids" || bindings[0]["passed"] != false || bindings[0]["value"] != testCompartmentId {
+		t.Fatalf("unexpected bindings: %#v", bindings)
+	}
+}
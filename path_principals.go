@@ -0,0 +1,232 @@
+// Copyright © 2019, Oracle and/or its affiliates.
+package ociauth
+
+import (
+	"context"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+func pathListPrincipals(b *backend) *framework.Path {
+	return &framework.Path{
+		Pattern: "principals/?",
+
+		DisplayAttrs: &framework.DisplayAttributes{
+			OperationPrefix: operationPrefixOCI,
+			OperationVerb:   "list",
+			OperationSuffix: "principals",
+		},
+
+		Callbacks: map[logical.Operation]framework.OperationFunc{
+			logical.ListOperation: b.pathPrincipalList,
+		},
+
+		HelpSynopsis:    pathListPrincipalsSyn,
+		HelpDescription: pathListPrincipalsDesc,
+	}
+}
+
+func pathPrincipalsTidy(b *backend) *framework.Path {
+	return &framework.Path{
+		Pattern: "principals/tidy$",
+
+		DisplayAttrs: &framework.DisplayAttributes{
+			OperationPrefix: operationPrefixOCI,
+			OperationVerb:   "tidy",
+			OperationSuffix: "principals",
+		},
+
+		Callbacks: map[logical.Operation]framework.OperationFunc{
+			logical.UpdateOperation: b.pathPrincipalsTidyUpdate,
+		},
+
+		HelpSynopsis:    pathPrincipalsTidySyn,
+		HelpDescription: pathPrincipalsTidyDesc,
+	}
+}
+
+func pathPrincipalsUnlock(b *backend) *framework.Path {
+	return &framework.Path{
+		Pattern: "principals/unlock$",
+
+		DisplayAttrs: &framework.DisplayAttributes{
+			OperationPrefix: operationPrefixOCI,
+			OperationVerb:   "unlock",
+			OperationSuffix: "principal",
+		},
+
+		Fields: map[string]*framework.FieldSchema{
+			"subject_id": {
+				Type:        framework.TypeString,
+				Description: "The OCID of the user or instance to unlock.",
+			},
+			"key_id": {
+				Type:        framework.TypeString,
+				Description: "The keyId of the signatures to unlock.",
+			},
+		},
+
+		Callbacks: map[logical.Operation]framework.OperationFunc{
+			logical.UpdateOperation: b.pathPrincipalsUnlockUpdate,
+		},
+
+		HelpSynopsis:    pathPrincipalsUnlockSyn,
+		HelpDescription: pathPrincipalsUnlockDesc,
+	}
+}
+
+func pathPrincipal(b *backend) *framework.Path {
+	return &framework.Path{
+		Pattern: "principals/" + framework.GenericNameRegex("subject_id"),
+
+		DisplayAttrs: &framework.DisplayAttributes{
+			OperationPrefix: operationPrefixOCI,
+			OperationSuffix: "principal",
+		},
+
+		Fields: map[string]*framework.FieldSchema{
+			"subject_id": {
+				Type:        framework.TypeString,
+				Description: "The OCID of the user or instance.",
+			},
+		},
+
+		Callbacks: map[logical.Operation]framework.OperationFunc{
+			logical.ReadOperation: b.pathPrincipalRead,
+		},
+
+		HelpSynopsis:    pathPrincipalSyn,
+		HelpDescription: pathPrincipalDesc,
+	}
+}
+
+func (b *backend) pathPrincipalList(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	subjectIds, err := req.Storage.List(ctx, principalStoragePrefix)
+	if err != nil {
+		return nil, err
+	}
+	return logical.ListResponse(subjectIds), nil
+}
+
+func (b *backend) pathPrincipalRead(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	entry, err := getPrincipal(ctx, req.Storage, data.Get("subject_id").(string))
+	if err != nil {
+		return nil, err
+	}
+	if entry == nil {
+		return nil, nil
+	}
+
+	configEntry, err := b.getOCIConfig(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+
+	// The logins that were not written to the ledger yet are kept in the memory of this node
+	b.principalLogins.peek(entry.SubjectId).apply(entry)
+
+	// The failed logins are counted in memory, so they are those seen by this node
+	responseData := entry.responseData()
+	failures, lockedUntil := b.loginLockouts.status(lockoutSubjectKey(entry.SubjectId), configEntry.lockoutPolicy(), time.Now())
+	responseData["failed_login_count"] = failures
+	responseData["locked_out"] = !lockedUntil.IsZero()
+	responseData["locked_out_until"] = formatTime(lockedUntil.UTC())
+
+	return &logical.Response{
+		Data: responseData,
+	}, nil
+}
+
+// pathPrincipalsUnlockUpdate lifts the lockout of a principal or a keyId, and resets its count of failed logins
+func (b *backend) pathPrincipalsUnlockUpdate(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	subjectId := data.Get("subject_id").(string)
+	keyId := data.Get("key_id").(string)
+	if subjectId == "" && keyId == "" {
+		return logical.ErrorResponse("subject_id or key_id is required"), nil
+	}
+
+	if subjectId != "" && b.loginLockouts.reset(lockoutSubjectKey(subjectId)) {
+		b.Logger().Info("Unlocked a principal", "id", req.ID, "subject", subjectId)
+	}
+	if keyId != "" && b.loginLockouts.reset(lockoutKeyIdKey(keyId)) {
+		b.Logger().Info("Unlocked a keyId", "id", req.ID, "key_id", loggedKeyId(keyId))
+	}
+
+	return nil, nil
+}
+
+// pathPrincipalsTidyUpdate removes the principals that did not log in within the retention
+func (b *backend) pathPrincipalsTidyUpdate(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	configEntry, err := b.getOCIConfig(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+
+	removed, err := b.tidyPrincipals(ctx, req.Storage, configEntry.principalRetention())
+	if err != nil {
+		return nil, err
+	}
+
+	b.Logger().Debug("tidied the login ledger", "removed", removed)
+
+	return &logical.Response{
+		Data: map[string]interface{}{
+			"principals_removed": removed,
+		},
+	}, nil
+}
+
+const pathListPrincipalsSyn = `
+Lists the principals that have logged in.
+`
+
+const pathListPrincipalsDesc = `
+Lists the OCIDs of the users and instances that have logged in with this auth method. The principals
+whose last login is older than the principal_retention of the config are removed hourly by the active
+node, and by principals/tidy, so they are listed until then.
+`
+
+const pathPrincipalSyn = `
+Reads the login record of a principal.
+`
+
+const pathPrincipalDesc = `
+Returns the type and tenancy of the principal, the role of its last login, the times of its first
+and last logins, and its number of logins. It also returns the throttles and the lockouts of the
+principal, and whether it is currently locked out after failed logins.
+
+The record is written on a best effort basis: the logins, throttles and lockouts that are served by a
+performance standby are not recorded, and neither are those that can not be written to the storage. The logins of a principal that keeps logging
+in with the same claims to the same roles are written at most once a minute, and in the meantime are
+kept in the memory of the node that served them.
+`
+
+const pathPrincipalsUnlockSyn = `
+Lifts the lockout of a principal or a keyId.
+`
+
+const pathPrincipalsUnlockDesc = `
+Lifts the lockout of the principal with the subject_id, or of the signatures with the key_id, and resets
+their count of failed logins, so that they can log in again before the lockout_duration of the config ends.
+The failed logins are counted in the memory of each Vault node, so the unlock only applies to the node that
+serves it.
+
+Example:
+
+vault write /auth/oci/principals/unlock subject_id=ocid1.instance.oc1.phx.exampleuniqueid
+`
+
+const pathPrincipalsTidySyn = `
+Removes the principals whose last login is older than the retention.
+`
+
+const pathPrincipalsTidyDesc = `
+Removes the login record of each principal that did not log in within the principal_retention of
+the config.
+
+Example:
+
+vault write -f /auth/oci/principals/tidy
+`
@@ -4,6 +4,11 @@ package ociauth
 import (
 	"context"
 	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/hashicorp/go-secure-stdlib/parseutil"
+	"github.com/hashicorp/go-secure-stdlib/strutil"
 	"github.com/hashicorp/vault/sdk/framework"
 	"github.com/hashicorp/vault/sdk/helper/tokenutil"
 	"github.com/hashicorp/vault/sdk/logical"
@@ -11,9 +16,9 @@ import (
 
 // Constants for role specific data
 const (
-	// Increasing this above this limit might require implementing
-	// client-side paging in the filterGroupMembership API
-	MaxOCIDsPerRole = 100
+	// Group membership is checked in chunks of filterGroupMembershipChunkSize OCIDs,
+	// so this limit only bounds the number of FilterGroupMembership requests per login
+	MaxOCIDsPerRole = 1000
 )
 
 func pathRole(b *backend) *framework.Path {
@@ -34,6 +39,94 @@ func pathRole(b *backend) *framework.Path {
 				Type:        framework.TypeCommaStringSlice,
 				Description: `A comma separated list of Group or Dynamic Group OCIDs that are allowed to take this role.`,
 			},
+			"group_match": {
+				Type:        framework.TypeString,
+				Description: `Whether the principal must be a member of any or of all of the Groups or Dynamic Groups in ocid_list and group_names. Either "any" or "all". Defaults to "any".`,
+			},
+			"group_rule": {
+				Type:        framework.TypeMap,
+				Description: `A nested AND/OR set of Group or Dynamic Group OCIDs that the principal must match to take this role, such as {"all": ["<OCID>", {"any": ["<OCID>", "<OCID>"]}]}. An empty map removes the rule.`,
+			},
+			"group_names": {
+				Type:        framework.TypeCommaStringSlice,
+				Description: `A comma separated list of Group or Dynamic Group names that are allowed to take this role. The names are resolved to OCIDs in the home tenancy when the role is written.`,
+			},
+			"bound_compartment_ids": {
+				Type:        framework.TypeCommaStringSlice,
+				Description: `A comma separated list of compartment OCIDs. If set, only instances in one of these compartments can take this role.`,
+			},
+			"include_subcompartments": {
+				Type:        framework.TypeBool,
+				Description: `If set, instances in the subcompartments of the compartments in bound_compartment_ids, at any depth, can also take this role.`,
+			},
+			"bound_instance_ids": {
+				Type:        framework.TypeCommaStringSlice,
+				Description: `A comma separated list of instance OCIDs. If set, only these instances can take this role.`,
+			},
+			"bound_defined_tags": {
+				Type:        framework.TypeKVPairs,
+				Description: `A map of defined tags, named <namespace>.<key>, to their values. If set, only instances that have all of these defined tags can take this role. Requires the instance_lookup of the config.`,
+			},
+			"bound_freeform_tags": {
+				Type:        framework.TypeKVPairs,
+				Description: `A map of freeform tags to their values. If set, only instances that have all of these freeform tags can take this role. Requires the instance_lookup of the config.`,
+			},
+			"bound_user_emails": {
+				Type:        framework.TypeCommaStringSlice,
+				Description: `A comma separated list of email addresses, which can contain * wildcards such as *@example.com. If set, only users with a verified email address that matches one of these can take this role. Requires the user_lookup of the config.`,
+			},
+			"matching_rule": {
+				Type:        framework.TypeString,
+				Description: `A rule in the syntax of the matching rules of OCI dynamic groups, such as ANY {instance.compartment.id = '<OCID>', instance.id = '<OCID>'}. If set, only principals that match the rule can take this role.`,
+			},
+			"bound_subject_ids": {
+				Type:        framework.TypeCommaStringSlice,
+				Description: `A comma separated list of user or instance OCIDs that are allowed to take this role without being a member of its groups.`,
+			},
+			"denied_subject_ids": {
+				Type:        framework.TypeCommaStringSlice,
+				Description: `A comma separated list of user or instance OCIDs that can never take this role, even if they match it otherwise.`,
+			},
+			"denied_group_ids": {
+				Type:        framework.TypeCommaStringSlice,
+				Description: `A comma separated list of Group or Dynamic Group OCIDs whose members can never take this role, even if they match it otherwise.`,
+			},
+			"group_policies": {
+				Type:        framework.TypeMap,
+				Description: `A map of Group or Dynamic Group OCIDs to the policies, as a list or a comma separated string, that are added to the token when the principal is a member of the group.`,
+			},
+			"claim_mappings": {
+				Type:        framework.TypeKVPairs,
+				Description: `A map of claim keys of the principal, such as opc-compartment, to the names of the token metadata that they are copied to.`,
+			},
+			"token_policy_templates": {
+				Type:        framework.TypeCommaStringSlice,
+				Description: `A comma separated list of policy names that use the values of the principal, such as app-{{compartment_name}}. The variables are the metadata names of claim_mappings and compartment_name.`,
+			},
+			"priority": {
+				Type:        framework.TypeInt,
+				Description: `The priority of the role when a login without a role matches several roles. The matched role with the highest priority is used.`,
+			},
+			"subject_login_rate": {
+				Type:        framework.TypeFloat,
+				Description: `Number of logins per second that each principal can make to this role, overriding the config. 0 disables the limit for the role, and -1 removes the override.`,
+			},
+			"subject_login_burst": {
+				Type:        framework.TypeInt,
+				Description: `Number of logins that each principal can make to this role in a burst, overriding the config.`,
+			},
+			"role_login_rate": {
+				Type:        framework.TypeFloat,
+				Description: `Number of logins per second that can be made to this role, overriding the config. 0 disables the limit for the role, and -1 removes the override.`,
+			},
+			"role_login_burst": {
+				Type:        framework.TypeInt,
+				Description: `Number of logins that can be made to this role in a burst, overriding the config.`,
+			},
+			"force": {
+				Type:        framework.TypeBool,
+				Description: `Store the role even if no principal can take it, because it has no OCIDs, group names, group rule, bindings or bound subjects.`,
+			},
 		},
 
 		ExistenceCheck: b.pathRoleExistenceCheck,
@@ -73,6 +166,32 @@ func pathListRoles(b *backend) *framework.Path {
 	}
 }
 
+func pathRoleRefresh(b *backend) *framework.Path {
+	return &framework.Path{
+		Pattern: "role/" + framework.GenericNameRegex("role") + "/refresh",
+
+		DisplayAttrs: &framework.DisplayAttributes{
+			OperationPrefix: operationPrefixOCI,
+			OperationVerb:   "refresh",
+			OperationSuffix: "role",
+		},
+
+		Fields: map[string]*framework.FieldSchema{
+			"role": {
+				Type:        framework.TypeLowerCaseString,
+				Description: "Name of the role.",
+			},
+		},
+
+		Callbacks: map[logical.Operation]framework.OperationFunc{
+			logical.UpdateOperation: b.pathRoleRefreshUpdate,
+		},
+
+		HelpSynopsis:    pathRoleRefreshSyn,
+		HelpDescription: pathRoleRefreshDesc,
+	}
+}
+
 // Establishes dichotomy of request operation between CreateOperation and UpdateOperation.
 // Returning 'true' forces an UpdateOperation, CreateOperation otherwise.
 func (b *backend) pathRoleExistenceCheck(ctx context.Context, req *logical.Request, data *framework.FieldData) (bool, error) {
@@ -103,14 +222,39 @@ func (b *backend) setOCIRole(ctx context.Context, s logical.Storage, roleName st
 		return err
 	}
 
+	b.invalidateOCIRole(roleName)
+
 	return nil
 }
 
-// getOCIRole returns the properties set on the given role.
+// getOCIRole returns the properties set on the given role, from the cache if possible.
+// The returned entry is shared and must not be modified; use readOCIRole to get an entry to update.
+func (b *backend) getOCIRole(ctx context.Context, s logical.Storage, roleName string) (*OCIRoleEntry, error) {
+	if roleName == "" {
+		return nil, fmt.Errorf("missing role name")
+	}
+
+	roleEntry, generation := b.cachedOCIRole(roleName)
+	emitCacheMetrics(metricCacheRole, roleEntry != nil)
+	if roleEntry != nil {
+		return roleEntry, nil
+	}
+
+	roleEntry, err := b.readOCIRole(ctx, s, roleName)
+	if err != nil || roleEntry == nil {
+		return roleEntry, err
+	}
+
+	b.cacheOCIRole(roleName, roleEntry, generation)
+
+	return roleEntry, nil
+}
+
+// readOCIRole reads the properties set on the given role from the storage.
 // This method does NOT check to see if a role upgrade is required. It is
 // the responsibility of the caller to check if a role upgrade is required and,
 // if so, to upgrade the role
-func (b *backend) getOCIRole(ctx context.Context, s logical.Storage, roleName string) (*OCIRoleEntry, error) {
+func (b *backend) readOCIRole(ctx context.Context, s logical.Storage, roleName string) (*OCIRoleEntry, error) {
 	if roleName == "" {
 		return nil, fmt.Errorf("missing role name")
 	}
@@ -134,7 +278,13 @@ func (b *backend) getOCIRole(ctx context.Context, s logical.Storage, roleName st
 func (b *backend) pathRoleDelete(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
 	roleName := data.Get("role").(string)
 
-	return nil, req.Storage.Delete(ctx, "role/"+roleName)
+	if err := req.Storage.Delete(ctx, "role/"+roleName); err != nil {
+		return nil, err
+	}
+
+	b.invalidateOCIRole(roleName)
+
+	return nil, nil
 }
 
 func (b *backend) pathRoleList(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
@@ -155,7 +305,32 @@ func (b *backend) pathRoleRead(ctx context.Context, req *logical.Request, data *
 	}
 
 	responseData := map[string]interface{}{
-		"ocid_list": append([]string{}, roleEntry.OcidList...),
+		"ocid_list":               append([]string{}, roleEntry.OcidList...),
+		"group_names":             roleEntry.groupNamesData(),
+		"group_match":             roleEntry.groupMatch(),
+		"group_rule":              roleEntry.groupRuleData(),
+		"bound_compartment_ids":   append([]string{}, roleEntry.BoundCompartmentIds...),
+		"bound_instance_ids":      append([]string{}, roleEntry.BoundInstanceIds...),
+		"include_subcompartments": roleEntry.IncludeSubcompartments,
+		"bound_defined_tags":      copyStringMap(roleEntry.BoundDefinedTags),
+		"bound_freeform_tags":     copyStringMap(roleEntry.BoundFreeformTags),
+		"bound_user_emails":       append([]string{}, roleEntry.BoundUserEmails...),
+		"matching_rule":           roleEntry.MatchingRule,
+		"bound_subject_ids":       append([]string{}, roleEntry.BoundSubjectIds...),
+		"denied_subject_ids":      append([]string{}, roleEntry.DeniedSubjectIds...),
+		"denied_group_ids":        append([]string{}, roleEntry.DeniedGroupIds...),
+		"group_policies":          roleEntry.groupPoliciesData(),
+		"claim_mappings":          copyStringMap(roleEntry.ClaimMappings),
+		"token_policy_templates":  append([]string{}, roleEntry.TokenPolicyTemplates...),
+		"priority":                roleEntry.Priority,
+	}
+	if roleEntry.SubjectLoginRateLimit != nil {
+		responseData["subject_login_rate"] = roleEntry.SubjectLoginRateLimit.Rate
+		responseData["subject_login_burst"] = roleEntry.SubjectLoginRateLimit.burst()
+	}
+	if roleEntry.RoleLoginRateLimit != nil {
+		responseData["role_login_rate"] = roleEntry.RoleLoginRateLimit.Rate
+		responseData["role_login_burst"] = roleEntry.RoleLoginRateLimit.burst()
 	}
 
 	roleEntry.PopulateTokenData(responseData)
@@ -170,7 +345,7 @@ func (b *backend) pathRoleCreateUpdate(ctx context.Context, req *logical.Request
 
 	roleName := data.Get("role").(string)
 
-	roleEntry, err := b.getOCIRole(ctx, req.Storage, roleName)
+	roleEntry, err := b.readOCIRole(ctx, req.Storage, roleName)
 	if err != nil {
 		return nil, err
 	}
@@ -181,11 +356,210 @@ func (b *backend) pathRoleCreateUpdate(ctx context.Context, req *logical.Request
 		return logical.ErrorResponse("The specified role does not exist"), nil
 	}
 
+	configEntry, err := b.getOCIConfig(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+
+	// The OCIDs that are set by this request are validated, so that typos are found before any login fails
+	realm := homeTenancyRealm(configEntry)
+	var warnings []string
+	var newGroupOcids []string
+	validate := func(field string, values []string, resourceTypes ...string) error {
+		fieldWarnings, err := validateOCIDs(field, values, realm, resourceTypes...)
+		warnings = append(warnings, fieldWarnings...)
+		return err
+	}
+
 	if ocidList, ok := data.GetOk("ocid_list"); ok {
 		roleEntry.OcidList = ocidList.([]string)
 		if len(roleEntry.OcidList) > MaxOCIDsPerRole {
 			return logical.ErrorResponse("Number of OCIDs for this role exceeds the limit"), nil
 		}
+		if err := validate("ocid_list", roleEntry.OcidList, ocidTypeGroup, ocidTypeDynamicGroup); err != nil {
+			return logical.ErrorResponse(err.Error()), nil
+		}
+		newGroupOcids = append(newGroupOcids, roleEntry.OcidList...)
+	}
+
+	if groupNames, ok := data.GetOk("group_names"); ok {
+		roleEntry.GroupNames, err = b.resolveGroupOcids(ctx, req.ID, configEntry.homeTenancyId(), strutil.RemoveDuplicatesStable(groupNames.([]string), false))
+		if err != nil {
+			return logical.ErrorResponse(err.Error()), nil
+		}
+	}
+
+	if groupMatch, ok := data.GetOk("group_match"); ok {
+		roleEntry.GroupMatch = groupMatch.(string)
+		if roleEntry.GroupMatch != groupMatchAny && roleEntry.GroupMatch != groupMatchAll {
+			return logical.ErrorResponse(fmt.Sprintf("invalid group_match %q, expected %q or %q", roleEntry.GroupMatch, groupMatchAny, groupMatchAll)), nil
+		}
+	}
+
+	if groupRule, ok := data.GetOk("group_rule"); ok {
+		roleEntry.GroupRule = nil
+		if input := groupRule.(map[string]interface{}); len(input) > 0 {
+			roleEntry.GroupRule, err = parseGroupRule(input, 1)
+			if err != nil {
+				return logical.ErrorResponse(err.Error()), nil
+			}
+			ruleOcids := roleEntry.GroupRule.ocids()
+			if err := validate("group_rule", ruleOcids, ocidTypeGroup, ocidTypeDynamicGroup); err != nil {
+				return logical.ErrorResponse(err.Error()), nil
+			}
+			newGroupOcids = append(newGroupOcids, ruleOcids...)
+		}
+	}
+
+	if boundCompartmentIds, ok := data.GetOk("bound_compartment_ids"); ok {
+		roleEntry.BoundCompartmentIds = boundCompartmentIds.([]string)
+		if err := validate("bound_compartment_ids", roleEntry.BoundCompartmentIds, ocidTypeCompartment, ocidTypeTenancy); err != nil {
+			return logical.ErrorResponse(err.Error()), nil
+		}
+	}
+
+	if includeSubcompartments, ok := data.GetOk("include_subcompartments"); ok {
+		roleEntry.IncludeSubcompartments = includeSubcompartments.(bool)
+	}
+	if roleEntry.IncludeSubcompartments && len(roleEntry.BoundCompartmentIds) == 0 {
+		warnings = append(warnings, "include_subcompartments has no effect because the role has no bound_compartment_ids")
+	}
+
+	if boundInstanceIds, ok := data.GetOk("bound_instance_ids"); ok {
+		roleEntry.BoundInstanceIds = boundInstanceIds.([]string)
+		if err := validate("bound_instance_ids", roleEntry.BoundInstanceIds, ocidTypeInstance); err != nil {
+			return logical.ErrorResponse(err.Error()), nil
+		}
+	}
+
+	for _, field := range []string{"bound_defined_tags", "bound_freeform_tags"} {
+		tags, ok := data.GetOk(field)
+		if !ok {
+			continue
+		}
+		parsed, err := parseTagBindings(field, tags.(map[string]string))
+		if err != nil {
+			return logical.ErrorResponse(err.Error()), nil
+		}
+		if field == "bound_defined_tags" {
+			roleEntry.BoundDefinedTags = parsed
+		} else {
+			roleEntry.BoundFreeformTags = parsed
+		}
+	}
+	if boundUserEmails, ok := data.GetOk("bound_user_emails"); ok {
+		roleEntry.BoundUserEmails, err = parseUserEmails(boundUserEmails.([]string))
+		if err != nil {
+			return logical.ErrorResponse(err.Error()), nil
+		}
+	}
+	if len(roleEntry.BoundUserEmails) > 0 && !configEntry.userLookup() {
+		warnings = append(warnings, fmt.Sprintf("No user can take the role until %s is enabled in the config, because the role is bound to email addresses", UserLookupConfigName))
+	}
+
+	if roleEntry.hasTagBindings() && !configEntry.instanceLookup() {
+		warnings = append(warnings, fmt.Sprintf("No instance can take the role until %s is enabled in the config, because the role is bound to tags", InstanceLookupConfigName))
+	}
+
+	if matchingRule, ok := data.GetOk("matching_rule"); ok {
+		roleEntry.MatchingRule = strings.TrimSpace(matchingRule.(string))
+		if roleEntry.MatchingRule != "" {
+			if _, err := parseMatchingRule(roleEntry.MatchingRule); err != nil {
+				return logical.ErrorResponse(err.Error()), nil
+			}
+		}
+	}
+
+	if boundSubjectIds, ok := data.GetOk("bound_subject_ids"); ok {
+		roleEntry.BoundSubjectIds = boundSubjectIds.([]string)
+		if err := validate("bound_subject_ids", roleEntry.BoundSubjectIds, ocidTypeUser, ocidTypeInstance); err != nil {
+			return logical.ErrorResponse(err.Error()), nil
+		}
+	}
+
+	if deniedSubjectIds, ok := data.GetOk("denied_subject_ids"); ok {
+		roleEntry.DeniedSubjectIds = deniedSubjectIds.([]string)
+		if err := validate("denied_subject_ids", roleEntry.DeniedSubjectIds, ocidTypeUser, ocidTypeInstance); err != nil {
+			return logical.ErrorResponse(err.Error()), nil
+		}
+	}
+
+	if deniedGroupIds, ok := data.GetOk("denied_group_ids"); ok {
+		roleEntry.DeniedGroupIds = deniedGroupIds.([]string)
+		if err := validate("denied_group_ids", roleEntry.DeniedGroupIds, ocidTypeGroup, ocidTypeDynamicGroup); err != nil {
+			return logical.ErrorResponse(err.Error()), nil
+		}
+		newGroupOcids = append(newGroupOcids, roleEntry.DeniedGroupIds...)
+	}
+
+	if groupPolicies, ok := data.GetOk("group_policies"); ok {
+		roleEntry.GroupPolicies, err = parseGroupPolicies(groupPolicies.(map[string]interface{}))
+		if err != nil {
+			return logical.ErrorResponse(err.Error()), nil
+		}
+		groupPolicyOcids := make([]string, 0, len(roleEntry.GroupPolicies))
+		for ocid := range roleEntry.GroupPolicies {
+			groupPolicyOcids = append(groupPolicyOcids, ocid)
+		}
+		sort.Strings(groupPolicyOcids)
+		if err := validate("group_policies", groupPolicyOcids, ocidTypeGroup, ocidTypeDynamicGroup); err != nil {
+			return logical.ErrorResponse(err.Error()), nil
+		}
+		newGroupOcids = append(newGroupOcids, groupPolicyOcids...)
+	}
+
+	if claimMappings, ok := data.GetOk("claim_mappings"); ok {
+		roleEntry.ClaimMappings = claimMappings.(map[string]string)
+		if err := validateClaimMappings(roleEntry.ClaimMappings); err != nil {
+			return logical.ErrorResponse(err.Error()), nil
+		}
+	}
+
+	if policyTemplates, ok := data.GetOk("token_policy_templates"); ok {
+		roleEntry.TokenPolicyTemplates = policyTemplates.([]string)
+	}
+	// The templates are validated again when claim_mappings changes, since they use its metadata names
+	roleEntry.TokenPolicyTemplates, err = parsePolicyTemplates(roleEntry.TokenPolicyTemplates, roleEntry.ClaimMappings)
+	if err != nil {
+		return logical.ErrorResponse(err.Error()), nil
+	}
+
+	if len(roleEntry.membershipOcids()) > MaxOCIDsPerRole {
+		return logical.ErrorResponse("Number of OCIDs for this role exceeds the limit"), nil
+	}
+
+	if len(newGroupOcids) > 0 || len(roleEntry.BoundCompartmentIds) > 0 || len(roleEntry.BoundInstanceIds) > 0 ||
+		len(roleEntry.BoundSubjectIds) > 0 || len(roleEntry.DeniedSubjectIds) > 0 {
+		if realm == "" {
+			warnings = append(warnings, fmt.Sprintf("The realm of the OCIDs was not validated because %s is not configured", HomeTenancyIdConfigName))
+		}
+	}
+
+	if len(newGroupOcids) > 0 && configEntry != nil && configEntry.OnlineOCIDValidation {
+		if err := b.validateGroupsExist(ctx, req.ID, configEntry.homeTenancyId(), strutil.RemoveDuplicatesStable(newGroupOcids, false)); err != nil {
+			return logical.ErrorResponse(err.Error()), nil
+		}
+	}
+
+	// A role without OCIDs, group names, bindings or bound subjects can never be taken
+	if !roleEntry.canBeTaken() {
+		if !data.Get("force").(bool) {
+			return logical.ErrorResponse("No principal can take the role because it has no ocid_list, group_names, group_rule, bindings or bound_subject_ids; set force to store it anyway"), nil
+		}
+		warnings = append(warnings, "No principal can take the role because it has no ocid_list, group_names, group_rule, bindings or bound_subject_ids")
+	}
+
+	if priority, ok := data.GetOk("priority"); ok {
+		roleEntry.Priority = priority.(int)
+	}
+
+	roleEntry.SubjectLoginRateLimit, err = parseRoleLoginRateLimit(data, "subject_login_rate", "subject_login_burst", roleEntry.SubjectLoginRateLimit)
+	if err != nil {
+		return logical.ErrorResponse(err.Error()), nil
+	}
+	roleEntry.RoleLoginRateLimit, err = parseRoleLoginRateLimit(data, "role_login_rate", "role_login_burst", roleEntry.RoleLoginRateLimit)
+	if err != nil {
+		return logical.ErrorResponse(err.Error()), nil
 	}
 
 	if err := roleEntry.ParseTokenFields(req, data); err != nil {
@@ -198,14 +572,322 @@ func (b *backend) pathRoleCreateUpdate(ctx context.Context, req *logical.Request
 		return nil, err
 	}
 
+	if len(warnings) > 0 {
+		resp = &logical.Response{}
+		for _, warning := range warnings {
+			resp.AddWarning(warning)
+		}
+	}
+
 	return resp, nil
 }
 
+// pathRoleRefreshUpdate resolves the group names of the role again, after groups were renamed or recreated
+func (b *backend) pathRoleRefreshUpdate(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	roleName := data.Get("role").(string)
+
+	roleEntry, err := b.readOCIRole(ctx, req.Storage, roleName)
+	if err != nil {
+		return nil, err
+	}
+	if roleEntry == nil {
+		return logical.ErrorResponse("The specified role does not exist"), nil
+	}
+
+	configEntry, err := b.getOCIConfig(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+
+	groupNames := make([]string, 0, len(roleEntry.GroupNames))
+	for name := range roleEntry.GroupNames {
+		groupNames = append(groupNames, name)
+	}
+	sort.Strings(groupNames)
+
+	roleEntry.GroupNames, err = b.resolveGroupOcids(ctx, req.ID, configEntry.homeTenancyId(), groupNames)
+	if err != nil {
+		return logical.ErrorResponse(err.Error()), nil
+	}
+
+	if err := b.setOCIRole(ctx, req.Storage, roleName, roleEntry); err != nil {
+		return nil, err
+	}
+
+	return &logical.Response{
+		Data: map[string]interface{}{
+			"group_names": roleEntry.groupNamesData(),
+		},
+	}, nil
+}
+
 // Struct to hold the information associated with an OCI role
 type OCIRoleEntry struct {
 	tokenutil.TokenParams
 
-	OcidList []string `json:"ocid_list"`
+	OcidList            []string            `json:"ocid_list"`
+	GroupNames          map[string]string   `json:"group_names"`
+	GroupMatch          string              `json:"group_match,omitempty"`
+	GroupRule           *groupRule          `json:"group_rule,omitempty"`
+	BoundCompartmentIds []string            `json:"bound_compartment_ids"`
+	BoundInstanceIds    []string            `json:"bound_instance_ids"`
+	BoundDefinedTags    map[string]string   `json:"bound_defined_tags,omitempty"`
+	BoundFreeformTags   map[string]string   `json:"bound_freeform_tags,omitempty"`
+	BoundUserEmails     []string            `json:"bound_user_emails,omitempty"`
+	MatchingRule        string              `json:"matching_rule,omitempty"`
+	GroupPolicies       map[string][]string `json:"group_policies"`
+	Priority            int                 `json:"priority"`
+
+	// The claims copied to the token metadata, and the policies named after the values of the principal
+	ClaimMappings        map[string]string `json:"claim_mappings,omitempty"`
+	TokenPolicyTemplates []string          `json:"token_policy_templates,omitempty"`
+
+	// IncludeSubcompartments extends bound_compartment_ids to the descendants of its compartments
+	IncludeSubcompartments bool `json:"include_subcompartments,omitempty"`
+
+	// The principals that can take the role without group membership, and those that can never take it
+	BoundSubjectIds  []string `json:"bound_subject_ids,omitempty"`
+	DeniedSubjectIds []string `json:"denied_subject_ids,omitempty"`
+	DeniedGroupIds   []string `json:"denied_group_ids,omitempty"`
+
+	// The login rate limits of the role, which override those of the config if set
+	SubjectLoginRateLimit *loginRateLimit `json:"subject_login_rate_limit,omitempty"`
+	RoleLoginRateLimit    *loginRateLimit `json:"role_login_rate_limit,omitempty"`
+}
+
+// roleBinding restricts the principals that can take a role to those whose claim has one of the values
+type roleBinding struct {
+	field  string
+	claim  string
+	values []string
+
+	// ancestorsClaim is the claim of the ancestors of the value, any of which can also be allowed by the binding
+	ancestorsClaim string
+
+	// glob is set if the values are glob patterns, such as *@example.com
+	glob bool
+
+	// errMessage is the error returned when the claim of the principal has none of the values
+	errMessage string
+}
+
+// check returns an error if the value of the claim is not allowed by the binding
+func (binding roleBinding) check(value string) error {
+	if value == "" || (binding.glob && !strutil.StrListContainsGlob(binding.values, value)) ||
+		(!binding.glob && !strutil.StrListContains(binding.values, value)) {
+		return fmt.Errorf("%s", binding.errMessage)
+	}
+	return nil
+}
+
+// bindings returns the bindings that are set on the role
+func (roleEntry *OCIRoleEntry) bindings() []roleBinding {
+	var bindings []roleBinding
+	if len(roleEntry.BoundCompartmentIds) > 0 {
+		bindings = append(bindings, roleBinding{
+			field:      "bound_compartment_ids",
+			claim:      ClaimCompartment,
+			values:     roleEntry.BoundCompartmentIds,
+			errMessage: "Entity not in any of the Role compartments",
+		})
+		if roleEntry.IncludeSubcompartments {
+			bindings[len(bindings)-1].ancestorsClaim = claimCompartmentAncestors
+		}
+	}
+	if len(roleEntry.BoundInstanceIds) > 0 {
+		bindings = append(bindings, roleBinding{
+			field:      "bound_instance_ids",
+			claim:      ClaimInstance,
+			values:     roleEntry.BoundInstanceIds,
+			errMessage: "Entity not one of the Role instances",
+		})
+	}
+	bindings = append(bindings, roleEntry.tagBindings()...)
+	return append(bindings, roleEntry.userEmailBinding()...)
+}
+
+// hasBindings returns true if the role restricts the principals that can take it by their claims
+func (roleEntry *OCIRoleEntry) hasBindings() bool {
+	return len(roleEntry.bindings()) > 0 || roleEntry.MatchingRule != ""
+}
+
+// canBeTaken returns true if some principal can take the role, by group membership, by its bindings or as a bound subject
+func (roleEntry *OCIRoleEntry) canBeTaken() bool {
+	return roleEntry.requiresGroups() || roleEntry.hasBindings() || len(roleEntry.BoundSubjectIds) > 0
+}
+
+// requiresGroups returns true if the role is taken by group membership, through its OCIDs or its group rule
+func (roleEntry *OCIRoleEntry) requiresGroups() bool {
+	return len(roleEntry.roleOcids()) > 0 || roleEntry.GroupRule != nil
+}
+
+// groupMatch returns the group_match mode of the role
+func (roleEntry *OCIRoleEntry) groupMatch() string {
+	if roleEntry.GroupMatch == "" {
+		return groupMatchAny
+	}
+	return roleEntry.GroupMatch
+}
+
+// matchesAnyGroup returns true if membership of any one of the OCIDs of the role is enough to take it,
+// so that the group membership check can stop at the first match
+func (roleEntry *OCIRoleEntry) matchesAnyGroup() bool {
+	return roleEntry.groupMatch() == groupMatchAny && roleEntry.GroupRule == nil
+}
+
+// matchesGroups returns true if the groups that the principal is a member of allow it to take the role: the OCIDs
+// of the role are matched as set by group_match, and the group rule must match as well if it is set
+func (roleEntry *OCIRoleEntry) matchesGroups(groupIds []string) bool {
+	groupIdMap := sliceToMap(groupIds)
+	if roleOcids := roleEntry.roleOcids(); len(roleOcids) > 0 {
+		rule := groupRule{Operator: roleEntry.groupMatch(), GroupIds: roleOcids}
+		if !rule.matches(groupIdMap) {
+			return false
+		}
+	}
+	return roleEntry.GroupRule == nil || roleEntry.GroupRule.matches(groupIdMap)
+}
+
+// grantingOcids returns the OCIDs that allow a principal to take the role: the OCIDs of the role
+// followed by the sorted OCIDs of the group rule that are not among them
+func (roleEntry *OCIRoleEntry) grantingOcids() []string {
+	roleOcids := roleEntry.roleOcids()
+	if roleEntry.GroupRule == nil {
+		return roleOcids
+	}
+	return appendMissingOcids(roleOcids, roleEntry.GroupRule.ocids())
+}
+
+// bindsSubject returns true if the subject is in bound_subject_ids
+func (roleEntry *OCIRoleEntry) bindsSubject(subjectId string) bool {
+	return subjectId != "" && strutil.StrListContains(roleEntry.BoundSubjectIds, subjectId)
+}
+
+// deniesSubject returns true if the subject is in denied_subject_ids
+func (roleEntry *OCIRoleEntry) deniesSubject(subjectId string) bool {
+	return subjectId != "" && strutil.StrListContains(roleEntry.DeniedSubjectIds, subjectId)
+}
+
+// deniedGroups returns the OCIDs of denied_group_ids that are among the given groups
+func (roleEntry *OCIRoleEntry) deniedGroups(groupIds []string) []string {
+	var denied []string
+	for _, groupId := range groupIds {
+		if strutil.StrListContains(roleEntry.DeniedGroupIds, groupId) {
+			denied = append(denied, groupId)
+		}
+	}
+	return denied
+}
+
+// roleOcids returns the OCIDs that are allowed to take the role:
+// the OCIDs in ocid_list followed by the sorted OCIDs of group_names that are not in ocid_list
+func (roleEntry *OCIRoleEntry) roleOcids() []string {
+	if len(roleEntry.GroupNames) == 0 {
+		return roleEntry.OcidList
+	}
+	return appendMissingOcids(roleEntry.OcidList, mapToSlice(roleEntry.GroupNames))
+}
+
+// groupOcids returns the OCIDs that grant the role or its policies:
+// the OCIDs allowed to take the role followed by the sorted OCIDs of group_policies that are not among them
+func (roleEntry *OCIRoleEntry) groupOcids() []string {
+	roleOcids := roleEntry.grantingOcids()
+	if len(roleEntry.GroupPolicies) == 0 {
+		return roleOcids
+	}
+
+	groupPolicyOcids := make([]string, 0, len(roleEntry.GroupPolicies))
+	for ocid := range roleEntry.GroupPolicies {
+		groupPolicyOcids = append(groupPolicyOcids, ocid)
+	}
+	return appendMissingOcids(roleOcids, groupPolicyOcids)
+}
+
+// membershipOcids returns the OCIDs whose group membership is checked on login, followed by the sorted OCIDs of
+// denied_group_ids that are not among them
+func (roleEntry *OCIRoleEntry) membershipOcids() []string {
+	ocids := roleEntry.groupOcids()
+	if len(roleEntry.DeniedGroupIds) == 0 {
+		return ocids
+	}
+	return appendMissingOcids(ocids, roleEntry.DeniedGroupIds)
+}
+
+// appendMissingOcids returns a copy of ocids followed by the sorted extraOcids that are not in ocids
+func appendMissingOcids(ocids []string, extraOcids []string) []string {
+	var missingOcids []string
+	for _, ocid := range extraOcids {
+		if !strutil.StrListContains(ocids, ocid) && !strutil.StrListContains(missingOcids, ocid) {
+			missingOcids = append(missingOcids, ocid)
+		}
+	}
+	sort.Strings(missingOcids)
+
+	return append(append([]string{}, ocids...), missingOcids...)
+}
+
+// matchedGroupPolicies returns the policies that group_policies grants to members of the given groups
+func (roleEntry *OCIRoleEntry) matchedGroupPolicies(groupIds []string) []string {
+	var policies []string
+	for _, groupId := range groupIds {
+		policies = append(policies, roleEntry.GroupPolicies[groupId]...)
+	}
+	return policies
+}
+
+// groupNamesData returns a copy of group_names for responses
+func (roleEntry *OCIRoleEntry) groupNamesData() map[string]string {
+	groupNames := make(map[string]string, len(roleEntry.GroupNames))
+	for name, ocid := range roleEntry.GroupNames {
+		groupNames[name] = ocid
+	}
+	return groupNames
+}
+
+// groupRuleData returns the group rule for responses, or an empty map if it is not set
+func (roleEntry *OCIRoleEntry) groupRuleData() map[string]interface{} {
+	if roleEntry.GroupRule == nil {
+		return map[string]interface{}{}
+	}
+	return roleEntry.GroupRule.data()
+}
+
+// copyStringMap returns a copy of a map of the role for responses
+func copyStringMap(input map[string]string) map[string]string {
+	output := make(map[string]string, len(input))
+	for key, value := range input {
+		output[key] = value
+	}
+	return output
+}
+
+// groupPoliciesData returns a copy of group_policies for responses
+func (roleEntry *OCIRoleEntry) groupPoliciesData() map[string][]string {
+	groupPolicies := make(map[string][]string, len(roleEntry.GroupPolicies))
+	for ocid, policies := range roleEntry.GroupPolicies {
+		groupPolicies[ocid] = append([]string{}, policies...)
+	}
+	return groupPolicies
+}
+
+// parseGroupPolicies parses the group_policies field, whose values are lists or comma separated strings of policies
+func parseGroupPolicies(input map[string]interface{}) (map[string][]string, error) {
+	groupPolicies := make(map[string][]string, len(input))
+	for ocid, value := range input {
+		if ocid == "" {
+			return nil, fmt.Errorf("group_policies contains an empty OCID")
+		}
+		policies, err := parseutil.ParseCommaStringSlice(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid policies for %q in group_policies: %w", ocid, err)
+		}
+		policies = strutil.RemoveDuplicates(policies, true)
+		if len(policies) == 0 {
+			return nil, fmt.Errorf("no policies for %q in group_policies", ocid)
+		}
+		groupPolicies[ocid] = policies
+	}
+	return groupPolicies, nil
 }
 
 const pathRoleSyn = `
@@ -214,6 +896,85 @@ Create a role and associate policies to it.
 
 const pathRoleDesc = `
 Create a role and associate policies to it.
+
+A principal can take the role if it is a member of one of the Groups or Dynamic Groups in ocid_list.
+The role can additionally be bound to instances by bound_compartment_ids and bound_instance_ids, in which
+case the principal must also satisfy every binding that is set. A role that only has bindings does not
+require group membership, so instance principal logins verified locally need no call to OCI Identity.
+
+If include_subcompartments is set, bound_compartment_ids also covers the subcompartments of its compartments at
+any depth: an instance can take the role if its compartment or any ancestor of it is listed. The ancestors are
+looked up with OCI Identity, and cached for an hour, when the compartment of the instance is not listed itself.
+
+bound_defined_tags and bound_freeform_tags bind the role to the tags of instances: an instance must have every tag
+of the role, with the given value, to take it. The defined tags are named <namespace>.<key>, and the names of tags
+are not case sensitive. The tags of an instance are looked up with the Compute API, which requires the
+instance_lookup of the config.
+
+bound_user_emails binds the role to the email addresses of users, looked up with OCI Identity, which requires the
+user_lookup of the config. The addresses can contain * wildcards, such as *@example.com, and are not case sensitive.
+Only verified email addresses are matched.
+
+matching_rule binds the role with a rule in the syntax of the matching rules of OCI dynamic groups, which is
+evaluated against the claims of the principal on login. ANY {...} and ALL {...} combine conditions and nested rules,
+and the conditions compare instance.id, instance.compartment.id or the value of a defined tag,
+tag.<namespace>.<key>.value, to a value in single quotes with = or !=:
+
+ANY {instance.compartment.id = 'ocid1.compartment.oc1..examplecompartment', instance.id = 'ocid1.instance.oc1.phx.exampleinstance'}
+
+A condition on an attribute that the principal does not have, such as instance.id for a user, does not match.
+The defined tags of instances are only known if the instance_lookup of the config is enabled.
+The rule is parsed when the role is written, and an error gives the position of the problem.
+
+group_match sets whether the principal must be a member of any (the default) or of all of the groups in ocid_list
+and group_names. group_rule is a nested AND/OR set of groups that the principal must match as well, written as a map
+with a single "all" or "any" key whose value is a list of OCIDs or nested rules, for example:
+
+{"all": ["ocid1.dynamicgroup.oc1..prodhosts", {"any": ["ocid1.dynamicgroup.oc1..pcihosts", "ocid1.group.oc1..auditors"]}]}
+
+A role with a group_rule and no ocid_list or group_names is taken by the principals that match the rule. The rule is
+evaluated against the result of a single group membership check of all its OCIDs.
+
+group_names lists Groups or Dynamic Groups of the home tenancy by name instead of by OCID. The names are resolved
+to OCIDs when the role is written, and both are shown when the role is read. Use the role/<role>/refresh endpoint
+to resolve the names again after a group is renamed or recreated.
+
+group_policies maps Group or Dynamic Group OCIDs to additional policies. A principal that can take the role
+gets the policies of every group in group_policies that it is a member of, in addition to token_policies.
+Membership of a group that is only in group_policies does not allow the principal to take the role.
+The matched groups are listed in the matched_group_ids metadata of the token.
+
+bound_subject_ids lists users or instances by OCID that can take the role without being a member of its groups.
+They must still satisfy the bindings of the role. denied_subject_ids and denied_group_ids list the users or instances,
+and the Groups or Dynamic Groups, that can never take the role: they always override a match, so that a single
+principal can be excluded without changing the policies of OCI IAM. A denied login fails with the denied error code.
+
+The OCIDs set on a role must be well formed, of the expected resource type, and in the realm of the home tenancy.
+A role that no principal can take, because it has no ocid_list, group_names, group_rule, bindings or
+bound_subject_ids, is refused unless force is set.
+
+claim_mappings copies claims of the principal to the metadata of the token, under the given names. For example,
+claim_mappings=opc-compartment=compartment_id sets the compartment_id metadata of the tokens of instances.
+token_policy_templates are policy names that use the values of the principal, written as {{<name>}}, where the name
+is a metadata name of claim_mappings or compartment_name, the name of the compartment of the principal that is looked
+up with OCI Identity. The rendered policies are added to token_policies, so that a single role can serve many teams:
+
+vault write auth/oci/role/apps ocid_list=<OCID> token_policy_templates="app-{{compartment_name}}"
+
+A template whose variable has no value for the principal, such as compartment_name for a user, grants no policy.
+
+subject_login_rate, subject_login_burst, role_login_rate and role_login_burst override the login rate limits of the
+config for this role. Setting a rate to -1 removes the override.
+`
+
+const pathRoleRefreshSyn = `
+Resolve the group names of a role again.
+`
+
+const pathRoleRefreshDesc = `
+Resolves the group_names of the role to the OCIDs of the Groups or Dynamic Groups that currently have these names
+in the home tenancy, and stores them in the role. A name that no longer resolves to exactly one group is an error,
+in which case the role is not modified.
 `
 
 const pathListRolesHelpSyn = `
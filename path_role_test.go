@@ -3,10 +3,16 @@ package ociauth
 
 import (
 	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
 	"strconv"
+	"strings"
 	"testing"
 
 	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/oracle/oci-go-sdk/v65/common"
+	"github.com/oracle/oci-go-sdk/v65/identity"
 	"os"
 )
 
@@ -29,7 +35,7 @@ func TestBackend_PathRoles(t *testing.T) {
 
 	roleData := map[string]interface{}{
 		"description":    "My dev role",
-		"ocid_list":      "ocid1,ocid2",
+		"ocid_list":      "ocid1.group.oc1..group1,ocid1.group.oc1..group2",
 		"token_policies": "policy1,policy2",
 		"token_ttl":      1500,
 	}
@@ -68,7 +74,7 @@ func TestBackend_PathRoles(t *testing.T) {
 	// now update the roles
 	roleDataUpdate := map[string]interface{}{
 		"description":    "My developer role",
-		"ocid_list":      "ocid3",
+		"ocid_list":      "ocid1.group.oc1..group3",
 		"token_policies": "ocid1",
 		"token_ttl":      1000,
 	}
@@ -141,3 +147,101 @@ func TestBackend_PathRoles(t *testing.T) {
 		t.Fatalf("Failed to list the expected number of roles")
 	}
 }
+
+func TestBackend_RoleOCIDValidation(t *testing.T) {
+	existingGroup := "ocid1.group.oc1..existing"
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if !strings.HasSuffix(r.URL.Path, "/"+existingGroup) {
+			w.WriteHeader(http.StatusNotFound)
+			json.NewEncoder(w).Encode(map[string]string{"code": "NotAuthorizedOrNotFound", "message": "not found"})
+			return
+		}
+		json.NewEncoder(w).Encode(identity.Group{Id: common.String(existingGroup), Name: common.String("existing"), CompartmentId: common.String(testTenancyId)})
+	}))
+	defer server.Close()
+
+	config := logical.TestBackendConfig()
+	config.StorageView = &logical.InmemStorage{}
+	b, err := Backend()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Setup(context.Background(), config); err != nil {
+		t.Fatal(err)
+	}
+	b.identityClient = newTestIdentityClient(t, server.URL)
+
+	writeRole := func(roleData map[string]interface{}) *logical.Response {
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.CreateOperation,
+			Path:      "role/devrole",
+			Storage:   config.StorageView,
+			Data:      roleData,
+		})
+		if err != nil && err != logical.ErrInvalidRequest {
+			t.Fatal(err)
+		}
+		return resp
+	}
+
+	// Without a config, the realm can not be validated
+	resp := writeRole(map[string]interface{}{"ocid_list": "ocid1.group.oc2..group1"})
+	if resp == nil || resp.IsError() || len(resp.Warnings) != 1 {
+		t.Fatalf("expected a warning: %#v", resp)
+	}
+
+	if err := b.setOCIConfig(context.Background(), config.StorageView, &OCIConfigEntry{HomeTenancyId: testTenancyId}); err != nil {
+		t.Fatal(err)
+	}
+
+	invalidRoles := []map[string]interface{}{
+		{"ocid_list": "ocid1.group.oc2..group1"},
+		{"ocid_list": "ocid1.user.oc1..user1"},
+		{"ocid_list": "group1"},
+		{"bound_compartment_ids": "ocid1.instance.oc1.phx.instance1"},
+		{"bound_instance_ids": "ocid1.compartment.oc1..compartment1"},
+		{"group_policies": map[string]interface{}{"ocid1.tenancy.oc1..tenancy1": "admin"}},
+		{"bound_subject_ids": "ocid1.group.oc1..group1"},
+		{"denied_subject_ids": "ocid1.compartment.oc1..compartment1"},
+		{"denied_group_ids": "ocid1.user.oc1..user1"},
+		// A role that can never match is only stored if forced
+		{"ocid_list": ""},
+	}
+	for _, roleData := range invalidRoles {
+		if resp := writeRole(roleData); resp == nil || !resp.IsError() {
+			t.Fatalf("expected %v to be rejected: %#v", roleData, resp)
+		}
+	}
+
+	resp = writeRole(map[string]interface{}{"ocid_list": "", "force": true})
+	if resp == nil || resp.IsError() || len(resp.Warnings) != 1 {
+		t.Fatalf("expected a forced role to be stored with a warning: %#v", resp)
+	}
+
+	resp = writeRole(map[string]interface{}{
+		"ocid_list":             "ocid1.group.oc1..group1,ocid1.dynamicgroup.oc1..group2",
+		"bound_compartment_ids": testTenancyId,
+		"bound_instance_ids":    testInstanceId,
+		"denied_group_ids":      "ocid1.group.oc1..group3",
+	})
+	if resp != nil {
+		t.Fatalf("expected the role to be stored without warnings: %#v", resp)
+	}
+
+	// A role with only bound subjects can be taken by them
+	if resp := writeRole(map[string]interface{}{"ocid_list": "", "bound_subject_ids": "ocid1.user.oc1..user1"}); resp != nil {
+		t.Fatalf("expected the role to be stored without warnings: %#v", resp)
+	}
+
+	// Online validation checks that the groups exist in the home tenancy
+	if err := b.setOCIConfig(context.Background(), config.StorageView, &OCIConfigEntry{HomeTenancyId: testTenancyId, OnlineOCIDValidation: true}); err != nil {
+		t.Fatal(err)
+	}
+	if resp := writeRole(map[string]interface{}{"ocid_list": "ocid1.group.oc1..missing"}); resp == nil || !resp.IsError() {
+		t.Fatalf("expected a missing group to be rejected: %#v", resp)
+	}
+	if resp := writeRole(map[string]interface{}{"ocid_list": existingGroup}); resp != nil && resp.IsError() {
+		t.Fatalf("expected an existing group to be accepted: %#v", resp)
+	}
+}
@@ -0,0 +1,525 @@
+// Copyright © 2019, Oracle and/or its affiliates.
+package ociauth
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"sort"
+	"sync"
+	"time"
+
+	lru "github.com/hashicorp/golang-lru"
+	"github.com/hashicorp/vault/sdk/helper/consts"
+	"github.com/hashicorp/vault/sdk/helper/locksutil"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+// These constants store the defaults of the login ledger
+const (
+	// principalStoragePrefix is the storage prefix of the ledger entries, keyed on the subject OCID
+	principalStoragePrefix = "principal/"
+
+	// defaultPrincipalRetention is how long a principal is kept in the ledger after its last login
+	defaultPrincipalRetention = 30 * 24 * time.Hour
+
+	// principalTidyInterval is how often the periodic function removes the principals that did not log in within the
+	// retention
+	principalTidyInterval = time.Hour
+
+	// loginRecordInterval is how often the logins of a principal are written to its ledger entry while it keeps
+	// logging in with the same claims to the same roles, so that the ledger is not written on every login
+	loginRecordInterval = time.Minute
+
+	// pendingLoginsCacheSize is the number of principals whose logins are tracked in memory. The logins of a principal
+	// that was evicted are recorded by its next login.
+	pendingLoginsCacheSize = 10000
+)
+
+// principalEntry is the ledger entry of a principal that has logged in
+type principalEntry struct {
+	SubjectId     string    `json:"subject_id"`
+	PrincipalType string    `json:"principal_type"`
+	TenantId      string    `json:"tenant_id"`
+	LastRole      string    `json:"last_role"`
+	FirstLogin    time.Time `json:"first_login"`
+	LastLogin     time.Time `json:"last_login"`
+	LoginCount    int64     `json:"login_count"`
+
+	// RoleLogins is the time of the last login to each role whose policies were granted to the principal
+	RoleLogins map[string]time.Time `json:"role_logins"`
+
+	// Claims are the claims of the principal at its last login, used to recheck its group membership
+	Claims []Claim `json:"claims"`
+
+	// LostRoles is when a recheck found that the principal is no longer allowed to take each of the roles of its
+	// outstanding tokens. A role is removed by the next login to it.
+	LostRoles           map[string]time.Time `json:"lost_roles"`
+	MembershipCheckedAt time.Time            `json:"membership_checked_at"`
+
+	// ThrottledCount is the number of logins of the principal that were rejected by the login rate limits
+	ThrottledCount int64     `json:"throttled_count"`
+	LastThrottled  time.Time `json:"last_throttled"`
+
+	// LockoutCount is the number of times the principal was locked out after failed logins
+	LockoutCount int64     `json:"lockout_count"`
+	LastLockout  time.Time `json:"last_lockout"`
+}
+
+// principal returns the principal of the entry, as it was at its last login
+func (entry *principalEntry) principal() Principal {
+	principal := Principal{
+		SubjectId: &entry.SubjectId,
+		Claims:    entry.Claims,
+	}
+	if entry.TenantId != "" {
+		principal.TenantId = &entry.TenantId
+	}
+	return principal
+}
+
+// roleLogins returns the time of the last login to each role of the principal. The entries recorded before the logins
+// to each role were tracked only have the last role.
+func (entry *principalEntry) roleLogins() map[string]time.Time {
+	if len(entry.RoleLogins) > 0 {
+		return entry.RoleLogins
+	}
+	if entry.LastRole == "" {
+		return nil
+	}
+	return map[string]time.Time{entry.LastRole: entry.LastLogin}
+}
+
+// lostRole returns true if a recheck found that the principal is no longer allowed to take any of the roles
+func (entry *principalEntry) lostRole(roleNames []string) bool {
+	for _, roleName := range roleNames {
+		if _, lost := entry.LostRoles[roleName]; lost {
+			return true
+		}
+	}
+	return false
+}
+
+// formatTime formats a time of the ledger, or returns an empty string for a time that is not set
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.Format(time.RFC3339)
+}
+
+// responseData returns the ledger entry as the data of a read response
+func (entry *principalEntry) responseData() map[string]interface{} {
+	lostRoles := make(map[string]string, len(entry.LostRoles))
+	for roleName, lostAt := range entry.LostRoles {
+		lostRoles[roleName] = formatTime(lostAt)
+	}
+	return map[string]interface{}{
+		"subject_id":            entry.SubjectId,
+		"principal_type":        entry.PrincipalType,
+		"tenant_id":             entry.TenantId,
+		"last_role":             entry.LastRole,
+		"first_login":           formatTime(entry.FirstLogin),
+		"last_login":            formatTime(entry.LastLogin),
+		"login_count":           entry.LoginCount,
+		"membership_lost":       len(entry.LostRoles) > 0,
+		"lost_roles":            lostRoles,
+		"membership_checked_at": formatTime(entry.MembershipCheckedAt),
+		"throttled_count":       entry.ThrottledCount,
+		"last_throttled":        formatTime(entry.LastThrottled),
+		"lockout_count":         entry.LockoutCount,
+		"last_lockout":          formatTime(entry.LastLockout),
+	}
+}
+
+// pendingLogins are the logins of a principal that were not written to its ledger entry yet
+type pendingLogins struct {
+	// fingerprint identifies the principal, its claims and its roles at the last written login
+	fingerprint string
+
+	// recordedAt is when the logins of the principal were last written
+	recordedAt time.Time
+
+	count      int64
+	lastLogin  time.Time
+	roleLogins map[string]time.Time
+}
+
+// apply adds the pending logins to the ledger entry
+func (pending *pendingLogins) apply(entry *principalEntry) {
+	if pending == nil || pending.count == 0 {
+		return
+	}
+	entry.LoginCount += pending.count
+	if pending.lastLogin.After(entry.LastLogin) {
+		entry.LastLogin = pending.lastLogin
+	}
+	if entry.RoleLogins == nil {
+		entry.RoleLogins = make(map[string]time.Time)
+	}
+	for roleName, lastLogin := range pending.roleLogins {
+		if lastLogin.After(entry.RoleLogins[roleName]) {
+			entry.RoleLogins[roleName] = lastLogin
+		}
+	}
+}
+
+func (pending *pendingLogins) copy() *pendingLogins {
+	copied := *pending
+	copied.roleLogins = make(map[string]time.Time, len(pending.roleLogins))
+	for roleName, lastLogin := range pending.roleLogins {
+		copied.roleLogins[roleName] = lastLogin
+	}
+	return &copied
+}
+
+// principalLogins is a bounded LRU cache of the logins of each principal that were not written to the ledger yet
+type principalLogins struct {
+	lock sync.Mutex
+	lru  *lru.Cache
+}
+
+func newPrincipalLogins(size int) *principalLogins {
+	cache, _ := lru.New(size)
+	return &principalLogins{
+		lru: cache,
+	}
+}
+
+// deferLogin adds the login to the pending logins of the subject and returns true, unless the login has to be written
+// now because the logins of the subject were not written within the interval, or its fingerprint changed
+func (l *principalLogins) deferLogin(subjectId, fingerprint string, roleNames []string, now time.Time) bool {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	value, ok := l.lru.Get(subjectId)
+	if !ok {
+		return false
+	}
+	pending := value.(*pendingLogins)
+	if pending.fingerprint != fingerprint || now.Sub(pending.recordedAt) >= loginRecordInterval {
+		return false
+	}
+
+	pending.count++
+	pending.lastLogin = now
+	if pending.roleLogins == nil {
+		pending.roleLogins = make(map[string]time.Time)
+	}
+	for _, roleName := range roleNames {
+		pending.roleLogins[roleName] = now
+	}
+	return true
+}
+
+// take removes and returns the pending logins of the subject, so that they are written with its login
+func (l *principalLogins) take(subjectId string) *pendingLogins {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	value, ok := l.lru.Get(subjectId)
+	if !ok {
+		return nil
+	}
+	l.lru.Remove(subjectId)
+	return value.(*pendingLogins)
+}
+
+// recorded starts a new interval once a login of the subject with the fingerprint was written
+func (l *principalLogins) recorded(subjectId, fingerprint string, now time.Time) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	l.lru.Add(subjectId, &pendingLogins{
+		fingerprint: fingerprint,
+		recordedAt:  now,
+	})
+}
+
+// peek returns a copy of the pending logins of the subject, or nil if there are none
+func (l *principalLogins) peek(subjectId string) *pendingLogins {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	value, ok := l.lru.Peek(subjectId)
+	if !ok || value.(*pendingLogins).count == 0 {
+		return nil
+	}
+	return value.(*pendingLogins).copy()
+}
+
+// flush returns the pending logins of every subject that has some, and resets their count. The interval of the
+// subjects is not changed.
+func (l *principalLogins) flush() map[string]*pendingLogins {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	flushed := make(map[string]*pendingLogins)
+	for _, key := range l.lru.Keys() {
+		value, ok := l.lru.Peek(key)
+		if !ok || value.(*pendingLogins).count == 0 {
+			continue
+		}
+		pending := value.(*pendingLogins)
+		flushed[key.(string)] = pending.copy()
+		pending.count = 0
+		pending.roleLogins = nil
+	}
+	return flushed
+}
+
+// forget removes the pending logins of the subject, so that its next login is written
+func (l *principalLogins) forget(subjectId string) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	l.lru.Remove(subjectId)
+}
+
+// loginFingerprint identifies what a login writes to the ledger besides its time, so that a login that changes it is
+// written at once
+func loginFingerprint(attempt *loginAttempt) string {
+	roleNames := append([]string(nil), attempt.grantedRoles()...)
+	sort.Strings(roleNames)
+	// The claims are not in a stable order
+	claims := ledgerClaims(attempt.principal.Claims)
+	sort.Slice(claims, func(i, j int) bool {
+		return claimSortKey(claims[i]) < claimSortKey(claims[j])
+	})
+	recorded, _ := json.Marshal(struct {
+		PrincipalType string
+		TenantId      *string
+		RoleName      string
+		RoleNames     []string
+		Claims        []Claim
+	}{
+		PrincipalType: attempt.principalType,
+		TenantId:      attempt.principal.TenantId,
+		RoleName:      attempt.roleName,
+		RoleNames:     roleNames,
+		Claims:        claims,
+	})
+	sum := sha256.Sum256(recorded)
+	return hex.EncodeToString(sum[:])
+}
+
+// claimSortKey returns the key and the value of the claim, to sort the claims
+func claimSortKey(claim Claim) string {
+	var key, value string
+	if claim.Key != nil {
+		key = *claim.Key
+	}
+	if claim.Value != nil {
+		value = *claim.Value
+	}
+	return key + "\x00" + value
+}
+
+// ledgerWritable returns false on a performance standby. Its storage is read-only and the periodic function does not
+// run on it, so the logins, throttles and lockouts it handles are not recorded in the ledger.
+func (b *backend) ledgerWritable() bool {
+	return !b.System().ReplicationState().HasState(consts.ReplicationPerformanceStandby)
+}
+
+// recordPrincipalLogin updates the ledger entry of the principal of a successful login. The logins of a principal that
+// keeps logging in with the same claims to the same roles are written at most once per loginRecordInterval, and in the
+// meantime are kept in memory until its next written login or the periodic function.
+// The ledger is best effort: a login does not fail because it can not be recorded.
+func (b *backend) recordPrincipalLogin(ctx context.Context, req *logical.Request, attempt *loginAttempt) {
+	if attempt.principal == nil || attempt.principal.SubjectId == nil || *attempt.principal.SubjectId == "" || !b.ledgerWritable() {
+		return
+	}
+	subjectId := *attempt.principal.SubjectId
+
+	now := time.Now().UTC()
+	fingerprint := loginFingerprint(attempt)
+	if b.principalLogins.deferLogin(subjectId, fingerprint, attempt.grantedRoles(), now) {
+		return
+	}
+	pending := b.principalLogins.take(subjectId)
+
+	err := b.updatePrincipal(ctx, req.Storage, subjectId, func(entry *principalEntry) {
+		pending.apply(entry)
+		if entry.FirstLogin.IsZero() {
+			entry.FirstLogin = now
+		}
+		entry.PrincipalType = attempt.principalType
+		if attempt.principal.TenantId != nil {
+			entry.TenantId = *attempt.principal.TenantId
+		}
+		entry.LastRole = attempt.roleName
+		entry.LastLogin = now
+		if entry.RoleLogins == nil {
+			entry.RoleLogins = make(map[string]time.Time)
+		}
+		for _, roleName := range attempt.grantedRoles() {
+			entry.RoleLogins[roleName] = now
+		}
+		entry.LoginCount++
+		entry.Claims = ledgerClaims(attempt.principal.Claims)
+		// The login has checked the membership of its roles again
+		for _, roleName := range attempt.grantedRoles() {
+			delete(entry.LostRoles, roleName)
+		}
+	})
+	if err != nil {
+		b.Logger().Warn("Unable to record the login in the ledger", "id", req.ID, "subject", subjectId, "err", err)
+		return
+	}
+	b.principalLogins.recorded(subjectId, fingerprint, now)
+}
+
+// recordPendingLogins writes the logins that were kept in memory to the ledger entries of their principals
+func (b *backend) recordPendingLogins(ctx context.Context, s logical.Storage) error {
+	for subjectId, pending := range b.principalLogins.flush() {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		err := b.updatePrincipal(ctx, s, subjectId, pending.apply)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// recordPrincipalThrottles adds the throttled logins of the subject to its ledger entry. As for logins, the ledger is best effort.
+func (b *backend) recordPrincipalThrottles(ctx context.Context, req *logical.Request, subjectId string, throttled int64, now time.Time) {
+	if !b.ledgerWritable() {
+		return
+	}
+	err := b.updatePrincipal(ctx, req.Storage, subjectId, func(entry *principalEntry) {
+		entry.ThrottledCount += throttled
+		entry.LastThrottled = now.UTC()
+	})
+	if err != nil {
+		b.Logger().Warn("Unable to record the throttles in the ledger", "id", req.ID, "subject", subjectId, "err", err)
+	}
+}
+
+// ledgerClaims returns the claims of a principal without the claims that are not stored
+func ledgerClaims(claims []Claim) []Claim {
+	stored := make([]Claim, 0, len(claims))
+	for _, claim := range claims {
+		if claim.Key != nil && containsAny([]string{*claim.Key}, redactedClaims) {
+			continue
+		}
+		stored = append(stored, claim)
+	}
+	return stored
+}
+
+// updatePrincipal applies the update to the ledger entry of the subject, creating the entry if needed
+func (b *backend) updatePrincipal(ctx context.Context, s logical.Storage, subjectId string, update func(entry *principalEntry)) error {
+	lock := locksutil.LockForKey(b.principalLocks, subjectId)
+	lock.Lock()
+	defer lock.Unlock()
+
+	entry, err := getPrincipal(ctx, s, subjectId)
+	if err != nil {
+		return err
+	}
+	if entry == nil {
+		entry = &principalEntry{
+			SubjectId: subjectId,
+		}
+	}
+
+	update(entry)
+
+	storageEntry, err := logical.StorageEntryJSON(principalStoragePrefix+subjectId, entry)
+	if err != nil {
+		return err
+	}
+	return s.Put(ctx, storageEntry)
+}
+
+// getPrincipal returns the ledger entry of the subject, or nil if it never logged in
+func getPrincipal(ctx context.Context, s logical.Storage, subjectId string) (*principalEntry, error) {
+	storageEntry, err := s.Get(ctx, principalStoragePrefix+subjectId)
+	if err != nil || storageEntry == nil {
+		return nil, err
+	}
+
+	var entry principalEntry
+	if err := storageEntry.DecodeJSON(&entry); err != nil {
+		return nil, err
+	}
+	return &entry, nil
+}
+
+// tidyPrincipalsIfDue removes the principals that did not log in within the retention, if principalTidyInterval has
+// elapsed since the last tidy
+func (b *backend) tidyPrincipalsIfDue(ctx context.Context, s logical.Storage, now time.Time) error {
+	// A tidy that is still running is not started again
+	if !b.tidyMutex.TryLock() {
+		return nil
+	}
+	defer b.tidyMutex.Unlock()
+
+	if now.Sub(b.lastTidy) < principalTidyInterval {
+		return nil
+	}
+
+	configEntry, err := b.getOCIConfig(ctx, s)
+	if err != nil {
+		return err
+	}
+
+	removed, err := b.tidyPrincipals(ctx, s, configEntry.principalRetention())
+	if err != nil {
+		return err
+	}
+	b.lastTidy = now
+
+	b.Logger().Debug("tidied the login ledger", "removed", removed)
+
+	return nil
+}
+
+// tidyPrincipals removes the ledger entries of the principals whose last login is older than the retention.
+// It returns the number of entries removed.
+func (b *backend) tidyPrincipals(ctx context.Context, s logical.Storage, retention time.Duration) (int, error) {
+	subjectIds, err := s.List(ctx, principalStoragePrefix)
+	if err != nil {
+		return 0, err
+	}
+
+	cutoff := time.Now().Add(-retention)
+	removed := 0
+	for _, subjectId := range subjectIds {
+		if err := ctx.Err(); err != nil {
+			return removed, err
+		}
+
+		deleted, err := b.tidyPrincipal(ctx, s, subjectId, cutoff)
+		if err != nil {
+			return removed, err
+		}
+		if deleted {
+			removed++
+		}
+	}
+
+	return removed, nil
+}
+
+// tidyPrincipal removes the ledger entry of the subject if its last login is before the cutoff
+func (b *backend) tidyPrincipal(ctx context.Context, s logical.Storage, subjectId string, cutoff time.Time) (bool, error) {
+	lock := locksutil.LockForKey(b.principalLocks, subjectId)
+	lock.Lock()
+	defer lock.Unlock()
+
+	entry, err := getPrincipal(ctx, s, subjectId)
+	if err != nil || entry == nil {
+		return false, err
+	}
+	if !entry.LastLogin.Before(cutoff) {
+		return false, nil
+	}
+
+	b.principalLogins.forget(subjectId)
+	return true, s.Delete(ctx, principalStoragePrefix+subjectId)
+}
@@ -0,0 +1,195 @@
+// Copyright © 2019, Oracle and/or its affiliates.
+package ociauth
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/helper/consts"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+// readOnlyStorage is a storage that refuses writes, as on a performance standby
+type readOnlyStorage struct {
+	logical.Storage
+}
+
+func (s *readOnlyStorage) Put(ctx context.Context, entry *logical.StorageEntry) error {
+	return logical.ErrReadOnly
+}
+
+func TestBackend_PrincipalLedger(t *testing.T) {
+	b, config, login := newTestLocalLoginBackend(t, map[string]string{
+		"boundrole": testCompartmentId,
+		"otherrole": "ocid1.compartment.oc1..othercompartment",
+	})
+
+	request := func(operation logical.Operation, path string, data map[string]interface{}) *logical.Response {
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{
+			Operation: operation,
+			Path:      path,
+			Storage:   config.StorageView,
+			Data:      data,
+		})
+		if err != nil || (resp != nil && resp.IsError()) {
+			t.Fatalf("%s %s failed. resp:%#v\n err:%v", operation, path, resp, err)
+		}
+		return resp
+	}
+
+	before := time.Now().UTC().Truncate(time.Second)
+	login("boundrole")
+	login("boundrole")
+	// Failed logins are not recorded
+	login("otherrole")
+
+	resp := request(logical.ListOperation, "principals/", nil)
+	if !reflect.DeepEqual(resp.Data["keys"], []string{testInstanceId}) {
+		t.Fatalf("unexpected principals: %#v", resp.Data)
+	}
+
+	resp = request(logical.ReadOperation, "principals/"+testInstanceId, nil)
+	if resp.Data["subject_id"] != testInstanceId || resp.Data["principal_type"] != PrincipalTypeInstance ||
+		resp.Data["tenant_id"] != testTenancyId || resp.Data["last_role"] != "boundrole" || resp.Data["login_count"] != int64(2) {
+		t.Fatalf("unexpected principal: %#v", resp.Data)
+	}
+	firstLogin, err := time.Parse(time.RFC3339, resp.Data["first_login"].(string))
+	if err != nil || firstLogin.Before(before) {
+		t.Fatalf("unexpected first login: %#v err:%v", resp.Data["first_login"], err)
+	}
+
+	// The second login is kept in memory until the periodic function writes it
+	stored := func() *principalEntry {
+		entry, err := getPrincipal(context.Background(), config.StorageView, testInstanceId)
+		if err != nil || entry == nil {
+			t.Fatalf("expected a ledger entry: %#v err:%v", entry, err)
+		}
+		return entry
+	}
+	if entry := stored(); entry.LoginCount != 1 {
+		t.Fatalf("expected the second login not to be written: %#v", entry)
+	}
+	if err := b.periodicFunc(context.Background(), &logical.Request{Storage: config.StorageView}); err != nil {
+		t.Fatal(err)
+	}
+	if entry := stored(); entry.LoginCount != 2 || entry.LastLogin.Before(firstLogin) {
+		t.Fatalf("expected the second login to be written: %#v", entry)
+	}
+
+	if resp = request(logical.ReadOperation, "principals/ocid1.instance.oc1.phx.unknown", nil); resp != nil {
+		t.Fatalf("expected no principal: %#v", resp)
+	}
+
+	// Principals within the retention are kept
+	resp = request(logical.UpdateOperation, "principals/tidy", nil)
+	if resp.Data["principals_removed"] != 0 {
+		t.Fatalf("unexpected tidy: %#v", resp.Data)
+	}
+
+	err = b.updatePrincipal(context.Background(), config.StorageView, testInstanceId, func(entry *principalEntry) {
+		entry.LastLogin = time.Now().Add(-2 * time.Hour)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	request(logical.UpdateOperation, "config", map[string]interface{}{PrincipalRetentionConfigName: "1h"})
+	resp = request(logical.UpdateOperation, "principals/tidy", nil)
+	if resp.Data["principals_removed"] != 1 {
+		t.Fatalf("unexpected tidy: %#v", resp.Data)
+	}
+	if resp = request(logical.ListOperation, "principals/", nil); len(resp.Data) != 0 {
+		t.Fatalf("expected no principals: %#v", resp.Data)
+	}
+
+	// The periodic function tidies the ledger too
+	err = b.updatePrincipal(context.Background(), config.StorageView, testInstanceId, func(entry *principalEntry) {
+		entry.LastLogin = time.Now().Add(-2 * time.Hour)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := b.tidyPrincipalsIfDue(context.Background(), config.StorageView, time.Now().Add(principalTidyInterval)); err != nil {
+		t.Fatal(err)
+	}
+	if resp = request(logical.ListOperation, "principals/", nil); len(resp.Data) != 0 {
+		t.Fatalf("expected no principals: %#v", resp.Data)
+	}
+
+	// A login succeeds when it can not be recorded
+	config.StorageView = &readOnlyStorage{Storage: config.StorageView}
+	if resp = login("boundrole"); resp == nil || resp.Auth == nil {
+		t.Fatalf("expected login to succeed: %#v", resp)
+	}
+}
+
+func TestBackend_PrincipalLedgerDeferredLogins(t *testing.T) {
+	b, config, login := newTestLocalLoginBackend(t, map[string]string{
+		"boundrole": testCompartmentId,
+	})
+	stored := func() *principalEntry {
+		entry, err := getPrincipal(context.Background(), config.StorageView, testInstanceId)
+		if err != nil || entry == nil {
+			t.Fatalf("expected a ledger entry: %#v err:%v", entry, err)
+		}
+		return entry
+	}
+
+	// Only the first of the logins with the same claims to the same role is written
+	for i := 0; i < 3; i++ {
+		login("boundrole")
+	}
+	if entry := stored(); entry.LoginCount != 1 {
+		t.Fatalf("expected the later logins to be deferred: %#v", entry)
+	}
+	resp, err := b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      "principals/" + testInstanceId,
+		Storage:   config.StorageView,
+	})
+	if err != nil || resp == nil || resp.Data["login_count"] != int64(3) {
+		t.Fatalf("expected the read to include the deferred logins: %#v err:%v", resp, err)
+	}
+
+	// The deferred logins are written once
+	if err := b.recordPendingLogins(context.Background(), config.StorageView); err != nil {
+		t.Fatal(err)
+	}
+	if entry := stored(); entry.LoginCount != 3 {
+		t.Fatalf("expected the deferred logins to be written: %#v", entry)
+	}
+	if err := b.recordPendingLogins(context.Background(), config.StorageView); err != nil {
+		t.Fatal(err)
+	}
+	if entry := stored(); entry.LoginCount != 3 {
+		t.Fatalf("expected the deferred logins to be written once: %#v", entry)
+	}
+
+	// A deferred login that can not be written fails the flush
+	login("boundrole")
+	if err := b.recordPendingLogins(context.Background(), &readOnlyStorage{Storage: config.StorageView}); !errors.Is(err, logical.ErrReadOnly) {
+		t.Fatalf("expected the flush to fail: %v", err)
+	}
+}
+
+func TestBackend_PrincipalLedgerStandby(t *testing.T) {
+	b, config, login := newTestLocalLoginBackend(t, map[string]string{
+		"boundrole": testCompartmentId,
+	})
+	config.System.(*logical.StaticSystemView).ReplicationStateVal = consts.ReplicationPerformanceStandby
+
+	// A performance standby neither writes the logins nor keeps them for the periodic function
+	for i := 0; i < 2; i++ {
+		if resp := login("boundrole"); resp == nil || resp.Auth == nil {
+			t.Fatalf("expected login %d to succeed: %#v", i, resp)
+		}
+	}
+	if entry, err := getPrincipal(context.Background(), config.StorageView, testInstanceId); err != nil || entry != nil {
+		t.Fatalf("expected no ledger entry: %#v err:%v", entry, err)
+	}
+	if pending := b.principalLogins.peek(testInstanceId); pending != nil {
+		t.Fatalf("expected no deferred logins: %#v", pending)
+	}
+}
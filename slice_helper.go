@@ -32,3 +32,25 @@ func removeSliceFromMap(inputSlice []string, inputMap map[string]string) map[str
 	}
 	return inputMap
 }
+
+// containsAny returns true if any item of inputSlice is in items
+func containsAny(inputSlice []string, items []string) bool {
+	itemMap := sliceToMap(items)
+	for _, item := range inputSlice {
+		if _, present := itemMap[item]; present {
+			return true
+		}
+	}
+	return false
+}
+
+// countMissing returns the number of distinct items of inputSlice that are not in inputMap
+func countMissing(inputSlice []string, inputMap map[string]string) int {
+	missing := make(map[string]struct{})
+	for _, item := range inputSlice {
+		if _, present := inputMap[item]; !present {
+			missing[item] = struct{}{}
+		}
+	}
+	return len(missing)
+}
@@ -0,0 +1,69 @@
+// Copyright © 2019, Oracle and/or its affiliates.
+package ociauth
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/oracle/oci-go-sdk/v65/common"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracerName is the name of the tracer of the plugin. The spans are exported by the global
+// tracer provider, which does nothing unless the plugin process configures one.
+const tracerName = "github.com/hashicorp/vault-plugin-auth-oci"
+
+// These constants are the names of the spans of the login flow
+const (
+	spanLogin                 = "oci.login"
+	spanLoginHeaders          = "oci.login.headers"
+	spanLoginConfig           = "oci.login.config"
+	spanLoginRole             = "oci.login.role"
+	spanAuthenticateClient    = "oci.identity.authenticate_client"
+	spanFilterGroupMembership = "oci.identity.filter_group_membership"
+	spanLoginToken            = "oci.login.token"
+)
+
+// These constants are the attributes of the spans
+const (
+	attributeVaultRequestId = "vault.request_id"
+	attributeOpcRequestId   = "oci.opc_request_id"
+	attributeRole           = "oci.role"
+	attributePrincipalType  = "oci.principal_type"
+	attributeErrorCode      = "oci.error_code"
+	attributeGroupCount     = "oci.group_count"
+)
+
+// startSpan starts a span of the login flow, with the ID of the Vault request as an attribute
+func startSpan(ctx context.Context, requestId string, name string) (context.Context, trace.Span) {
+	return otel.Tracer(tracerName).Start(ctx, name, trace.WithAttributes(
+		attribute.String(attributeVaultRequestId, requestId),
+	))
+}
+
+// endSpan ends the span, recording the error if the stage failed
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// opcRequestId returns the ID that OCI Identity assigned to a request, so that it can be correlated
+// with Identity. The ID is read from the error if the request failed.
+func opcRequestId(opcRequestId *string, rawResponse *http.Response, err error) string {
+	if opcRequestId != nil {
+		return *opcRequestId
+	}
+	if serviceErr, ok := common.IsServiceError(err); ok {
+		return serviceErr.GetOpcRequestID()
+	}
+	if rawResponse != nil {
+		return rawResponse.Header.Get("opc-request-id")
+	}
+	return ""
+}
@@ -0,0 +1,128 @@
+// Copyright © 2019, Oracle and/or its affiliates.
+package ociauth
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/oracle/oci-go-sdk/v65/common"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace/noop"
+)
+
+// newTestSpanRecorder records the spans of the plugin for the duration of the test
+func newTestSpanRecorder(t *testing.T) *tracetest.SpanRecorder {
+	t.Helper()
+
+	recorder := tracetest.NewSpanRecorder()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	t.Cleanup(func() {
+		otel.SetTracerProvider(noop.NewTracerProvider())
+	})
+	return recorder
+}
+
+// spanAttributes returns the attributes of the span as strings
+func spanAttributes(span sdktrace.ReadOnlySpan) map[string]string {
+	attributes := make(map[string]string)
+	for _, kv := range span.Attributes() {
+		attributes[string(kv.Key)] = kv.Value.Emit()
+	}
+	return attributes
+}
+
+func TestBackend_LoginSpans(t *testing.T) {
+	recorder := newTestSpanRecorder(t)
+
+	_, _, login := newTestLocalLoginBackend(t, map[string]string{
+		"boundrole": testCompartmentId,
+		"otherrole": "ocid1.compartment.oc1..othercompartment",
+	})
+
+	login("boundrole")
+
+	var names []string
+	for _, span := range recorder.Ended() {
+		names = append(names, span.Name())
+		if attributes := spanAttributes(span); attributes[attributeVaultRequestId] != "requestid" {
+			t.Fatalf("expected the Vault request ID on span %s: %#v", span.Name(), attributes)
+		}
+	}
+	expected := []string{spanLoginRole, spanLoginHeaders, spanLoginConfig, spanLoginToken, spanLogin}
+	if !reflect.DeepEqual(names, expected) {
+		t.Fatalf("expected spans %v, got %v", expected, names)
+	}
+
+	loginSpan := recorder.Ended()[len(expected)-1]
+	for _, span := range recorder.Ended()[:len(expected)-1] {
+		if span.Parent().SpanID() != loginSpan.SpanContext().SpanID() {
+			t.Fatalf("expected span %s to be a child of the login span", span.Name())
+		}
+	}
+	if attributes := spanAttributes(loginSpan); attributes[attributeRole] != "boundrole" || attributes[attributePrincipalType] != PrincipalTypeInstance {
+		t.Fatalf("unexpected login span attributes: %#v", attributes)
+	}
+
+	// A failed login records its error code
+	login("otherrole")
+	ended := recorder.Ended()
+	failedSpan := ended[len(ended)-1]
+	if failedSpan.Name() != spanLogin || failedSpan.Status().Code != codes.Error ||
+		spanAttributes(failedSpan)[attributeErrorCode] != LoginErrorBindingFailed {
+		t.Fatalf("unexpected failed login span: %s %#v %#v", failedSpan.Name(), failedSpan.Status(), spanAttributes(failedSpan))
+	}
+
+	// The role of the request path is not recorded until the role is found
+	login("missingrole")
+	ended = recorder.Ended()
+	if attributes := spanAttributes(ended[len(ended)-1]); attributes[attributeRole] != metricUnknown || attributes[attributeErrorCode] != LoginErrorInvalidRole {
+		t.Fatalf("unexpected invalid role span attributes: %#v", attributes)
+	}
+}
+
+func TestFilterGroupMembership_Span(t *testing.T) {
+	recorder := newTestSpanRecorder(t)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var details FilterGroupMembershipDetails
+		if err := json.NewDecoder(r.Body).Decode(&details); err != nil {
+			t.Errorf("bad request body: %v", err)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("opc-request-id", "identityrequestid")
+		json.NewEncoder(w).Encode(FilterGroupMembershipResult{Principal: details.Principal, GroupIds: details.GroupIds})
+	}))
+	defer server.Close()
+
+	b, err := Backend()
+	if err != nil {
+		t.Fatal(err)
+	}
+	b.authenticationClient = newTestAuthenticationClient(t, server.URL)
+
+	principal := Principal{TenantId: common.String(testTenancyId), SubjectId: common.String(testInstanceId), Claims: []Claim{}}
+	if _, err := b.filterGroupMembershipChunk(context.Background(), "requestid", principal, []string{"ocid1.group.oc1..group"}); err != nil {
+		t.Fatal(err)
+	}
+
+	spans := recorder.Ended()
+	if len(spans) != 1 || spans[0].Name() != spanFilterGroupMembership {
+		t.Fatalf("unexpected spans: %#v", spans)
+	}
+	expected := map[string]string{
+		attributeVaultRequestId: "requestid",
+		attributeOpcRequestId:   "identityrequestid",
+		attributeGroupCount:     attribute.IntValue(1).Emit(),
+	}
+	if attributes := spanAttributes(spans[0]); !reflect.DeepEqual(attributes, expected) {
+		t.Fatalf("expected attributes %#v, got %#v", expected, attributes)
+	}
+}
@@ -0,0 +1,185 @@
+// Copyright © 2019, Oracle and/or its affiliates.
+package ociauth
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	lru "github.com/hashicorp/golang-lru"
+	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/oracle/oci-go-sdk/v65/common"
+	"github.com/oracle/oci-go-sdk/v65/identity"
+)
+
+// These constants store the defaults of the user cache
+const (
+	userCacheSize = 4096
+
+	// The email of a user is used to authorize its logins, so it is cached for a short time
+	userCacheTTL = 5 * time.Minute
+)
+
+// These constants are the claims of the attributes of a user looked up with Identity
+const (
+	userClaimPrefix = "user."
+
+	ClaimUserName               = "user.name"
+	ClaimUserEmail              = "user.email"
+	ClaimUserIdentityProviderId = "user.identity_provider_id"
+	ClaimUserExternalIdentifier = "user.external_identifier"
+)
+
+// userMetadataNames maps the claims of the attributes of a user to their token metadata names
+var userMetadataNames = map[string]string{
+	ClaimUserName:               "user_name",
+	ClaimUserEmail:              "user_email",
+	ClaimUserIdentityProviderId: "user_identity_provider_id",
+	ClaimUserExternalIdentifier: "user_external_identifier",
+}
+
+// userCacheEntry is the attributes of a user looked up through Identity
+type userCacheEntry struct {
+	name string
+
+	// email is only set if the email address of the user is verified
+	email string
+
+	// identityProviderId and externalIdentifier are set for the users federated from an identity provider
+	identityProviderId string
+	externalIdentifier string
+
+	expiry time.Time
+}
+
+func newUserCache() *lru.Cache {
+	cache, _ := lru.New(userCacheSize)
+	return cache
+}
+
+// addUserAttributes looks up the user of a user principal with Identity, if user_lookup is enabled, and adds its
+// attributes to the claims so that roles can be bound to them. Principals that are not users are left as they are.
+func (b *backend) addUserAttributes(ctx context.Context, requestId string, configEntry *OCIConfigEntry, principal *Principal, claims InternalClaims) error {
+	if !configEntry.userLookup() || claims.GetString(ClaimPrincipalType) != PrincipalTypeUser {
+		return nil
+	}
+	userId := principalSubjectId(principal)
+	if userId == "" {
+		return nil
+	}
+
+	user, err := b.getUser(ctx, requestId, userId)
+	if err != nil {
+		b.Logger().Warn("Unable to look up the user", "id", requestId, "user", userId, "err", err)
+		return err
+	}
+
+	for claim, value := range map[string]string{
+		ClaimUserName:               user.name,
+		ClaimUserEmail:              user.email,
+		ClaimUserIdentityProviderId: user.identityProviderId,
+		ClaimUserExternalIdentifier: user.externalIdentifier,
+	} {
+		if value != "" {
+			claims[claim] = []InternalClaim{{Key: claim, Value: value}}
+		}
+	}
+	return nil
+}
+
+// applyUserAttributes names the display name of the token after the user, and adds the attributes of the user to the
+// token metadata, if they were looked up. The name of a user can be changed and reused, so the alias is the OCID of
+// the user instead.
+func applyUserAttributes(claims InternalClaims, auth *logical.Auth) {
+	userName := claims.GetString(ClaimUserName)
+	if userName == "" {
+		return
+	}
+
+	auth.DisplayName = userName
+	if userId := claims.GetString(ClaimSubject); userId != "" {
+		auth.Alias.Name = userId
+	}
+	for claim, name := range userMetadataNames {
+		if value := claims.GetString(claim); value != "" {
+			auth.Metadata[name] = value
+		}
+	}
+}
+
+// getUser returns the attributes of the user with the given OCID, from the cache if possible
+func (b *backend) getUser(ctx context.Context, requestId string, userId string) (*userCacheEntry, error) {
+	if value, ok := b.userCache.Get(userId); ok {
+		entry := value.(*userCacheEntry)
+		if time.Now().Before(entry.expiry) {
+			emitCacheMetrics(metricCacheUser, true)
+			return entry, nil
+		}
+	}
+	emitCacheMetrics(metricCacheUser, false)
+
+	identityClient, err := b.getIdentityClient()
+	if err != nil {
+		return nil, err
+	}
+
+	response, err := identityClient.GetUser(ctx, identity.GetUserRequest{
+		UserId:       common.String(userId),
+		OpcRequestId: common.String(requestId),
+	})
+	if err != nil {
+		return nil, err
+	}
+	if response.Name == nil || *response.Name == "" {
+		return nil, fmt.Errorf("user %q has no name", userId)
+	}
+
+	entry := &userCacheEntry{
+		name:               *response.Name,
+		identityProviderId: stringValue(response.IdentityProviderId),
+		externalIdentifier: stringValue(response.ExternalIdentifier),
+		expiry:             time.Now().Add(userCacheTTL),
+	}
+	if response.EmailVerified != nil && *response.EmailVerified {
+		entry.email = strings.ToLower(stringValue(response.Email))
+	}
+	b.userCache.Add(userId, entry)
+
+	return entry, nil
+}
+
+// stringValue returns the value of an optional string of the OCI SDK, or an empty string if it is not set
+func stringValue(value *string) string {
+	if value == nil {
+		return ""
+	}
+	return *value
+}
+
+// userEmailBinding returns the binding of the role to the email addresses of users
+func (roleEntry *OCIRoleEntry) userEmailBinding() []roleBinding {
+	if len(roleEntry.BoundUserEmails) == 0 {
+		return nil
+	}
+	return []roleBinding{{
+		field:      "bound_user_emails",
+		claim:      ClaimUserEmail,
+		values:     roleEntry.BoundUserEmails,
+		glob:       true,
+		errMessage: "Entity does not have any of the Role email addresses",
+	}}
+}
+
+// parseUserEmails validates the patterns of bound_user_emails and returns them lowercased
+func parseUserEmails(patterns []string) ([]string, error) {
+	parsed := make([]string, 0, len(patterns))
+	for _, pattern := range patterns {
+		pattern = strings.ToLower(strings.TrimSpace(pattern))
+		if pattern == "" || pattern == "*" || !strings.Contains(pattern, "@") {
+			return nil, fmt.Errorf("invalid pattern %q in bound_user_emails, expected an email address such as *@example.com", pattern)
+		}
+		parsed = append(parsed, pattern)
+	}
+	return parsed, nil
+}
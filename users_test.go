@@ -0,0 +1,194 @@
+// Copyright © 2019, Oracle and/or its affiliates.
+package ociauth
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/oracle/oci-go-sdk/v65/common"
+	"github.com/oracle/oci-go-sdk/v65/identity"
+)
+
+func TestParseUserEmails(t *testing.T) {
+	emails, err := parseUserEmails([]string{"*@Example.com", " alice@example.org "})
+	if err != nil || !reflect.DeepEqual(emails, []string{"*@example.com", "alice@example.org"}) {
+		t.Fatalf("unexpected emails: %#v %v", emails, err)
+	}
+
+	for _, pattern := range []string{"", "*", "alice"} {
+		if _, err := parseUserEmails([]string{pattern}); err == nil {
+			t.Fatalf("expected %q to be rejected", pattern)
+		}
+	}
+}
+
+func TestBackend_UserLookup(t *testing.T) {
+	subjectId := "ocid1.user.oc1..alice"
+	var userRequests, failures int32
+	emailVerified := true
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&userRequests, 1)
+		if atomic.LoadInt32(&failures) > 0 {
+			// A status code that the SDK does not retry
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if !strings.HasSuffix(r.URL.Path, "/users/"+subjectId) {
+			t.Errorf("unexpected path: %s", r.URL.Path)
+		}
+		json.NewEncoder(w).Encode(identity.User{
+			Id:                 common.String(subjectId),
+			Name:               common.String("alice@example.com"),
+			Email:              common.String("Alice@Example.com"),
+			EmailVerified:      common.Bool(emailVerified),
+			IdentityProviderId: common.String("ocid1.saml2idp.oc1..idp"),
+			ExternalIdentifier: common.String("alice-external"),
+		})
+	}))
+	defer server.Close()
+
+	fake := newFakeIdentity(t)
+	b, config := initOfflineTest(t, fake, "ocid1.group.oc1..rolegroup")
+	b.identityClient = newTestIdentityClient(t, server.URL)
+
+	writeRole := func(name string, data map[string]interface{}) *logical.Response {
+		data["token_policies"] = "default"
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.CreateOperation,
+			Path:      "role/" + name,
+			Storage:   config.StorageView,
+			Data:      data,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp
+	}
+	login := func(roleName string) *logical.Response {
+		headers, keyId := signedAPIKeyHeaders(t, PathVersionBase+fmt.Sprintf(PathBaseFormat, "oci", roleName))
+		fake.addPrincipal(keyId, newFakePrincipal(subjectId, PrincipalTypeUser, nil))
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      "login/" + roleName,
+			Storage:   config.StorageView,
+			Data: map[string]interface{}{
+				"request_headers": headers,
+			},
+		})
+		if err != nil {
+			t.Fatalf("login failed: %v", err)
+		}
+		return resp
+	}
+
+	// The role warns that the emails are not known until the lookup is enabled, and can not be taken
+	resp := writeRole("emailrole", map[string]interface{}{"bound_user_emails": "*@example.com"})
+	if resp == nil || len(resp.Warnings) != 1 || !strings.Contains(resp.Warnings[0], UserLookupConfigName) {
+		t.Fatalf("expected a warning: %#v", resp)
+	}
+	if code, errorCode := loginErrorCode(t, login("emailrole")); code != http.StatusForbidden || errorCode != LoginErrorBindingFailed {
+		t.Fatalf("expected the binding to fail: %d %s", code, errorCode)
+	}
+	if atomic.LoadInt32(&userRequests) != 0 {
+		t.Fatalf("expected no user lookup: %d", userRequests)
+	}
+
+	resp, err := b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "config",
+		Storage:   config.StorageView,
+		Data:      map[string]interface{}{UserLookupConfigName: true},
+	})
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("Config update failed. resp:%#v\n err:%v", resp, err)
+	}
+
+	// The token is named after the user, its alias is the OCID of the user, and its metadata has the attributes of the user
+	for i := 0; i < 2; i++ {
+		resp = login("emailrole")
+		if resp == nil || resp.Auth == nil {
+			t.Fatalf("expected login to succeed: %#v", resp)
+		}
+	}
+	expectedMetadata := map[string]string{
+		"role_name":                 "emailrole",
+		"user_name":                 "alice@example.com",
+		"user_email":                "alice@example.com",
+		"user_identity_provider_id": "ocid1.saml2idp.oc1..idp",
+		"user_external_identifier":  "alice-external",
+	}
+	if !reflect.DeepEqual(resp.Auth.Metadata, expectedMetadata) {
+		t.Fatalf("unexpected metadata: %#v", resp.Auth.Metadata)
+	}
+	if resp.Auth.DisplayName != "alice@example.com" || resp.Auth.Alias.Name != subjectId {
+		t.Fatalf("unexpected names: %s %s", resp.Auth.DisplayName, resp.Auth.Alias.Name)
+	}
+	if atomic.LoadInt32(&userRequests) != 1 {
+		t.Fatalf("expected the user to be cached: %d", userRequests)
+	}
+
+	writeRole("otherrole", map[string]interface{}{"bound_user_emails": "bob@example.com,*@example.org"})
+	if code, errorCode := loginErrorCode(t, login("otherrole")); code != http.StatusForbidden || errorCode != LoginErrorBindingFailed {
+		t.Fatalf("expected the binding to fail: %d %s", code, errorCode)
+	}
+
+	// An email address that is not verified is not matched
+	emailVerified = false
+	b.userCache.Purge()
+	if code, errorCode := loginErrorCode(t, login("emailrole")); code != http.StatusForbidden || errorCode != LoginErrorBindingFailed {
+		t.Fatalf("expected the binding to fail: %d %s", code, errorCode)
+	}
+
+	// An email address carried by the principal is not trusted either
+	headers, keyId := signedAPIKeyHeaders(t, PathVersionBase+fmt.Sprintf(PathBaseFormat, "oci", "emailrole"))
+	fake.addPrincipal(keyId, newFakePrincipal(subjectId, PrincipalTypeUser, map[string]string{ClaimUserEmail: "alice@example.com"}))
+	resp, err = b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "login/emailrole",
+		Storage:   config.StorageView,
+		Data:      map[string]interface{}{"request_headers": headers},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if code, errorCode := loginErrorCode(t, resp); code != http.StatusForbidden || errorCode != LoginErrorBindingFailed {
+		t.Fatalf("expected the binding to fail: %d %s", code, errorCode)
+	}
+
+	// If the lookup fails, only the roles bound to emails can not be taken, and the token is named after the role
+	b.userCache.Purge()
+	atomic.StoreInt32(&failures, 1)
+	if code, errorCode := loginErrorCode(t, login("emailrole")); code != http.StatusServiceUnavailable || errorCode != LoginErrorIdentityUnavailable {
+		t.Fatalf("expected the lookup to fail: %d %s", code, errorCode)
+	}
+	writeRole("subjectrole", map[string]interface{}{"bound_subject_ids": subjectId})
+	resp = login("subjectrole")
+	if resp == nil || resp.Auth == nil {
+		t.Fatalf("expected login to succeed: %#v", resp)
+	}
+	if resp.Auth.DisplayName != "subjectrole" || resp.Auth.Alias.Name != "subjectrole" || resp.Auth.Metadata["user_name"] != "" {
+		t.Fatalf("unexpected auth: %#v", resp.Auth)
+	}
+	atomic.StoreInt32(&failures, 0)
+
+	resp, err = b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      "role/otherrole",
+		Storage:   config.StorageView,
+	})
+	if err != nil || resp == nil || resp.IsError() {
+		t.Fatalf("Role read failed. resp:%#v\n err:%v", resp, err)
+	}
+	if !reflect.DeepEqual(resp.Data["bound_user_emails"], []string{"bob@example.com", "*@example.org"}) {
+		t.Fatalf("unexpected role: %#v", resp.Data)
+	}
+}